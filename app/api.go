@@ -0,0 +1,493 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//APIVersion is the version of the HTTP API served by the mirror. It changes only when
+//the API changes incompatibly, new fields in responses do not change it
+const APIVersion = "v1"
+
+var apiVersions = []string{APIVersion}
+
+//APIVersionList is the response of /api, it tells which versions of the API the mirror serves
+type APIVersionList struct {
+	Versions []string `json:"versions"`
+	Kubemrr  string   `json:"kubemrr"`
+}
+
+//APIObject is an object in responses of the API
+type APIObject struct {
+	Kind            string            `json:"kind"`
+	Group           string            `json:"group,omitempty"`
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	//Containers and InitContainers are names of containers of pods
+	Containers     []string `json:"containers,omitempty"`
+	InitContainers []string `json:"initContainers,omitempty"`
+	//NodeName and Phase are spec.nodeName and status.phase of pods, Ready tells whether a pod has condition Ready
+	NodeName string `json:"nodeName,omitempty"`
+	Phase    string `json:"phase,omitempty"`
+	Ready    bool   `json:"ready,omitempty"`
+	//DeletionTimestamp is set when the object is being deleted
+	DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+}
+
+//APILabelList is the response of /api/v1/labels
+type APILabelList struct {
+	APIVersion string   `json:"apiVersion"`
+	Labels     []string `json:"labels"`
+}
+
+//APIObjectList is the response of /api/v1/objects
+type APIObjectList struct {
+	APIVersion string      `json:"apiVersion"`
+	Objects    []APIObject `json:"objects"`
+}
+
+//APIStatus is the response of /api/v1/status, it tells what the mirror is doing
+type APIStatus struct {
+	APIVersion string              `json:"apiVersion"`
+	Kubemrr    string              `json:"kubemrr"`
+	Started    time.Time           `json:"started"`
+	Resources  []APIResourceStatus `json:"resources"`
+}
+
+//APIResourceStatus is the state of a mirrored resource of a server
+type APIResourceStatus struct {
+	Server  string `json:"server"`
+	Group   string `json:"group,omitempty"`
+	Kind    string `json:"kind"`
+	Objects int    `json:"objects"`
+	//Stale is true while the objects are restored from the cache file and not confirmed by the server
+	Stale bool `json:"stale,omitempty"`
+	//Started is when the mirror started to mirror the resource
+	Started time.Time `json:"started"`
+	//LastSync is when the server last confirmed the objects
+	LastSync *time.Time `json:"lastSync,omitempty"`
+	//WatchStarted is when the current watch connection was opened, it is empty while the watch is not open
+	WatchStarted  *time.Time `json:"watchStarted,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+	Events        uint64     `json:"events"`
+	Reconnects    uint64     `json:"reconnects"`
+}
+
+//APIError is the response of the API when a request fails
+type APIError struct {
+	Error string `json:"error"`
+}
+
+func newAPIObject(o KubeObject) APIObject {
+	res := APIObject{
+		Kind:              o.Kind,
+		Group:             o.Group,
+		Name:              o.Name,
+		Namespace:         o.Namespace,
+		ResourceVersion:   o.ResourceVersion,
+		Labels:            o.Labels,
+		Annotations:       o.Annotations,
+		DeletionTimestamp: o.DeletionTimestamp,
+		Ready:             o.isReady(),
+	}
+	if o.Spec != nil {
+		res.NodeName = o.Spec.NodeName
+		for _, c := range o.Spec.Containers {
+			res.Containers = append(res.Containers, c.Name)
+		}
+		for _, c := range o.Spec.InitContainers {
+			res.InitContainers = append(res.InitContainers, c.Name)
+		}
+	}
+	if o.Status != nil {
+		res.Phase = o.Status.Phase
+	}
+	return res
+}
+
+func (o APIObject) kubeObject() KubeObject {
+	res := KubeObject{
+		TypeMeta: TypeMeta{Kind: o.Kind, Group: o.Group},
+		ObjectMeta: ObjectMeta{
+			Name:              o.Name,
+			Namespace:         o.Namespace,
+			ResourceVersion:   o.ResourceVersion,
+			Labels:            o.Labels,
+			Annotations:       o.Annotations,
+			DeletionTimestamp: o.DeletionTimestamp,
+		},
+	}
+	if o.NodeName != "" || len(o.Containers) > 0 || len(o.InitContainers) > 0 {
+		res.Spec = &PodSpec{NodeName: o.NodeName}
+		for _, name := range o.Containers {
+			res.Spec.Containers = append(res.Spec.Containers, Container{Name: name})
+		}
+		for _, name := range o.InitContainers {
+			res.Spec.InitContainers = append(res.Spec.InitContainers, Container{Name: name})
+		}
+	}
+	if o.Phase != "" || o.Ready {
+		res.Status = &PodStatus{Phase: o.Phase}
+	}
+	if o.Ready {
+		res.Status.Conditions = []PodCondition{{Type: podReady, Status: conditionTrue}}
+	}
+	return res
+}
+
+//NewMirrorHandler returns the handler of the HTTP API of the mirror:
+//  GET /api lists the supported versions of the API
+//  GET /api/v1/objects?kind=&server=&namespace=&word=&match=&limit=&labelSelector=&fieldSelector=&running= returns objects matching the filter
+//  GET /api/v1/labels?key=&kind=&server=&namespace=&word=&match=&limit=&labelSelector=&fieldSelector= returns keys of labels
+//  of the objects matching the filter, or values of the label with the key
+//  GET /api/v1/status returns the state of the mirrored resources whose objects the client may see in all namespaces
+//The state is also served as a table at /status, metrics in the Prometheus format at /metrics,
+//and health checks at /healthz and /readyz
+func NewMirrorHandler(c *MrrCache) http.Handler {
+	return NewAuthorizedMirrorHandler(c, allowAllRequests{})
+}
+
+//NewAuthorizedMirrorHandler returns the handler of the HTTP API of the mirror that returns
+//only the objects the client is allowed to see. Requests of unknown clients are rejected
+func NewAuthorizedMirrorHandler(c *MrrCache, ra RequestAuthorizer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, APIVersionList{Versions: apiVersions, Kubemrr: VERSION})
+	})
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, APIError{fmt.Sprintf(
+			"unsupported API path %s, the mirror serves API versions %s",
+			r.URL.Path, strings.Join(apiVersions, ", "),
+		)})
+	})
+	mux.HandleFunc("/api/"+APIVersion+"/objects", requireAuthorization(ra, func(w http.ResponseWriter, r *http.Request, a Authorizer) {
+		if r.Method != "GET" {
+			writeJSON(w, http.StatusMethodNotAllowed, APIError{"only GET is supported"})
+			return
+		}
+
+		f, err := filterFromQuery(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{err.Error()})
+			return
+		}
+
+		var objects []KubeObject
+		if err := c.Objects(r.Context(), f, a, &objects); err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{err.Error()})
+			return
+		}
+
+		res := APIObjectList{APIVersion: APIVersion, Objects: make([]APIObject, len(objects))}
+		for i, o := range objects {
+			res.Objects[i] = newAPIObject(o)
+		}
+		writeJSON(w, http.StatusOK, res)
+	}))
+	mux.HandleFunc("/api/"+APIVersion+"/labels", requireAuthorization(ra, func(w http.ResponseWriter, r *http.Request, a Authorizer) {
+		if r.Method != "GET" {
+			writeJSON(w, http.StatusMethodNotAllowed, APIError{"only GET is supported"})
+			return
+		}
+
+		f, err := filterFromQuery(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{err.Error()})
+			return
+		}
+
+		var labels []string
+		if err := c.Labels(r.Context(), f, r.URL.Query().Get("key"), a, &labels); err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, APILabelList{APIVersion: APIVersion, Labels: labels})
+	}))
+	mux.HandleFunc("/api/"+APIVersion+"/status", requireAuthorization(ra, func(w http.ResponseWriter, r *http.Request, a Authorizer) {
+		writeJSON(w, http.StatusOK, c.authorizedStatus(r.Context(), a))
+	}))
+	mux.HandleFunc("/status", requireAuthorization(ra, func(w http.ResponseWriter, r *http.Request, a Authorizer) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeStatusTable(w, c.authorizedStatus(r.Context(), a), time.Now())
+	}))
+	mux.HandleFunc("/metrics", requireAuthorization(ra, func(w http.ResponseWriter, r *http.Request, a Authorizer) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.writeMetrics(w, c.authorizedStatus(r.Context(), a))
+	}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := c.status()
+		if n := status.unsynced(); n > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "objects of %d of %d resources are not synced yet\n", n, len(status.Resources))
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return instrument(c.monitor, mux)
+}
+
+//filterFromQuery reads the filter from parameters of a request
+func filterFromQuery(q url.Values) (*MrrFilter, error) {
+	f := &MrrFilter{
+		Server:        q.Get("server"),
+		Namespace:     q.Get("namespace"),
+		Kind:          q.Get("kind"),
+		Word:          q.Get("word"),
+		Match:         q.Get("match"),
+		LabelSelector: q.Get("labelSelector"),
+		FieldSelector: q.Get("fieldSelector"),
+	}
+	if limit := q.Get("limit"); limit != "" {
+		var err error
+		if f.Limit, err = strconv.Atoi(limit); err != nil || f.Limit < 0 {
+			return nil, errors.New("limit must be a non-negative integer")
+		}
+	}
+	if running := q.Get("running"); running != "" {
+		var err error
+		if f.Running, err = strconv.ParseBool(running); err != nil {
+			return nil, errors.New("running must be true or false")
+		}
+	}
+	return f, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithField("error", err).Warn("could not write response")
+	}
+}
+
+type MrrClient interface {
+	Objects(f MrrFilter) ([]KubeObject, error)
+	Labels(f MrrFilter, key string) ([]string, error)
+	Version() (APIVersionList, error)
+	Status() (*APIStatus, error)
+}
+
+//MrrClientDefault queries the mirror over its HTTP API
+type MrrClientDefault struct {
+	address string
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+//NewMrrClient creates client of the mirror at the given endpoint
+func NewMrrClient(e MirrorEndpoint) (*MrrClientDefault, error) {
+	tlsConfig, err := e.clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	token, err := e.token()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http://"
+	if e.TLS {
+		scheme = "https://"
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	mc := &MrrClientDefault{
+		address: e.Address,
+		baseURL: scheme + e.Address,
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+
+	switch e.Network {
+	case "tcp":
+	case "unix":
+		//the host is ignored, all connections go to the socket
+		mc.baseURL = scheme + "kubemrr"
+		transport.DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", e.Address)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported network %s", e.Network)
+	}
+	return mc, nil
+}
+
+//filterQuery returns parameters of a request with the filter
+func filterQuery(f MrrFilter) url.Values {
+	q := url.Values{}
+	q.Set("kind", f.Kind)
+	for name, value := range map[string]string{
+		"server":        f.Server,
+		"namespace":     f.Namespace,
+		"word":          f.Word,
+		"match":         f.Match,
+		"labelSelector": f.LabelSelector,
+		"fieldSelector": f.FieldSelector,
+	} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	if f.Limit > 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+	if f.Running {
+		q.Set("running", "true")
+	}
+	return q
+}
+
+func (mc *MrrClientDefault) Objects(f MrrFilter) ([]KubeObject, error) {
+	var list APIObjectList
+	if err := mc.get("/api/"+APIVersion+"/objects?"+filterQuery(f).Encode(), &list); err != nil {
+		return nil, err
+	}
+	if list.APIVersion != APIVersion {
+		return nil, mc.incompatible()
+	}
+
+	res := make([]KubeObject, len(list.Objects))
+	for i, o := range list.Objects {
+		res[i] = o.kubeObject()
+	}
+	return res, nil
+}
+
+//Labels returns keys of labels of the objects matching the filter, or values of the label with the key
+func (mc *MrrClientDefault) Labels(f MrrFilter, key string) ([]string, error) {
+	q := filterQuery(f)
+	if key != "" {
+		q.Set("key", key)
+	}
+
+	var list APILabelList
+	if err := mc.get("/api/"+APIVersion+"/labels?"+q.Encode(), &list); err != nil {
+		return nil, err
+	}
+	if list.APIVersion != APIVersion {
+		return nil, mc.incompatible()
+	}
+	return list.Labels, nil
+}
+
+//Version returns the versions of the API and of kubemrr served by the mirror
+func (mc *MrrClientDefault) Version() (APIVersionList, error) {
+	var versions APIVersionList
+	err := mc.get("/api", &versions)
+	return versions, err
+}
+
+//Status returns the state of the resources mirrored by the mirror
+func (mc *MrrClientDefault) Status() (*APIStatus, error) {
+	var status APIStatus
+	if err := mc.get("/api/"+APIVersion+"/status", &status); err != nil {
+		return nil, err
+	}
+	if status.APIVersion != APIVersion {
+		return nil, mc.incompatible()
+	}
+	return &status, nil
+}
+
+func (mc *MrrClientDefault) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", mc.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if mc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+mc.token)
+	}
+
+	resp, err := mc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr APIError
+		if err := json.Unmarshal(raw, &apiErr); err == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		if strings.Contains(string(raw), "HTTP request to an HTTPS server") {
+			return fmt.Errorf("mirror at %s is served over HTTPS, use --tls", mc.address)
+		}
+		return mc.incompatible()
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return mc.incompatible()
+	}
+	return nil
+}
+
+//incompatible explains why the mirror does not understand the client
+func (mc *MrrClientDefault) incompatible() error {
+	resp, err := mc.client.Get(mc.baseURL + "/api")
+	if err == nil {
+		defer resp.Body.Close()
+		var versions APIVersionList
+		if err := json.NewDecoder(resp.Body).Decode(&versions); err == nil && len(versions.Versions) > 0 {
+			return fmt.Errorf(
+				"mirror at %s runs kubemrr %s with API versions %s, but kubemrr %s requires API %s; run the same version of kubemrr for watch and get",
+				mc.address, versions.Kubemrr, strings.Join(versions.Versions, ", "), VERSION, APIVersion,
+			)
+		}
+	}
+	return fmt.Errorf(
+		"mirror at %s does not serve API %s, it probably runs an older version of kubemrr; restart it with kubemrr %s",
+		mc.address, APIVersion, VERSION,
+	)
+}
+
+type TestMirrorClient struct {
+	err        error
+	lastFilter MrrFilter
+	lastKey    string
+	objects    []KubeObject
+	labels     []string
+	status     *APIStatus
+}
+
+func (mc *TestMirrorClient) Objects(f MrrFilter) ([]KubeObject, error) {
+	mc.lastFilter = f
+	return mc.objects, mc.err
+}
+
+func (mc *TestMirrorClient) Labels(f MrrFilter, key string) ([]string, error) {
+	mc.lastFilter = f
+	mc.lastKey = key
+	return mc.labels, mc.err
+}
+
+func (mc *TestMirrorClient) Version() (APIVersionList, error) {
+	return APIVersionList{Versions: apiVersions, Kubemrr: VERSION}, mc.err
+}
+
+func (mc *TestMirrorClient) Status() (*APIStatus, error) {
+	return mc.status, mc.err
+}
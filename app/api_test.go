@@ -0,0 +1,138 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMirrorClient(server *httptest.Server) *MrrClientDefault {
+	client, _ := NewMrrClient(MirrorEndpoint{Network: "tcp", Address: strings.TrimPrefix(server.URL, "http://")})
+	return client
+}
+
+func TestMirrorHandler(t *testing.T) {
+	c := NewMrrCache()
+	c.updateKubeObject(KubeServer{"http://a.com"}, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1", Namespace: "ns1", ResourceVersion: "3", Labels: map[string]string{"app": "web"}},
+		Spec:   &PodSpec{NodeName: "n1", Containers: []Container{{Name: "c1"}}, InitContainers: []Container{{Name: "i1"}}},
+		Status: &PodStatus{Phase: "Running", Conditions: []PodCondition{{Type: "Ready", Status: "True"}}}})
+	deleted := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	c.updateKubeObject(KubeServer{"http://a.com"}, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p2", Namespace: "ns1", DeletionTimestamp: &deleted},
+		Status: &PodStatus{Phase: "Running"}})
+	server := httptest.NewServer(NewMirrorHandler(c))
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		code     int
+		expected string
+	}{
+		{"/api", 200, `{"versions":["v1"],"kubemrr":"` + VERSION + `"}`},
+		{"/api/v1/objects?kind=po&running=true", 200, `{"apiVersion":"v1","objects":[{"kind":"pod","name":"p1","namespace":"ns1","resourceVersion":"3","labels":{"app":"web"},"containers":["c1"],"initContainers":["i1"],"nodeName":"n1","phase":"Running","ready":true}]}`},
+		{"/api/v1/objects?kind=po&word=p2", 200, `{"apiVersion":"v1","objects":[{"kind":"pod","name":"p2","namespace":"ns1","phase":"Running","deletionTimestamp":"2026-10-17T10:00:00Z"}]}`},
+		{"/api/v1/objects?kind=po&running=yes", 400, `{"error":"running must be true or false"}`},
+		{"/api/v1/objects?kind=po&word=x", 200, `{"apiVersion":"v1","objects":[]}`},
+		{"/api/v1/objects?kind=po&labelSelector=app%3Ddb", 200, `{"apiVersion":"v1","objects":[]}`},
+		{"/api/v1/objects?kind=po&fieldSelector=status.phase%3DPending", 200, `{"apiVersion":"v1","objects":[]}`},
+		{"/api/v1/objects?kind=po&fieldSelector=status.podIP", 400, `{"error":"invalid field selector \"status.podIP\": \"status.podIP\" is not in the form field=value"}`},
+		{"/api/v1/objects?kind=po&labelSelector=app%3D%3D%3D", 400, `{"error":"invalid label selector \"app===\": invalid label value \"=\""}`},
+		{"/api/v1/labels?kind=po", 200, `{"apiVersion":"v1","labels":["app"]}`},
+		{"/api/v1/labels?kind=po&key=app&word=w", 200, `{"apiVersion":"v1","labels":["web"]}`},
+		{"/api/v1/labels?kind=po&limit=x", 400, `{"error":"limit must be a non-negative integer"}`},
+		{"/api/v1/objects?kind=unknown", 400, `{"error":"Unsupported resource type unknown"}`},
+		{"/api/v1/objects?kind=po&limit=-1", 400, `{"error":"limit must be a non-negative integer"}`},
+		{"/api/v2/objects?kind=po", 404, `{"error":"unsupported API path /api/v2/objects, the mirror serves API versions v1"}`},
+	}
+
+	for _, test := range tests {
+		resp, err := http.Get(server.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&actual)
+		resp.Body.Close()
+
+		assert.Equal(t, test.code, resp.StatusCode, test.path)
+		assert.Equal(t, test.expected, string(actual), test.path)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), test.path)
+	}
+
+	objects, err := newTestMirrorClient(server).Objects(MrrFilter{Kind: "pod", Running: true})
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(objects)) {
+		assert.Equal(t, []string{"c1", "i1"}, objects[0].containerNames(), "client must receive containers")
+		assert.Equal(t, map[string]string{"app": "web"}, objects[0].Labels)
+		assert.Equal(t, "n1", objects[0].Spec.NodeName)
+		assert.Equal(t, "Running", objects[0].Status.Phase)
+		assert.True(t, objects[0].isReady(), "client must receive readiness")
+	}
+
+	objects, err = newTestMirrorClient(server).Objects(MrrFilter{Kind: "pod", Word: "p2"})
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(objects)) {
+		assert.Equal(t, deleted, objects[0].DeletionTimestamp.UTC(), "client must receive deletion timestamp")
+	}
+
+	objects, err = newTestMirrorClient(server).Objects(MrrFilter{Kind: "pod", FieldSelector: "spec.nodeName=n2"})
+	if assert.NoError(t, err) {
+		assert.Empty(t, objects, "client must send the field selector")
+	}
+
+	labels, err := newTestMirrorClient(server).Labels(MrrFilter{Kind: "pod", LabelSelector: "app=web"}, "app")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"web"}, labels)
+	}
+}
+
+func TestMirrorClientErrors(t *testing.T) {
+	c := NewMrrCache()
+	c.updateKubeObject(KubeServer{"http://a.com"}, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1"}})
+	server := httptest.NewServer(NewMirrorHandler(c))
+	defer server.Close()
+
+	_, err := newTestMirrorClient(server).Objects(MrrFilter{Kind: "pod", Server: "http://b.com"})
+	if assert.Error(t, err) {
+		assert.Equal(t, "Unknown server http://b.com", err.Error(), "must pass error of the mirror")
+	}
+}
+
+func TestMirrorClientIncompatibleServer(t *testing.T) {
+	tests := []struct {
+		handler  http.HandlerFunc
+		complain string
+	}{
+		{
+			handler:  http.NotFound,
+			complain: "does not serve API v1, it probably runs an older version of kubemrr",
+		},
+		{
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api" {
+					w.Write([]byte(`{"versions":["v2"],"kubemrr":"2.0.0"}`))
+					return
+				}
+				http.NotFound(w, r)
+			},
+			complain: "runs kubemrr 2.0.0 with API versions v2, but kubemrr " + VERSION + " requires API v1",
+		},
+		{
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"apiVersion":"v2","objects":[]}`))
+			},
+			complain: "does not serve API v1",
+		},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(test.handler)
+		_, err := newTestMirrorClient(server).Objects(MrrFilter{Kind: "pod"})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), test.complain)
+		}
+		server.Close()
+	}
+}
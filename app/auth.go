@@ -0,0 +1,153 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//tokenSource provides bearer tokens for requests to API server
+type tokenSource interface {
+	token() (string, error)
+}
+
+//refreshableTokenSource is a token source whose token can be rejected by API server
+//before it expires, e.g. when the credentials are revoked
+type refreshableTokenSource interface {
+	tokenSource
+	invalidate()
+}
+
+type staticToken string
+
+func (t staticToken) token() (string, error) {
+	return string(t), nil
+}
+
+//tokenFileTTL is how long a token read from a file is used before the file is read again.
+//Tokens of service accounts are rotated, so the file cannot be read only once
+const tokenFileTTL = time.Minute
+
+type fileToken struct {
+	path string
+
+	mu     *sync.Mutex
+	value  string
+	readAt time.Time
+}
+
+func newFileToken(path string) *fileToken {
+	return &fileToken{path: path, mu: &sync.Mutex{}}
+}
+
+func (t *fileToken) token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.value != "" && time.Since(t.readAt) < tokenFileTTL {
+		return t.value, nil
+	}
+
+	raw, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return "", fmt.Errorf("could not read token file %s: %s", t.path, err)
+	}
+	t.value = strings.TrimSpace(string(raw))
+	t.readAt = time.Now()
+	return t.value, nil
+}
+
+func (t *fileToken) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.value = ""
+}
+
+//newTokenSource returns the source of bearer tokens configured for the user, or nil if
+//the user does not authenticate with tokens. As in kubectl, the token takes precedence
+//over the token file, the auth provider and the exec plugin
+func newTokenSource(u User, exec *execCredentials) (tokenSource, error) {
+	switch {
+	case u.Token != "":
+		return staticToken(u.Token), nil
+	case u.TokenFile != "":
+		return newFileToken(u.TokenFile), nil
+	case u.AuthProvider != nil:
+		return newAuthProvider(u.AuthProvider)
+	case exec != nil:
+		return exec, nil
+	default:
+		return nil, nil
+	}
+}
+
+//authTransport adds credentials of the user to each request
+type authTransport struct {
+	base     http.RoundTripper
+	tokens   tokenSource
+	username string
+	password string
+}
+
+//newAuthTransport wraps the transport so that requests are authenticated as the given user.
+//Client certificates are configured in the transport itself
+func newAuthTransport(u User, exec *execCredentials, base http.RoundTripper) (http.RoundTripper, error) {
+	tokens, err := newTokenSource(u, exec)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokens == nil && u.Username == "" {
+		return base, nil
+	}
+
+	return &authTransport{
+		base:     base,
+		tokens:   tokens,
+		username: u.Username,
+		password: u.Password,
+	}, nil
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	r := cloneRequest(req)
+	if t.tokens != nil {
+		token, err := t.tokens.token()
+		if err != nil {
+			return nil, fmt.Errorf("could not get token: %s", err)
+		}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	} else {
+		r.SetBasicAuth(t.username, t.password)
+	}
+
+	res, err := t.base.RoundTrip(r)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		//the next request will get a fresh token
+		if rts, ok := t.tokens.(refreshableTokenSource); ok {
+			rts.invalidate()
+		}
+	}
+	return res, err
+}
+
+//cloneRequest returns a copy of the request with deep copy of the headers,
+//because RoundTripper must not modify the given request
+func cloneRequest(req *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	return r
+}
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+//newAuthProvider returns the token source of the auth provider in kubeconfig.
+//The refreshed tokens are kept in memory, kubeconfig is never written
+func newAuthProvider(c *AuthProviderConfig) (tokenSource, error) {
+	switch c.Name {
+	case "gcp":
+		return newGCPToken(c.Config), nil
+	case "oidc":
+		return newOIDCToken(c.Config)
+	default:
+		if t := c.Config["access-token"]; t != "" {
+			return staticToken(t), nil
+		}
+		return nil, fmt.Errorf("unsupported auth provider %s", c.Name)
+	}
+}
+
+//gcpToken uses the access token cached in kubeconfig, and runs "cmd-path" to get a new one when it expires
+type gcpToken struct {
+	cmdPath   string
+	cmdArgs   []string
+	tokenKey  string
+	expiryKey string
+
+	mu     *sync.Mutex
+	value  string
+	expiry time.Time
+	now    func() time.Time
+}
+
+func newGCPToken(config map[string]string) *gcpToken {
+	t := &gcpToken{
+		cmdPath:   config["cmd-path"],
+		cmdArgs:   strings.Fields(config["cmd-args"]),
+		tokenKey:  config["token-key"],
+		expiryKey: config["expiry-key"],
+		value:     config["access-token"],
+		mu:        &sync.Mutex{},
+		now:       time.Now,
+	}
+	if t.tokenKey == "" {
+		t.tokenKey = "{.access_token}"
+	}
+	if t.expiryKey == "" {
+		t.expiryKey = "{.token_expiry}"
+	}
+	t.expiry, _ = time.Parse(time.RFC3339, config["expiry"])
+	return t
+}
+
+func (t *gcpToken) token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.value != "" && t.now().Before(t.expiry) {
+		return t.value, nil
+	}
+
+	if t.cmdPath == "" {
+		if t.value != "" && t.expiry.IsZero() {
+			return t.value, nil
+		}
+		return "", fmt.Errorf("gcp access token has expired and cmd-path is not configured")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(t.cmdPath, t.cmdArgs...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("could not run %s: %s %s", t.cmdPath, err, stderr.String())
+	}
+
+	var output interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return "", fmt.Errorf("could not parse output of %s: %s", t.cmdPath, err)
+	}
+
+	value, ok := lookupJSONPath(output, t.tokenKey)
+	if !ok || value == "" {
+		return "", fmt.Errorf("output of %s has no token at %s", t.cmdPath, t.tokenKey)
+	}
+	expiry, _ := lookupJSONPath(output, t.expiryKey)
+
+	t.value = value
+	t.expiry, _ = time.Parse(time.RFC3339, expiry)
+	return t.value, nil
+}
+
+func (t *gcpToken) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cmdPath != "" {
+		t.value = ""
+	}
+}
+
+//lookupJSONPath supports the subset of JSONPath used in kubeconfig, e.g. {.credential.access_token}
+func lookupJSONPath(v interface{}, path string) (string, bool) {
+	path = strings.TrimSuffix(strings.TrimPrefix(path, "{"), "}")
+	for _, key := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		v, ok = m[key]
+		if !ok {
+			return "", false
+		}
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+//oidcToken uses the ID token cached in kubeconfig, and refreshes it with the refresh token
+//at the token endpoint of the issuer when it expires
+type oidcToken struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	mu           *sync.Mutex
+	idToken      string
+	refreshToken string
+	now          func() time.Time
+}
+
+func newOIDCToken(config map[string]string) (*oidcToken, error) {
+	t := &oidcToken{
+		issuer:       config["idp-issuer-url"],
+		clientID:     config["client-id"],
+		clientSecret: config["client-secret"],
+		client:       &http.Client{Timeout: 30 * time.Second},
+		mu:           &sync.Mutex{},
+		idToken:      config["id-token"],
+		refreshToken: config["refresh-token"],
+		now:          time.Now,
+	}
+
+	if t.idToken == "" && (t.refreshToken == "" || t.issuer == "") {
+		return nil, fmt.Errorf("oidc auth provider needs id-token, or refresh-token with idp-issuer-url")
+	}
+	return t, nil
+}
+
+func (t *oidcToken) token() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.idToken != "" && !isExpiredJWT(t.idToken, t.now()) {
+		return t.idToken, nil
+	}
+
+	if t.refreshToken == "" || t.issuer == "" {
+		return "", fmt.Errorf("oidc id token has expired and cannot be refreshed")
+	}
+
+	if err := t.refresh(); err != nil {
+		return "", fmt.Errorf("could not refresh oidc id token: %s", err)
+	}
+	return t.idToken, nil
+}
+
+func (t *oidcToken) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.refreshToken != "" && t.issuer != "" {
+		t.idToken = ""
+	}
+}
+
+//refresh asks the issuer for a new ID token. Must be called under lock
+func (t *oidcToken) refresh() error {
+	var discovery struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err := t.getJSON(strings.TrimSuffix(t.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", t.refreshToken)
+	form.Set("client_id", t.clientID)
+	if t.clientSecret != "" {
+		form.Set("client_secret", t.clientSecret)
+	}
+	res, err := t.client.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status of %s: %s", discovery.TokenEndpoint, res.Status)
+	}
+
+	var tokens struct {
+		IDToken      string `json:"id_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return err
+	}
+	if tokens.IDToken == "" {
+		return fmt.Errorf("%s returned no id_token", discovery.TokenEndpoint)
+	}
+
+	t.idToken = tokens.IDToken
+	if tokens.RefreshToken != "" {
+		t.refreshToken = tokens.RefreshToken
+	}
+	return nil
+}
+
+func (t *oidcToken) getJSON(url string, v interface{}) error {
+	res, err := t.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status of %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+//isExpiredJWT tells whether the "exp" claim of the token is in the past.
+//Tokens which cannot be parsed are considered valid, API server will decide
+func isExpiredJWT(token string, now time.Time) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return false
+	}
+
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return false
+	}
+
+	//refresh a bit earlier, so that the token does not expire on the way to API server
+	return now.Add(10 * time.Second).After(time.Unix(int64(claims.Exp), 0))
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func jwt(exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	return "header." + payload + ".signature"
+}
+
+func TestIsExpiredJWT(t *testing.T) {
+	now := time.Now()
+	assert.False(t, isExpiredJWT(jwt(now.Add(time.Hour)), now))
+	assert.True(t, isExpiredJWT(jwt(now.Add(-time.Hour)), now))
+	assert.False(t, isExpiredJWT("opaque", now))
+}
+
+func TestOIDCToken(t *testing.T) {
+	valid := jwt(time.Now().Add(time.Hour))
+	var form map[string]string
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"token_endpoint": "%s/token"}`, ts.URL)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		fmt.Fprintf(w, `{"id_token": "%s", "refresh_token": "next"}`, valid)
+	})
+
+	ot, err := newOIDCToken(map[string]string{
+		"id-token":       jwt(time.Now().Add(-time.Hour)),
+		"refresh-token":  "first",
+		"idp-issuer-url": ts.URL,
+		"client-id":      "kubemrr",
+		"client-secret":  "secret",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	token, err := ot.token()
+	assert.Nil(t, err)
+	assert.Equal(t, valid, token)
+	assert.Equal(t, map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": "first",
+		"client_id":     "kubemrr",
+		"client_secret": "secret",
+	}, form)
+	assert.Equal(t, "next", ot.refreshToken)
+
+	form = nil
+	token, _ = ot.token()
+	assert.Equal(t, valid, token)
+	assert.Nil(t, form, "valid token must not be refreshed")
+}
+
+func TestOIDCTokenWithoutRefresh(t *testing.T) {
+	_, err := newOIDCToken(map[string]string{"refresh-token": "first"})
+	assert.NotNil(t, err)
+
+	ot, _ := newOIDCToken(map[string]string{"id-token": jwt(time.Now().Add(-time.Hour))})
+	_, err = ot.token()
+	assert.NotNil(t, err)
+}
+
+func TestGCPToken(t *testing.T) {
+	gt := newGCPToken(map[string]string{
+		"access-token": "cached",
+		"expiry":       "2020-01-01T10:00:00Z",
+		"cmd-path":     "echo",
+		"cmd-args":     `{"credential":{"access_token":"fresh","token_expiry":"2020-01-01T12:00:00Z"}}`,
+		"token-key":    "{.credential.access_token}",
+		"expiry-key":   "{.credential.token_expiry}",
+	})
+	now := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	gt.now = func() time.Time { return now }
+
+	token, err := gt.token()
+	assert.Nil(t, err)
+	assert.Equal(t, "cached", token)
+
+	now = time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)
+	token, err = gt.token()
+	assert.Nil(t, err)
+	assert.Equal(t, "fresh", token)
+	assert.Equal(t, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), gt.expiry)
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	v := map[string]interface{}{"a": map[string]interface{}{"b": "c", "n": 1.0}}
+
+	s, ok := lookupJSONPath(v, "{.a.b}")
+	assert.True(t, ok)
+	assert.Equal(t, "c", s)
+
+	_, ok = lookupJSONPath(v, "{.a.n}")
+	assert.False(t, ok)
+	_, ok = lookupJSONPath(v, "{.x.b}")
+	assert.False(t, ok)
+}
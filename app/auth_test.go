@@ -0,0 +1,147 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTokenSourcePrecedence(t *testing.T) {
+	exec := newExecCredentials(&ExecConfig{Command: "true"})
+	tests := []struct {
+		user     User
+		expected interface{}
+	}{
+		{User{}, nil},
+		{User{Token: "t", TokenFile: "f"}, staticToken("t")},
+		{User{TokenFile: "f", AuthProvider: &AuthProviderConfig{Name: "other", Config: map[string]string{"access-token": "a"}}}, newFileToken("f")},
+		{User{AuthProvider: &AuthProviderConfig{Name: "other", Config: map[string]string{"access-token": "a"}}}, staticToken("a")},
+	}
+
+	for _, test := range tests {
+		actual, err := newTokenSource(test.user, nil)
+		assert.Nil(t, err)
+		if test.expected == nil {
+			assert.Nil(t, actual)
+		} else {
+			assert.Equal(t, test.expected, actual)
+		}
+	}
+
+	actual, err := newTokenSource(User{}, exec)
+	assert.Nil(t, err)
+	assert.Equal(t, exec, actual)
+}
+
+func TestNewTokenSourceUnsupportedProvider(t *testing.T) {
+	_, err := newTokenSource(User{AuthProvider: &AuthProviderConfig{Name: "unknown"}}, nil)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unsupported auth provider unknown")
+	}
+}
+
+func TestFileToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "token")
+	ioutil.WriteFile(file, []byte("first\n"), 0600)
+
+	ts := newFileToken(file)
+	token, err := ts.token()
+	assert.Nil(t, err)
+	assert.Equal(t, "first", token)
+
+	ioutil.WriteFile(file, []byte("second"), 0600)
+	token, _ = ts.token()
+	assert.Equal(t, "first", token, "token must be cached")
+
+	ts.invalidate()
+	token, _ = ts.token()
+	assert.Equal(t, "second", token, "token must be read again after invalidation")
+
+	_, err = newFileToken(path.Join(dir, "missing")).token()
+	assert.NotNil(t, err)
+}
+
+func TestAuthTransport(t *testing.T) {
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		user     User
+		expected string
+	}{
+		{User{}, ""},
+		{User{Token: "abc"}, "Bearer abc"},
+		{User{Username: "admin", Password: "secret"}, "Basic YWRtaW46c2VjcmV0"},
+		{User{Token: "abc", Username: "admin", Password: "secret"}, "Bearer abc"},
+	}
+
+	for _, test := range tests {
+		rt, err := newAuthTransport(test.user, nil, http.DefaultTransport)
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+			continue
+		}
+
+		authorization = ""
+		c := &http.Client{Transport: rt}
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+			continue
+		}
+		res.Body.Close()
+
+		assert.Equal(t, test.expected, authorization, "user %+v", test.user)
+		assert.Equal(t, "", req.Header.Get("Authorization"), "original request must not be modified")
+	}
+}
+
+type countingTokenSource struct {
+	tokens      []string
+	invalidated int
+}
+
+func (s *countingTokenSource) token() (string, error) {
+	return s.tokens[s.invalidated], nil
+}
+
+func (s *countingTokenSource) invalidate() {
+	s.invalidated++
+}
+
+func TestAuthTransportInvalidatesRejectedToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	tokens := &countingTokenSource{tokens: []string{"revoked", "fresh"}}
+	c := &http.Client{Transport: &authTransport{base: http.DefaultTransport, tokens: tokens}}
+
+	res, err := c.Get(ts.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, 1, tokens.invalidated)
+
+	res, err = c.Get(ts.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 1, tokens.invalidated)
+}
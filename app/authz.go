@@ -0,0 +1,316 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+//Authorizer decides which objects a client of the mirror may see
+type Authorizer interface {
+	//Allowed tells whether objects of the resource in the namespace of the server may be seen.
+	//Empty namespace means objects of all namespaces for namespaced resources,
+	//and the objects of cluster-scoped resources. Reviews are given up when the context is done
+	Allowed(ctx context.Context, server KubeServer, r KubeResource, namespace string) bool
+}
+
+//allowAll lets clients see all objects, it is used when authorization is not configured
+type allowAll struct{}
+
+func (allowAll) Allowed(ctx context.Context, server KubeServer, r KubeResource, namespace string) bool {
+	return true
+}
+
+//errUnauthenticated is returned when the client of the mirror cannot be identified
+var errUnauthenticated = errors.New("client is not authenticated")
+
+//RequestAuthorizer finds out who sent the request to the mirror, and what the client may see
+type RequestAuthorizer interface {
+	Authorize(r *http.Request) (Authorizer, error)
+}
+
+//requireAuthorization passes the authorizer of the client to the handler,
+//and rejects requests of unknown clients
+func requireAuthorization(ra RequestAuthorizer, h func(w http.ResponseWriter, r *http.Request, a Authorizer)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a, err := ra.Authorize(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kubemrr"`)
+			writeJSON(w, http.StatusUnauthorized, APIError{err.Error()})
+			return
+		}
+		h(w, r, a)
+	}
+}
+
+type allowAllRequests struct{}
+
+func (allowAllRequests) Authorize(r *http.Request) (Authorizer, error) {
+	return allowAll{}, nil
+}
+
+//AuthzConfig is the content of the --authz-file. Clients are identified by their bearer tokens,
+//or by common names of their client certificates
+type AuthzConfig struct {
+	Users []AuthzUser `yaml:"users"`
+}
+
+type AuthzUser struct {
+	Name       string      `yaml:"name"`
+	Token      string      `yaml:"token,omitempty"`
+	CommonName string      `yaml:"commonName,omitempty"`
+	Rules      []AuthzRule `yaml:"rules"`
+}
+
+//AuthzRule allows to see objects in the namespaces of the servers. Servers and namespaces
+//are glob patterns, as in filepath.Match. Rules without servers apply to all servers
+type AuthzRule struct {
+	Servers       []string `yaml:"servers,omitempty"`
+	Namespaces    []string `yaml:"namespaces,omitempty"`
+	ClusterScoped bool     `yaml:"clusterScoped,omitempty"`
+}
+
+func readAuthzConfig(file string) (*AuthzConfig, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var config AuthzConfig
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", file, err)
+	}
+	for _, u := range config.Users {
+		if u.Token == "" && u.CommonName == "" {
+			return nil, fmt.Errorf("user %s in %s has neither token nor commonName", u.Name, file)
+		}
+	}
+	return &config, nil
+}
+
+func (c *AuthzConfig) Authorize(r *http.Request) (Authorizer, error) {
+	if token := bearerToken(r); token != "" {
+		for i := range c.Users {
+			u := &c.Users[i]
+			if u.Token != "" && subtle.ConstantTimeCompare([]byte(u.Token), []byte(token)) == 1 {
+				return u, nil
+			}
+		}
+		return nil, errUnauthenticated
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for i := range c.Users {
+			if c.Users[i].CommonName != "" && c.Users[i].CommonName == cn {
+				return &c.Users[i], nil
+			}
+		}
+	}
+	return nil, errUnauthenticated
+}
+
+func (u *AuthzUser) Allowed(ctx context.Context, server KubeServer, r KubeResource, namespace string) bool {
+	for _, rule := range u.Rules {
+		if len(rule.Servers) > 0 && !matchesAnyPattern(server.URL, rule.Servers) {
+			continue
+		}
+
+		switch {
+		case !r.Namespaced:
+			if rule.ClusterScoped {
+				return true
+			}
+		case namespace == "":
+			for _, ns := range rule.Namespaces {
+				if ns == "*" {
+					return true
+				}
+			}
+		default:
+			if matchesAnyPattern(namespace, rule.Namespaces) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+}
+
+//accessReviewTTL is how long decisions of API servers about access of clients are kept
+var accessReviewTTL = time.Minute
+
+//accessReviews asks API servers whether the clients of the mirror can list objects.
+//Clients send their own Kubernetes tokens, and the mirror asks the API server on behalf
+//of the client with SelfSubjectAccessReview
+type accessReviews struct {
+	//clients returns the clients of the mirrored servers
+	clients func() []KubeClient
+
+	decisions map[accessReviewKey]accessDecision
+	//tokens keeps when the tokens accepted by API servers must be checked again
+	tokens map[tokenServer]time.Time
+	mu     *sync.Mutex
+}
+
+//tokenServer is the hash of a token accepted by the server
+type tokenServer struct {
+	token  string
+	server KubeServer
+}
+
+type accessReviewKey struct {
+	token     string
+	server    KubeServer
+	group     string
+	resource  string
+	namespace string
+}
+
+type accessDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+func newAccessReviews(clients func() []KubeClient) *accessReviews {
+	return &accessReviews{
+		clients:   clients,
+		decisions: make(map[accessReviewKey]accessDecision),
+		tokens:    make(map[tokenServer]time.Time),
+		mu:        &sync.Mutex{},
+	}
+}
+
+//Authorize accepts the token for the servers given in the server parameter of the request which
+//authenticate it. The token is not sent to other servers: a token of one cluster must not reach
+//the others, and does not let the client see objects of the others
+func (ar *accessReviews) Authorize(r *http.Request) (Authorizer, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errUnauthenticated
+	}
+
+	ta := &tokenAccess{reviews: ar, token: token, servers: make(map[KubeServer]bool)}
+	server := r.URL.Query().Get("server")
+	if server == "" {
+		return ta, nil
+	}
+	for _, kc := range ar.clients() {
+		if isServer(server, kc.Server()) && ar.authenticated(r.Context(), token, kc) {
+			ta.servers[kc.Server()] = true
+		}
+	}
+	if len(ta.servers) == 0 {
+		return nil, errUnauthenticated
+	}
+	return ta, nil
+}
+
+//authenticated tells whether the API server accepts the token. Otherwise any string
+//would pass as a token, and show what every client may see
+func (ar *accessReviews) authenticated(ctx context.Context, token string, kc KubeClient) bool {
+	key := tokenServer{tokenHash(token), kc.Server()}
+	ar.mu.Lock()
+	expires, ok := ar.tokens[key]
+	ar.mu.Unlock()
+	if ok && time.Now().Before(expires) {
+		return true
+	}
+
+	if err := kc.Authenticate(ctx, token); err != nil {
+		log.WithField("server", kc.Server().URL).WithField("error", err).Debug("token is not accepted")
+		return false
+	}
+	ar.mu.Lock()
+	ar.tokens[key] = time.Now().Add(accessReviewTTL)
+	ar.mu.Unlock()
+	return true
+}
+
+//client returns the client of the mirrored server
+func (ar *accessReviews) client(server KubeServer) (KubeClient, bool) {
+	for _, kc := range ar.clients() {
+		if kc.Server() == server {
+			return kc, true
+		}
+	}
+	return nil, false
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//tokenAccess is what the client with the token can see on the servers which have authenticated the token
+type tokenAccess struct {
+	reviews *accessReviews
+	token   string
+	servers map[KubeServer]bool
+}
+
+func (ta *tokenAccess) Allowed(ctx context.Context, server KubeServer, r KubeResource, namespace string) bool {
+	if !ta.servers[server] {
+		return false
+	}
+	return ta.reviews.allowed(ctx, ta.token, server, r, namespace)
+}
+
+func (ar *accessReviews) allowed(ctx context.Context, token string, server KubeServer, r KubeResource, namespace string) bool {
+	key := accessReviewKey{tokenHash(token), server, r.Group, r.Name, namespace}
+
+	ar.mu.Lock()
+	d, ok := ar.decisions[key]
+	ar.mu.Unlock()
+	if ok && time.Now().Before(d.expires) {
+		return d.allowed
+	}
+
+	kc, ok := ar.client(server)
+	if !ok {
+		return false
+	}
+	allowed, err := kc.CanList(ctx, token, r, namespace)
+	if err != nil {
+		log.
+			WithField("server", server.URL).
+			WithField("kind", r.Singular).
+			WithField("namespace", namespace).
+			WithField("error", err).
+			Warn("could not review access of client, denying")
+		return false
+	}
+
+	ar.mu.Lock()
+	ar.decisions[key] = accessDecision{allowed, time.Now().Add(accessReviewTTL)}
+	for k, d := range ar.decisions {
+		if time.Now().After(d.expires) {
+			delete(ar.decisions, k)
+		}
+	}
+	for k, expires := range ar.tokens {
+		if time.Now().After(expires) {
+			delete(ar.tokens, k)
+		}
+	}
+	ar.mu.Unlock()
+	return allowed
+}
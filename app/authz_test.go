@@ -0,0 +1,296 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthzUserAllowed(t *testing.T) {
+	pod, _ := defaultRegistry.Lookup("pod")
+	node, _ := defaultRegistry.Lookup("node")
+	u := &AuthzUser{
+		Name: "dev",
+		Rules: []AuthzRule{
+			{Servers: []string{"https://dev.*"}, Namespaces: []string{"*"}, ClusterScoped: true},
+			{Namespaces: []string{"team-*"}},
+		},
+	}
+
+	tests := []struct {
+		server    string
+		r         KubeResource
+		namespace string
+		expected  bool
+	}{
+		{"https://dev.example.com", pod, "", true},
+		{"https://dev.example.com", pod, "kube-system", true},
+		{"https://dev.example.com", node, "", true},
+		{"https://prod.example.com", pod, "team-a", true},
+		{"https://prod.example.com", pod, "kube-system", false},
+		{"https://prod.example.com", pod, "", false},
+		{"https://prod.example.com", node, "", false},
+	}
+
+	for _, test := range tests {
+		actual := u.Allowed(context.Background(), KubeServer{test.server}, test.r, test.namespace)
+		assert.Equal(t, test.expected, actual, "%s %s in [%s]", test.server, test.r.Singular, test.namespace)
+	}
+}
+
+func TestObjectsAreAuthorized(t *testing.T) {
+	c := NewMrrCache()
+	fillCache(c)
+	u := &AuthzUser{Rules: []AuthzRule{
+		{Servers: []string{"server1"}, Namespaces: []string{"ns2"}},
+		{Servers: []string{"server2"}, ClusterScoped: true},
+	}}
+
+	tests := []struct {
+		filter   MrrFilter
+		expected []string
+	}{
+		{MrrFilter{Kind: "pod"}, []string{"server1-a", "server1-b", "server1-c"}},
+		{MrrFilter{Kind: "pod", Namespace: "ns1"}, []string{}},
+		{MrrFilter{Kind: "pod", Namespace: "NS2", Word: "server1-b"}, []string{"server1-b"}},
+		{MrrFilter{Kind: "namespace"}, []string{"server2-ns1", "server2-ns2"}},
+	}
+
+	for _, test := range tests {
+		var objects []KubeObject
+		err := c.Objects(context.Background(), &test.filter, u, &objects)
+		if assert.NoError(t, err, "%+v", test.filter) {
+			actual := []string{}
+			for _, o := range objects {
+				actual = append(actual, o.Name)
+			}
+			assert.Equal(t, test.expected, actual, "%+v", test.filter)
+		}
+	}
+}
+
+//slowAuthorizer allows the namespaces after a delay, and answers for the blocked namespace only when its review is canceled
+type slowAuthorizer struct {
+	delay    time.Duration
+	blocked  string
+	hits     chan string
+	canceled chan struct{}
+}
+
+func (a *slowAuthorizer) Allowed(ctx context.Context, server KubeServer, r KubeResource, namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	a.hits <- namespace
+	if namespace == a.blocked {
+		<-ctx.Done()
+		close(a.canceled)
+		return true
+	}
+	time.Sleep(a.delay)
+	return true
+}
+
+func TestObjectsAreAuthorizedConcurrently(t *testing.T) {
+	defer func(timeout time.Duration) { namespaceReviewTimeout = timeout }(namespaceReviewTimeout)
+	namespaceReviewTimeout = 500 * time.Millisecond
+
+	c := NewMrrCache()
+	fillCache(c)
+	a := &slowAuthorizer{delay: 100 * time.Millisecond, blocked: "ns2", hits: make(chan string, 10), canceled: make(chan struct{})}
+
+	start := time.Now()
+	var objects []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Server: "server1", Kind: "pod"}, a, &objects)
+	elapsed := time.Since(start)
+	if assert.NoError(t, err) {
+		actual := []string{}
+		for _, o := range objects {
+			actual = append(actual, o.Namespace)
+		}
+		assert.Equal(t, []string{"ns1", "ns1", "ns1", "ns3", "ns3", "ns3"}, actual)
+	}
+	assert.True(t, elapsed < time.Second, "authorization must not wait for the blocked namespace, took %v", elapsed)
+	assert.Equal(t, 3, len(a.hits), "namespaces must be authorized once")
+	select {
+	case <-a.canceled:
+	case <-time.After(time.Second):
+		t.Error("review of the blocked namespace must be canceled after the timeout")
+	}
+}
+
+func TestReadAuthzConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "authz.yaml")
+	ioutil.WriteFile(file, []byte(`
+users:
+- name: alice
+  token: alice-token
+  rules:
+  - namespaces: ["team-a"]
+- name: bob
+  commonName: bob
+  rules:
+  - servers: ["https://dev.*"]
+    namespaces: ["*"]
+    clusterScoped: true
+`), 0600)
+
+	config, err := readAuthzConfig(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &AuthzConfig{Users: []AuthzUser{
+			{Name: "alice", Token: "alice-token", Rules: []AuthzRule{{Namespaces: []string{"team-a"}}}},
+			{Name: "bob", CommonName: "bob", Rules: []AuthzRule{{Servers: []string{"https://dev.*"}, Namespaces: []string{"*"}, ClusterScoped: true}}},
+		}}, config)
+	}
+
+	ioutil.WriteFile(file, []byte("users:\n- name: anonymous\n"), 0600)
+	_, err = readAuthzConfig(file)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "neither token nor commonName")
+	}
+}
+
+func TestAuthzConfigIdentifiesClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewMrrCache()
+	fillCache(c)
+	config := &AuthzConfig{Users: []AuthzUser{
+		{Name: "alice", Token: "alice-token", Rules: []AuthzRule{{Servers: []string{"server3"}, Namespaces: []string{"ns3"}}}},
+	}}
+	server := httptest.NewServer(NewAuthorizedMirrorHandler(c, config))
+	defer server.Close()
+
+	for _, test := range []struct {
+		token    string
+		expected int
+		complain string
+	}{
+		{"alice-token", 3, ""},
+		{"mallory-token", 0, "client is not authenticated"},
+		{"", 0, "client is not authenticated"},
+	} {
+		e := MirrorEndpoint{Network: "tcp", Address: server.Listener.Addr().String()}
+		if test.token != "" {
+			e.TokenFile = path.Join(dir, "token")
+			ioutil.WriteFile(e.TokenFile, []byte(test.token), 0600)
+		}
+		client, err := NewMrrClient(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		objects, err := client.Objects(MrrFilter{Kind: "pod"})
+		if test.complain == "" {
+			assert.NoError(t, err, test.token)
+			assert.Equal(t, test.expected, len(objects), test.token)
+		} else if assert.Error(t, err, test.token) {
+			assert.Contains(t, err.Error(), test.complain)
+		}
+	}
+}
+
+func TestAccessReviews(t *testing.T) {
+	defer func(ttl time.Duration) { accessReviewTTL = ttl }(accessReviewTTL)
+	accessReviewTTL = time.Hour
+
+	kc := NewTestKubeClient()
+	kc.canList = func(token string, r KubeResource, namespace string) (bool, error) {
+		if token == "broken" {
+			return true, errors.New("server is not available")
+		}
+		return token == "alice" && namespace == "ns1", nil
+	}
+	ar := newAccessReviews(func() []KubeClient { return []KubeClient{kc} })
+	pod, _ := defaultRegistry.Lookup("pod")
+
+	assert.True(t, ar.allowed(context.Background(), "alice", kc.Server(), pod, "ns1"))
+	assert.False(t, ar.allowed(context.Background(), "alice", kc.Server(), pod, "ns2"))
+	assert.False(t, ar.allowed(context.Background(), "bob", kc.Server(), pod, "ns1"))
+	assert.False(t, ar.allowed(context.Background(), "broken", kc.Server(), pod, "ns1"), "access must be denied when review fails")
+	assert.False(t, ar.allowed(context.Background(), "alice", KubeServer{"http://unknown.com"}, pod, "ns1"))
+	assert.Equal(t, 4, kc.canListHits)
+
+	assert.True(t, ar.allowed(context.Background(), "alice", kc.Server(), pod, "ns1"))
+	assert.False(t, ar.allowed(context.Background(), "bob", kc.Server(), pod, "ns1"))
+	assert.Equal(t, 4, kc.counter(&kc.canListHits), "decisions must be reused")
+
+	assert.False(t, ar.allowed(context.Background(), "broken", kc.Server(), pod, "ns1"))
+	assert.Equal(t, 5, kc.counter(&kc.canListHits), "failed reviews must not be reused")
+}
+
+func TestAccessReviewsAuthenticateTokens(t *testing.T) {
+	defer func(ttl time.Duration) { accessReviewTTL = ttl }(accessReviewTTL)
+	accessReviewTTL = time.Hour
+
+	other := NewTestKubeClient()
+	other.authenticate = func(token string) error {
+		return nil
+	}
+	other.canList = func(token string, r KubeResource, namespace string) (bool, error) {
+		return true, nil
+	}
+	kc := NewTestKubeClient()
+	kc.authenticate = func(token string) error {
+		if token != "alice" {
+			return errors.New("401 Unauthorized")
+		}
+		return nil
+	}
+	kc.canList = func(token string, r KubeResource, namespace string) (bool, error) {
+		return true, nil
+	}
+	c := NewMrrCache()
+	pod := KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p", Namespace: "ns1"}}
+	c.updateKubeObject(kc.Server(), pod)
+	c.updateKubeObject(other.Server(), pod)
+	ar := newAccessReviews(func() []KubeClient { return []KubeClient{other, kc} })
+	server := httptest.NewServer(NewAuthorizedMirrorHandler(c, ar))
+	defer server.Close()
+
+	for _, test := range []struct {
+		token    string
+		query    string
+		expected int
+		objects  int
+	}{
+		{"x", "?kind=pod&server=" + kc.Server().URL, http.StatusUnauthorized, 0},
+		{"alice", "?kind=pod&server=" + kc.Server().URL, http.StatusOK, 1},
+		{"alice", "?kind=pod&server=" + kc.Server().URL, http.StatusOK, 1},
+		{"alice", "?kind=pod", http.StatusOK, 0},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+"/api/v1/objects"+test.query, nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		resp, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			var list APIObjectList
+			json.NewDecoder(resp.Body).Decode(&list)
+			resp.Body.Close()
+			assert.Equal(t, test.expected, resp.StatusCode, "%s %s", test.token, test.query)
+			assert.Equal(t, test.objects, len(list.Objects), "%s %s", test.token, test.query)
+		}
+	}
+
+	assert.Equal(t, 2, kc.counter(&kc.authenticateHits), "accepted tokens must be reused")
+	assert.Equal(t, 0, other.counter(&other.authenticateHits), "token must not be sent to servers the client does not ask about")
+	assert.Equal(t, 0, other.counter(&other.canListHits), "token must not be sent to servers the client does not ask about")
+}
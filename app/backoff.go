@@ -0,0 +1,87 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+//Backoff calculates delays between retries of failing requests to API server.
+//The delay starts at Initial and grows by Factor after each failure up to Max.
+//Each delay is increased by a random fraction of itself, which is at most Jitter.
+//The delay starts again from Initial after Reset is called on success
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+
+	attempts int
+}
+
+var DefaultBackoff = Backoff{
+	Initial: time.Second,
+	Max:     2 * time.Minute,
+	Factor:  2,
+	Jitter:  0.2,
+}
+
+//Next returns delay before the next retry and counts the failed attempt
+func (b *Backoff) Next() time.Duration {
+	d := float64(b.Initial) * math.Pow(b.Factor, float64(b.attempts))
+	if b.Jitter > 0 {
+		d += d * b.Jitter * rand.Float64()
+	}
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	b.attempts++
+	return time.Duration(d)
+}
+
+//Attempts returns the number of failed attempts since the last success
+func (b *Backoff) Attempts() int {
+	return b.attempts
+}
+
+func (b *Backoff) Reset() {
+	b.attempts = 0
+}
+
+func AddBackoffFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("backoff-initial", DefaultBackoff.Initial, "Delay before retrying a failed request to API server")
+	cmd.Flags().Duration("backoff-max", DefaultBackoff.Max, "Maximum delay between retries of failed requests to API server")
+	cmd.Flags().Float64("backoff-factor", DefaultBackoff.Factor, "Multiplier of the delay after each failed request to API server")
+	cmd.Flags().Float64("backoff-jitter", DefaultBackoff.Jitter, "Maximum random fraction added to the delay between retries")
+}
+
+func GetBackoff(cmd *cobra.Command) (Backoff, error) {
+	b := Backoff{}
+	var err error
+	if b.Initial, err = cmd.Flags().GetDuration("backoff-initial"); err != nil {
+		return b, err
+	}
+	if b.Max, err = cmd.Flags().GetDuration("backoff-max"); err != nil {
+		return b, err
+	}
+	if b.Factor, err = cmd.Flags().GetFloat64("backoff-factor"); err != nil {
+		return b, err
+	}
+	if b.Jitter, err = cmd.Flags().GetFloat64("backoff-jitter"); err != nil {
+		return b, err
+	}
+
+	if b.Initial <= 0 || b.Max < b.Initial {
+		return b, errors.New("--backoff-initial must be positive and not greater than --backoff-max")
+	}
+	if b.Factor < 1 {
+		return b, errors.New("--backoff-factor must be at least 1")
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return b, errors.New("--backoff-jitter must be between 0 and 1")
+	}
+	return b, nil
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var testBackoff = Backoff{Initial: time.Millisecond, Max: time.Millisecond, Factor: 1}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Factor: 2}
+
+	expected := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, e := range expected {
+		assert.Equal(t, e*time.Second, b.Next(), "attempt %d", i)
+	}
+	assert.Equal(t, len(expected), b.Attempts())
+
+	b.Reset()
+	assert.Equal(t, 0, b.Attempts())
+	assert.Equal(t, time.Second, b.Next(), "must start over after reset")
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Minute, Factor: 1, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		d := b.Next()
+		if d < time.Second || d > 1500*time.Millisecond {
+			t.Errorf("Delay %s is out of jitter bounds", d)
+		}
+	}
+}
+
+func TestGetBackoff(t *testing.T) {
+	tests := []struct {
+		flags   map[string]string
+		isError bool
+	}{
+		{
+			flags: map[string]string{},
+		},
+		{
+			flags: map[string]string{"backoff-initial": "5s", "backoff-max": "1m", "backoff-factor": "1.5", "backoff-jitter": "0"},
+		},
+		{
+			flags:   map[string]string{"backoff-initial": "0s"},
+			isError: true,
+		},
+		{
+			flags:   map[string]string{"backoff-initial": "5m", "backoff-max": "1m"},
+			isError: true,
+		},
+		{
+			flags:   map[string]string{"backoff-factor": "0.5"},
+			isError: true,
+		},
+		{
+			flags:   map[string]string{"backoff-jitter": "2"},
+			isError: true,
+		},
+	}
+
+	for i, test := range tests {
+		cmd := NewWatchCommand(NewTestFactory())
+		for k, v := range test.flags {
+			cmd.Flags().Set(k, v)
+		}
+		_, err := GetBackoff(cmd)
+		if test.isError {
+			assert.Error(t, err, "test %d", i)
+		} else {
+			assert.NoError(t, err, "test %d", i)
+		}
+	}
+}
@@ -18,8 +18,12 @@ func NewCompletionCommand(f Factory) *cobra.Command {
 	}
 
 	AddCommonFlags(cmd)
+	AddEndpointSecurityFlags(cmd)
 	cmd.Flags().String("kubectl-alias", "kubectl", "Alias of your kubectl command")
 	cmd.Flags().String("kubemrr-path", "kubemrr", "Path to the kubemrr command, if it is outside $PATH variable")
+	cmd.Flags().String("match", MatchPrefix, "How completed names are matched with the typed word: prefix, substring or fuzzy")
+	cmd.Flags().Int("limit", 0, "Maximum number of completed names, 0 for no limit")
+	cmd.Flags().Bool("autostart", false, "Start the mirror in the background when completion is used and the mirror is not running")
 
 	return cmd
 }
@@ -50,6 +54,7 @@ func RunAlias(f Factory, cmd *cobra.Command, args []string) error {
 		kubemrrPort:    33033,
 		kubemrrAddress: "0.0.0.0",
 		kubemrrPath:    "kubemrr",
+		kubemrrMatch:   MatchPrefix,
 	}
 
 	if c.kubemrrPort, err = cmd.Flags().GetInt("port"); err != nil {
@@ -58,18 +63,34 @@ func RunAlias(f Factory, cmd *cobra.Command, args []string) error {
 	if c.kubemrrAddress, err = cmd.Flags().GetString("address"); err != nil {
 		return err
 	}
+	if c.kubemrrEndpoint, err = GetEndpoint(cmd); err != nil {
+		return err
+	}
 	if c.kubectlAlias, err = cmd.Flags().GetString("kubectl-alias"); err != nil {
 		return err
 	}
 	if c.kubemrrPath, err = cmd.Flags().GetString("kubemrr-path"); err != nil {
 		return err
 	}
+	if c.kubemrrMatch, err = cmd.Flags().GetString("match"); err != nil {
+		return err
+	}
+	if !isMatchMode(c.kubemrrMatch) {
+		return fmt.Errorf("Unsupported match mode %s, expected one of %s", c.kubemrrMatch, strings.Join(matchModes, ", "))
+	}
+	if c.kubemrrLimit, err = cmd.Flags().GetInt("limit"); err != nil {
+		return err
+	}
+	if c.kubemrrAutostart, err = cmd.Flags().GetBool("autostart"); err != nil {
+		return err
+	}
 
 	in = fmt.Sprintf("# Below is your completion script for %s with %+v \n", shell, c) + in
 	in = strings.Replace(in, "[[kubectl_alias]]", c.kubectlAlias, -1)
 	in = strings.Replace(in, "[[kubemrr_path]]", c.kubemrrPath, -1)
-	in = strings.Replace(in, "[[kubemrr_address]]", c.kubemrrAddress, -1)
-	in = strings.Replace(in, "[[kubemrr_port]]", strconv.Itoa(c.kubemrrPort), -1)
+	in = strings.Replace(in, "[[kubemrr_endpoint]]", c.endpoint(), -1)
+	in = strings.Replace(in, "[[kubemrr_match]]", c.kubemrrMatch, -1)
+	in = strings.Replace(in, "[[kubemrr_limit]]", strconv.Itoa(c.kubemrrLimit), -1)
 	in = in + fmt.Sprintf("# Above is your completion script for %s with %+v \n", shell, c)
 
 	fmt.Fprint(f.StdOut(), in)
@@ -81,4 +102,42 @@ type replacement struct {
 	kubemrrPort    int
 	kubemrrAddress string
 	kubemrrPath    string
+	kubemrrMatch   string
+	kubemrrLimit   int
+
+	kubemrrEndpoint  MirrorEndpoint
+	kubemrrAutostart bool
+}
+
+//endpoint returns the flags that tell kubemrr where the mirror is accessible and how to connect to it
+func (c replacement) endpoint() string {
+	e := c.kubemrrEndpoint
+	flags := []string{}
+	if e.Network == "unix" {
+		flags = append(flags, "--socket="+shellQuote(e.Address))
+	} else {
+		flags = append(flags, fmt.Sprintf("-a %s -p %d", c.kubemrrAddress, c.kubemrrPort))
+	}
+
+	if e.TLS {
+		flags = append(flags, "--tls")
+	}
+	for _, f := range []struct{ name, value string }{
+		{"tls-cert", e.CertFile},
+		{"tls-key", e.KeyFile},
+		{"tls-ca", e.CAFile},
+		{"token-file", e.TokenFile},
+	} {
+		if f.value != "" {
+			flags = append(flags, "--"+f.name+"="+shellQuote(f.value))
+		}
+	}
+	if c.kubemrrAutostart {
+		flags = append(flags, "--autostart")
+	}
+	return strings.Join(flags, " ")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
 }
@@ -265,8 +265,8 @@ __kubectl_get_namespaces()
 {
     local template kubectl_out
     template="{{ range .items  }}{{ .metadata.name }} {{ end }}"
-    if kubectl_out=$([[kubemrr_path]] -a [[kubemrr_address]] -p [[kubemrr_port]] --kubectl-flags="$kubectl_line" get namespace); then
-        COMPREPLY=( $( compgen -W "${kubectl_out[*]}" -- "$cur" ) )
+    if kubectl_out=$([[kubemrr_path]] [[kubemrr_endpoint]] --kubectl-flags="$kubectl_line" --word="$cur" --match=[[kubemrr_match]] --limit=[[kubemrr_limit]] get namespace); then
+        COMPREPLY=( ${kubectl_out[*]} )
     fi
 }
 
@@ -288,8 +288,8 @@ __kubectl_parse_get()
     local template
     template="{{ range .items  }}{{ .metadata.name }} {{ end }}"
     local kubectl_out
-    if kubectl_out=$([[kubemrr_path]] -a [[kubemrr_address]] -p [[kubemrr_port]] --kubectl-flags="$kubectl_line" get "$1" 2>>"$bash_comp_err_file"); then
-        COMPREPLY=( $( compgen -W "${kubectl_out[*]}" -- "$cur" ) )
+    if kubectl_out=$([[kubemrr_path]] [[kubemrr_endpoint]] --kubectl-flags="$kubectl_line" --word="$cur" --match=[[kubemrr_match]] --limit=[[kubemrr_limit]] "${@:2}" get "$1" 2>>"$bash_comp_err_file"); then
+        COMPREPLY=( ${kubectl_out[*]} )
     fi
 }
 
@@ -306,16 +306,23 @@ __kubectl_get_resource_pod()
     __kubectl_parse_get "pod"
 }
 
+# only running pods can be connected to, terminated ones are left for kubectl logs
+__kubectl_get_running_pod()
+{
+    __kubectl_parse_get "pod" --running
+}
+
 __kubectl_get_resource_rc()
 {
     __kubectl_parse_get "rc"
 }
 
-# $1 is the name of the pod we want to get the list of containers inside
+# the pod is the only noun, its containers are asked from kubemrr
 __kubectl_get_containers()
 {
-    local template
-    template="{{ range .spec.containers  }}{{ .name }} {{ end }}"
+    local kubectl_line
+    local bash_comp_err_file=/dev/null
+    __unalias "$COMP_LINE"
     __debug "${FUNCNAME} nouns are ${nouns[*]}"
 
     local len="${#nouns[@]}"
@@ -324,8 +331,28 @@ __kubectl_get_containers()
     fi
     local last=${nouns[${len} -1]}
     local kubectl_out
-    if kubectl_out=$(kubectl get $(__kubectl_namespace_flag) -o template --template="${template}" pods "${last}" 2>/dev/null); then
-        COMPREPLY=( $( compgen -W "${kubectl_out[*]}" -- "$cur" ) )
+    if kubectl_out=$([[kubemrr_path]] [[kubemrr_endpoint]] --kubectl-flags="$kubectl_line" --word="$cur" --match=[[kubemrr_match]] --limit=[[kubemrr_limit]] --pod="${last}" get containers 2>>"$bash_comp_err_file"); then
+        COMPREPLY=( ${kubectl_out[*]} )
+    fi
+}
+
+# completes the label selector of objects of the first noun, labels are asked from kubemrr
+__kubectl_get_labels()
+{
+    local kubectl_line
+    local bash_comp_err_file=/dev/null
+    __unalias "$COMP_LINE"
+    __debug "${FUNCNAME} nouns are ${nouns[*]}"
+
+    if [[ ${#nouns[@]} -eq 0 ]]; then
+        return
+    fi
+    local kubectl_out
+    if kubectl_out=$([[kubemrr_path]] [[kubemrr_endpoint]] --kubectl-flags="$kubectl_line" --word="$cur" --match=[[kubemrr_match]] --limit=[[kubemrr_limit]] --kind="${nouns[0]}" get labels 2>>"$bash_comp_err_file"); then
+        COMPREPLY=( ${kubectl_out[*]} )
+    fi
+    if [[ "${COMPREPLY[0]}" == *= && $(type -t compopt) = "builtin" ]]; then
+        compopt -o nospace
     fi
 }
 
@@ -351,8 +378,8 @@ __custom_func() {
             __kubectl_require_pod_and_container
             return
             ;;
-        kubectl_exec)
-            __kubectl_get_resource_pod
+        kubectl_exec | kubectl_attach | kubectl_port-forward)
+            __kubectl_get_running_pod
             return
             ;;
         kubectl_rolling-update)
@@ -392,7 +419,11 @@ _kubectl_get()
     flags+=("--recursive")
     flags+=("-R")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-all")
     flags+=("-a")
     flags+=("--show-labels")
@@ -648,7 +679,11 @@ _kubectl_describe()
     flags+=("--recursive")
     flags+=("-R")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-events")
     flags+=("--alsologtostderr")
     flags+=("--api-version=")
@@ -1472,7 +1507,11 @@ _kubectl_delete()
     flags+=("--recursive")
     flags+=("-R")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--timeout=")
     flags+=("--alsologtostderr")
     flags+=("--api-version=")
@@ -1842,7 +1881,11 @@ _kubectl_logs()
     flags_completion=()
 
     flags+=("--container=")
+    flags_with_completion+=("--container")
+    flags_completion+=("__kubectl_get_containers")
     two_word_flags+=("-c")
+    flags_with_completion+=("-c")
+    flags_completion+=("__kubectl_get_containers")
     flags+=("--follow")
     flags+=("-f")
     flags+=("--include-extended-apis")
@@ -2174,7 +2217,11 @@ _kubectl_attach()
     flags_completion=()
 
     flags+=("--container=")
+    flags_with_completion+=("--container")
+    flags_completion+=("__kubectl_get_containers")
     two_word_flags+=("-c")
+    flags_with_completion+=("-c")
+    flags_completion+=("__kubectl_get_containers")
     flags+=("--stdin")
     flags+=("-i")
     flags+=("--tty")
@@ -2223,9 +2270,17 @@ _kubectl_exec()
     flags_completion=()
 
     flags+=("--container=")
+    flags_with_completion+=("--container")
+    flags_completion+=("__kubectl_get_containers")
     two_word_flags+=("-c")
+    flags_with_completion+=("-c")
+    flags_completion+=("__kubectl_get_containers")
     flags+=("--pod=")
+    flags_with_completion+=("--pod")
+    flags_completion+=("__kubectl_get_running_pod")
     two_word_flags+=("-p")
+    flags_with_completion+=("-p")
+    flags_completion+=("__kubectl_get_running_pod")
     flags+=("--stdin")
     flags+=("-i")
     flags+=("--tty")
@@ -2274,7 +2329,11 @@ _kubectl_port-forward()
     flags_completion=()
 
     flags+=("--pod=")
+    flags_with_completion+=("--pod")
+    flags_completion+=("__kubectl_get_running_pod")
     two_word_flags+=("-p")
+    flags_with_completion+=("-p")
+    flags_completion+=("__kubectl_get_running_pod")
     flags+=("--alsologtostderr")
     flags+=("--api-version=")
     flags+=("--as=")
@@ -2949,7 +3008,11 @@ _kubectl_label()
     flags+=("-R")
     flags+=("--resource-version=")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-all")
     flags+=("-a")
     flags+=("--show-labels")
@@ -3099,7 +3162,11 @@ _kubectl_annotate()
     flags+=("-R")
     flags+=("--resource-version=")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-all")
     flags+=("-a")
     flags+=("--show-labels")
@@ -3196,7 +3263,11 @@ _kubectl_taint()
     flags_with_completion+=("--schema-cache-dir")
     flags_completion+=("_filedir")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-all")
     flags+=("-a")
     flags+=("--show-labels")
@@ -4562,8 +4633,8 @@ __kubectl_get_namespaces()
 {
     local template kubectl_out
     template="{{ range .items  }}{{ .metadata.name }} {{ end }}"
-    if kubectl_out=$([[kubemrr_path]] -a [[kubemrr_address]] -p [[kubemrr_port]] --kubectl-flags="$kubectl_line" get namespace); then
-        COMPREPLY=( $( compgen -W "${kubectl_out[*]}" -- "$cur" ) )
+    if kubectl_out=$([[kubemrr_path]] [[kubemrr_endpoint]] --kubectl-flags="$kubectl_line" --word="$cur" --match=[[kubemrr_match]] --limit=[[kubemrr_limit]] get namespace); then
+        COMPREPLY=( ${kubectl_out[*]} )
     fi
 }
 
@@ -4576,8 +4647,8 @@ __kubectl_parse_get()
     local template
     template="{{ range .items  }}{{ .metadata.name }} {{ end }}"
     local kubectl_out
-    if kubectl_out=$([[kubemrr_path]] -a [[kubemrr_address]] -p [[kubemrr_port]] --kubectl-flags="$kubectl_line" get "$1" 2>>"$bash_comp_err_file"); then
-        COMPREPLY=( $( compgen -W "${kubectl_out[*]}" -- "$cur" ) )
+    if kubectl_out=$([[kubemrr_path]] [[kubemrr_endpoint]] --kubectl-flags="$kubectl_line" --word="$cur" --match=[[kubemrr_match]] --limit=[[kubemrr_limit]] "${@:2}" get "$1" 2>>"$bash_comp_err_file"); then
+        COMPREPLY=( ${kubectl_out[*]} )
     fi
 }
 
@@ -4594,16 +4665,22 @@ __kubectl_get_resource_pod()
     __kubectl_parse_get "pod"
 }
 
+# only running pods can be connected to, terminated ones are left for kubectl logs
+__kubectl_get_running_pod()
+{
+    __kubectl_parse_get "pod" --running
+}
+
 __kubectl_get_resource_rc()
 {
     __kubectl_parse_get "rc"
 }
 
-# $1 is the name of the pod we want to get the list of containers inside
+# the pod is the only noun, its containers are asked from kubemrr
 __kubectl_get_containers()
 {
-    local template
-    template="{{ range .spec.containers  }}{{ .name }} {{ end }}"
+    local kubectl_line=$COMP_LINE
+    local bash_comp_err_file=/dev/null
     __debug "${FUNCNAME} nouns are ${nouns[*]}"
 
     local len="${#nouns[@]}"
@@ -4612,8 +4689,27 @@ __kubectl_get_containers()
     fi
     local last=${nouns[${len} -1]}
     local kubectl_out
-    if kubectl_out=$(kubectl get $(__kubectl_namespace_flag) -o template --template="${template}" pods "${last}" 2>/dev/null); then
-        COMPREPLY=( $( compgen -W "${kubectl_out[*]}" -- "$cur" ) )
+    if kubectl_out=$([[kubemrr_path]] [[kubemrr_endpoint]] --kubectl-flags="$kubectl_line" --word="$cur" --match=[[kubemrr_match]] --limit=[[kubemrr_limit]] --pod="${last}" get containers 2>>"$bash_comp_err_file"); then
+        COMPREPLY=( ${kubectl_out[*]} )
+    fi
+}
+
+# completes the label selector of objects of the first noun, labels are asked from kubemrr
+__kubectl_get_labels()
+{
+    local kubectl_line=$COMP_LINE
+    local bash_comp_err_file=/dev/null
+    __debug "${FUNCNAME} nouns are ${nouns[*]}"
+
+    if [[ ${#nouns[@]} -eq 0 ]]; then
+        return
+    fi
+    local kubectl_out
+    if kubectl_out=$([[kubemrr_path]] [[kubemrr_endpoint]] --kubectl-flags="$kubectl_line" --word="$cur" --match=[[kubemrr_match]] --limit=[[kubemrr_limit]] --kind="${nouns[0]}" get labels 2>>"$bash_comp_err_file"); then
+        COMPREPLY=( ${kubectl_out[*]} )
+    fi
+    if [[ "${COMPREPLY[0]}" == *= && $(type -t compopt) = "builtin" ]]; then
+        compopt -o nospace
     fi
 }
 
@@ -4639,8 +4735,8 @@ __custom_func() {
             __kubectl_require_pod_and_container
             return
             ;;
-        kubectl_exec)
-            __kubectl_get_resource_pod
+        kubectl_exec | kubectl_attach | kubectl_port-forward)
+            __kubectl_get_running_pod
             return
             ;;
         kubectl_rolling-update)
@@ -4680,7 +4776,11 @@ _kubectl_get()
     flags+=("--recursive")
     flags+=("-R")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-all")
     flags+=("-a")
     flags+=("--show-labels")
@@ -4936,7 +5036,11 @@ _kubectl_describe()
     flags+=("--recursive")
     flags+=("-R")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-events")
     flags+=("--alsologtostderr")
     flags+=("--api-version=")
@@ -5760,7 +5864,11 @@ _kubectl_delete()
     flags+=("--recursive")
     flags+=("-R")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--timeout=")
     flags+=("--alsologtostderr")
     flags+=("--api-version=")
@@ -6130,7 +6238,11 @@ _kubectl_logs()
     flags_completion=()
 
     flags+=("--container=")
+    flags_with_completion+=("--container")
+    flags_completion+=("__kubectl_get_containers")
     two_word_flags+=("-c")
+    flags_with_completion+=("-c")
+    flags_completion+=("__kubectl_get_containers")
     flags+=("--follow")
     flags+=("-f")
     flags+=("--include-extended-apis")
@@ -6462,7 +6574,11 @@ _kubectl_attach()
     flags_completion=()
 
     flags+=("--container=")
+    flags_with_completion+=("--container")
+    flags_completion+=("__kubectl_get_containers")
     two_word_flags+=("-c")
+    flags_with_completion+=("-c")
+    flags_completion+=("__kubectl_get_containers")
     flags+=("--stdin")
     flags+=("-i")
     flags+=("--tty")
@@ -6511,9 +6627,17 @@ _kubectl_exec()
     flags_completion=()
 
     flags+=("--container=")
+    flags_with_completion+=("--container")
+    flags_completion+=("__kubectl_get_containers")
     two_word_flags+=("-c")
+    flags_with_completion+=("-c")
+    flags_completion+=("__kubectl_get_containers")
     flags+=("--pod=")
+    flags_with_completion+=("--pod")
+    flags_completion+=("__kubectl_get_running_pod")
     two_word_flags+=("-p")
+    flags_with_completion+=("-p")
+    flags_completion+=("__kubectl_get_running_pod")
     flags+=("--stdin")
     flags+=("-i")
     flags+=("--tty")
@@ -6562,7 +6686,11 @@ _kubectl_port-forward()
     flags_completion=()
 
     flags+=("--pod=")
+    flags_with_completion+=("--pod")
+    flags_completion+=("__kubectl_get_running_pod")
     two_word_flags+=("-p")
+    flags_with_completion+=("-p")
+    flags_completion+=("__kubectl_get_running_pod")
     flags+=("--alsologtostderr")
     flags+=("--api-version=")
     flags+=("--as=")
@@ -7237,7 +7365,11 @@ _kubectl_label()
     flags+=("-R")
     flags+=("--resource-version=")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-all")
     flags+=("-a")
     flags+=("--show-labels")
@@ -7387,7 +7519,11 @@ _kubectl_annotate()
     flags+=("-R")
     flags+=("--resource-version=")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-all")
     flags+=("-a")
     flags+=("--show-labels")
@@ -7484,7 +7620,11 @@ _kubectl_taint()
     flags_with_completion+=("--schema-cache-dir")
     flags_completion+=("_filedir")
     flags+=("--selector=")
+    flags_with_completion+=("--selector")
+    flags_completion+=("__kubectl_get_labels")
     two_word_flags+=("-l")
+    flags_with_completion+=("-l")
+    flags_completion+=("__kubectl_get_labels")
     flags+=("--show-all")
     flags+=("-a")
     flags+=("--show-labels")
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const crdResourceName = "customresourcedefinitions.apiextensions.k8s.io"
+
+//CustomResourceDefinition is a subset of apiextensions.k8s.io CustomResourceDefinition
+//which is needed to mirror the defined resource. Both v1 and v1beta1 versions are supported
+type CustomResourceDefinition struct {
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       CustomResourceDefinitionSpec `json:"spec"`
+}
+
+type CustomResourceDefinitionSpec struct {
+	Group    string                            `json:"group"`
+	Version  string                            `json:"version,omitempty"`
+	Versions []CustomResourceDefinitionVersion `json:"versions,omitempty"`
+	Names    CustomResourceDefinitionNames     `json:"names"`
+	Scope    string                            `json:"scope"`
+}
+
+type CustomResourceDefinitionVersion struct {
+	Name    string `json:"name"`
+	Served  bool   `json:"served"`
+	Storage bool   `json:"storage"`
+}
+
+type CustomResourceDefinitionNames struct {
+	Plural     string   `json:"plural"`
+	Singular   string   `json:"singular,omitempty"`
+	Kind       string   `json:"kind"`
+	ShortNames []string `json:"shortNames,omitempty"`
+}
+
+type CustomResourceDefinitionList struct {
+	ListMeta `json:"metadata,omitempty"`
+	Items    []CustomResourceDefinition `json:"items"`
+}
+
+type CRDEvent struct {
+	Type   EventType                 `json:"type"`
+	Object *CustomResourceDefinition `json:"object"`
+}
+
+//resource returns description of the resource defined by the CRD.
+//The storage version is used if it is served, otherwise the first served version
+func (crd *CustomResourceDefinition) resource() KubeResource {
+	version := crd.Spec.Version
+	for _, v := range crd.Spec.Versions {
+		if v.Served && (v.Storage || version == "") {
+			version = v.Name
+		}
+	}
+
+	return newKubeResource(crd.Spec.Group, version, APIResource{
+		Name:         crd.Spec.Names.Plural,
+		SingularName: crd.Spec.Names.Singular,
+		Namespaced:   crd.Spec.Scope == "Namespaced",
+		Kind:         crd.Spec.Names.Kind,
+		Verbs:        []string{"list", "watch"},
+		ShortNames:   crd.Spec.Names.ShortNames,
+	})
+}
+
+//syncCRDs makes the mirrored custom resources match the listed definitions. Resources of new definitions
+//are started, and resources of definitions deleted while the watch was down are stopped
+func (m *mirror) syncCRDs(crds []CustomResourceDefinition) {
+	listed := make(map[resourceKey]bool)
+	for i := range crds {
+		r := crds[i].resource()
+		listed[r.key()] = true
+		m.startCustom(r)
+	}
+	for _, r := range m.customResources() {
+		if !listed[r.key()] {
+			m.stop(r)
+		}
+	}
+}
+
+//loopWatchCRDs starts mirroring of a custom resource when its definition is created,
+//and stops it when the definition is deleted. Definitions are listed on each (re)connect,
+//so that changes missed while the watch was down are applied, and then watched from the
+//resource version of the list. The loop ends when the mirror is stopped
+func (m *mirror) loopWatchCRDs() {
+	l := log.WithField("kind", "customresourcedefinition").WithField("server", m.kc.Server().URL)
+	b := m.backoff
+
+	//watch starts and stops loops of custom resources, and returns the number of received events
+	watch := func(resourceVersion string) (int, error) {
+		events := make(chan *CRDEvent)
+		done := make(chan int)
+		go func() {
+			n := 0
+			for e := range events {
+				n++
+				if e.Object == nil {
+					continue
+				}
+				r := e.Object.resource()
+				l.
+					WithField("name", e.Object.Name).
+					WithField("type", e.Type).
+					Info("received event")
+				switch e.Type {
+				case Deleted:
+					m.stop(r)
+				case Added, Modified:
+					m.startCustom(r)
+				}
+			}
+			done <- n
+		}()
+
+		l.WithField("resourceVersion", resourceVersion).Info("started to watch")
+		err := m.kc.WatchCustomResourceDefinitions(m.ctx, resourceVersion, events)
+		close(events)
+		return <-done, err
+	}
+
+	loop := func() {
+		for !isStopped(m.ctx) {
+			list, err := m.kc.GetCustomResourceDefinitions(m.ctx)
+			if err != nil {
+				if !isStopped(m.ctx) {
+					retry(m.ctx, l.WithField("error", err), "failed to list definitions", &b)
+				}
+				continue
+			}
+			m.syncCRDs(list.Items)
+
+			start := time.Now()
+			n, err := watch(list.ResourceVersion)
+			switch {
+			case isStopped(m.ctx):
+			case isGone(err):
+				l.WithField("error", err).Info("resource version is too old, listing definitions again")
+			case err != nil:
+				retry(m.ctx, l.WithField("error", err), "watch connection failed", &b)
+			case n == 0 && time.Since(start) < minWatchDuration:
+				retry(m.ctx, l, "watch connection was closed without events", &b)
+			default:
+				b.Reset()
+				l.Info("watch connection was closed, resuming")
+			}
+		}
+	}
+
+	go loop()
+}
@@ -0,0 +1,267 @@
+package app
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCRDResource(t *testing.T) {
+	tests := []struct {
+		spec     CustomResourceDefinitionSpec
+		expected KubeResource
+	}{
+		{
+			spec: CustomResourceDefinitionSpec{
+				Group:   "cert-manager.io",
+				Version: "v1alpha1",
+				Names:   CustomResourceDefinitionNames{Plural: "certificates", Singular: "certificate", Kind: "Certificate", ShortNames: []string{"cert"}},
+				Scope:   "Namespaced",
+			},
+			expected: KubeResource{Group: "cert-manager.io", Version: "v1alpha1", Name: "certificates", Singular: "certificate", Kind: "Certificate", ShortNames: []string{"cert"}, Namespaced: true, Verbs: []string{"list", "watch"}},
+		},
+		{
+			spec: CustomResourceDefinitionSpec{
+				Group: "kafka.strimzi.io",
+				Versions: []CustomResourceDefinitionVersion{
+					{Name: "v1alpha1", Served: false},
+					{Name: "v1beta1", Served: true},
+					{Name: "v1beta2", Served: true, Storage: true},
+				},
+				Names: CustomResourceDefinitionNames{Plural: "kafkatopics", Kind: "KafkaTopic"},
+				Scope: "Cluster",
+			},
+			expected: KubeResource{Group: "kafka.strimzi.io", Version: "v1beta2", Name: "kafkatopics", Singular: "kafkatopic", Kind: "KafkaTopic", Verbs: []string{"list", "watch"}},
+		},
+		{
+			spec: CustomResourceDefinitionSpec{
+				Group: "networking.istio.io",
+				Versions: []CustomResourceDefinitionVersion{
+					{Name: "v1alpha3", Served: true},
+					{Name: "v1beta1", Served: true},
+					{Name: "v1", Served: false, Storage: true},
+				},
+				Names: CustomResourceDefinitionNames{Plural: "virtualservices", Singular: "virtualservice", Kind: "VirtualService"},
+				Scope: "Namespaced",
+			},
+			expected: KubeResource{Group: "networking.istio.io", Version: "v1alpha3", Name: "virtualservices", Singular: "virtualservice", Kind: "VirtualService", Namespaced: true, Verbs: []string{"list", "watch"}},
+		},
+	}
+
+	for i, test := range tests {
+		crd := CustomResourceDefinition{Spec: test.spec}
+		assert.Equal(t, test.expected, crd.resource(), "test %d", i)
+	}
+}
+
+func TestMirrorCRDs(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	kc.objectsF = func() []KubeObject {
+		return []KubeObject{{TypeMeta: TypeMeta{Kind: "kafkatopic", Group: "kafka.strimzi.io"}, ObjectMeta: ObjectMeta{Name: "topic1"}}}
+	}
+	crd := &CustomResourceDefinition{
+		ObjectMeta: ObjectMeta{Name: "kafkatopics.kafka.strimzi.io"},
+		Spec: CustomResourceDefinitionSpec{
+			Group:   "kafka.strimzi.io",
+			Version: "v1beta1",
+			Names:   CustomResourceDefinitionNames{Plural: "kafkatopics", Kind: "KafkaTopic"},
+			Scope:   "Namespaced",
+		},
+	}
+	kc.crdEvents = []*CRDEvent{{Added, crd}}
+
+	m := newMirror(context.Background(), c, kc, "", "", testBackoff)
+	m.loopWatchCRDs()
+	time.Sleep(50 * time.Millisecond)
+
+	var objects []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Kind: "kafkatopics.kafka.strimzi.io"}, allowAll{}, &objects)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []KubeObject{{TypeMeta: TypeMeta{Kind: "kafkatopic", Group: "kafka.strimzi.io"}, ObjectMeta: ObjectMeta{Name: "topic1"}}}, objects)
+	}
+
+	m.stop(crd.resource())
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, c.serverObjects(kc.Server()), "must remove objects of deleted CRD")
+
+	err = c.Objects(context.Background(), &MrrFilter{Kind: "kafkatopic"}, allowAll{}, &objects)
+	assert.Error(t, err, "must forget resource of deleted CRD")
+}
+
+func TestMirrorCRDsDeletedWhileWatchIsDown(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	kc.objectsF = func() []KubeObject {
+		return []KubeObject{{TypeMeta: TypeMeta{Kind: "kafkatopic", Group: "kafka.strimzi.io"}, ObjectMeta: ObjectMeta{Name: "topic1"}}}
+	}
+	kc.resourceVersion = "5"
+	kc.crdWatchCloses = time.Millisecond
+	crd := CustomResourceDefinition{
+		ObjectMeta: ObjectMeta{Name: "kafkatopics.kafka.strimzi.io"},
+		Spec: CustomResourceDefinitionSpec{
+			Group:   "kafka.strimzi.io",
+			Version: "v1beta1",
+			Names:   CustomResourceDefinitionNames{Plural: "kafkatopics", Kind: "KafkaTopic"},
+			Scope:   "Namespaced",
+		},
+	}
+	kc.setCRDs([]CustomResourceDefinition{crd})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newMirror(ctx, c, kc, "", "", testBackoff)
+	m.loopWatchCRDs()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, kc.watchHits("kafkatopic.kafka.strimzi.io"), "must mirror listed definition")
+
+	kc.setCRDs(nil)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, c.serverObjects(kc.Server()), "must remove objects of definition deleted while the watch was down")
+	assert.Equal(t, 0, kc.watches(), "must stop watching resource of deleted definition")
+
+	assert.True(t, kc.counter(&kc.crdLists) > 1, "must list definitions on each reconnect")
+	assert.Equal(t, "5", kc.crdWatchVersions()[0], "must watch from the resource version of the list")
+}
+
+func TestMirrorCRDWithNameOfCoreResource(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	kc.objectsF = func() []KubeObject {
+		return []KubeObject{{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "svc1", Namespace: "default"}}}
+	}
+	crd := &CustomResourceDefinition{
+		ObjectMeta: ObjectMeta{Name: "services.serving.knative.dev"},
+		Spec: CustomResourceDefinitionSpec{
+			Group:   "serving.knative.dev",
+			Version: "v1",
+			Names:   CustomResourceDefinitionNames{Plural: "services", Singular: "service", Kind: "Service", ShortNames: []string{"ksvc"}},
+			Scope:   "Namespaced",
+		},
+	}
+
+	m := newMirror(context.Background(), c, kc, "", "", testBackoff)
+	core, _ := defaultRegistry.Lookup("service")
+	c.setResources(kc.Server(), []KubeResource{core})
+	m.start(core)
+	m.start(crd.resource())
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, 1, kc.watchHits("service"))
+	assert.Equal(t, 1, kc.watchHits("service.serving.knative.dev"), "must mirror custom resource named as a core resource")
+
+	m.stop(crd.resource())
+	time.Sleep(50 * time.Millisecond)
+
+	var objects []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Kind: "svc"}, allowAll{}, &objects)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"svc1"}, names(objects), "must keep objects of the core resource")
+	}
+	assert.Equal(t, 1, kc.watches(), "must keep watching the core resource")
+}
+
+func TestMirrorStartIsIdempotent(t *testing.T) {
+	kc := NewTestKubeClient()
+	m := newMirror(context.Background(), NewMrrCache(), kc, "", "", testBackoff)
+
+	r := KubeResource{Version: "v1", Name: "secrets", Singular: "secret", Verbs: []string{"list"}}
+	m.start(r)
+	m.start(r)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, 1, kc.getHits("secret"))
+	assert.Equal(t, 1, kc.watchHits("secret"))
+}
+
+func TestMirrorCRDDeletedAndCreatedAgain(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	kc.objectsF = func() []KubeObject {
+		return []KubeObject{{TypeMeta: TypeMeta{Kind: "kafkatopic", Group: "kafka.strimzi.io"}, ObjectMeta: ObjectMeta{Name: "topic1"}}}
+	}
+	crd := CustomResourceDefinition{
+		ObjectMeta: ObjectMeta{Name: "kafkatopics.kafka.strimzi.io"},
+		Spec: CustomResourceDefinitionSpec{
+			Group:   "kafka.strimzi.io",
+			Version: "v1beta1",
+			Names:   CustomResourceDefinitionNames{Plural: "kafkatopics", Kind: "KafkaTopic"},
+			Scope:   "Namespaced",
+		},
+	}
+
+	m := newMirror(context.Background(), c, kc, "", "", testBackoff)
+	m.startCustom(crd.resource())
+	time.Sleep(50 * time.Millisecond)
+
+	m.stop(crd.resource())
+	m.startCustom(crd.resource())
+	time.Sleep(50 * time.Millisecond)
+
+	var objects []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Kind: "kafkatopic"}, allowAll{}, &objects)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"topic1"}, names(objects), "stopped loop must not remove objects of the new one")
+	}
+	assert.Equal(t, 1, len(c.status().Resources), "stopped loop must not remove status of the new one")
+	assert.Equal(t, 1, kc.watches())
+	m.stopAll()
+}
+
+func TestMirrorCRDWithChangedVersion(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	spec := CustomResourceDefinitionSpec{
+		Group:   "kafka.strimzi.io",
+		Version: "v1beta1",
+		Names:   CustomResourceDefinitionNames{Plural: "kafkatopics", Kind: "KafkaTopic"},
+		Scope:   "Namespaced",
+	}
+	crd := &CustomResourceDefinition{ObjectMeta: ObjectMeta{Name: "kafkatopics.kafka.strimzi.io"}, Spec: spec}
+	spec.Version = "v1beta2"
+	changed := &CustomResourceDefinition{ObjectMeta: ObjectMeta{Name: "kafkatopics.kafka.strimzi.io"}, Spec: spec}
+	kc.crdEvents = []*CRDEvent{{Added, crd}, {Modified, changed}, {Modified, changed}}
+
+	m := newMirror(context.Background(), c, kc, "", "", testBackoff)
+	m.loopWatchCRDs()
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, 2, kc.getHits("kafkatopic.kafka.strimzi.io"), "must mirror the resource again when its version changes")
+	assert.Equal(t, 1, kc.watches())
+	m.mu.Lock()
+	assert.Equal(t, "v1beta2", m.loops[changed.resource().key()].resource.Version)
+	m.mu.Unlock()
+	m.stopAll()
+}
+
+func TestMirrorCRDsClosedWithoutEvents(t *testing.T) {
+	defer func(d time.Duration) { minWatchDuration = d }(minWatchDuration)
+	minWatchDuration = 20 * time.Millisecond
+	b := Backoff{Initial: time.Second, Max: time.Second, Factor: 1}
+
+	tests := []struct {
+		closes   time.Duration
+		expected int
+		msg      string
+	}{
+		{time.Millisecond, 1, "watch closed at once must be retried with backoff"},
+		{30 * time.Millisecond, 3, "quiet watch must be resumed at once"},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		kc := NewTestKubeClient()
+		kc.crdWatchCloses = test.closes
+		m := newMirror(ctx, NewMrrCache(), kc, "", "", b)
+		m.loopWatchCRDs()
+		time.Sleep(150 * time.Millisecond)
+		cancel()
+
+		lists := kc.counter(&kc.crdLists)
+		if test.expected == 1 {
+			assert.Equal(t, 1, lists, test.msg)
+		} else {
+			assert.True(t, lists >= test.expected, "%s, listed %d times", test.msg, lists)
+		}
+	}
+}
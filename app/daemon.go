@@ -0,0 +1,643 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+//daemonStopTimeout is how long the daemon is given to exit after SIGTERM, before it is killed
+var daemonStopTimeout = 10 * time.Second
+
+//daemonPollInterval is the interval between checks whether the daemon has started or stopped
+var daemonPollInterval = 100 * time.Millisecond
+
+//DaemonFiles are the files of the daemon: the pid file keeps the pid of the running process,
+//the args file keeps its arguments for restarts, and the log file receives its output
+type DaemonFiles struct {
+	PidFile string
+	LogFile string
+}
+
+func (df DaemonFiles) argsFile() string {
+	return strings.TrimSuffix(df.PidFile, filepath.Ext(df.PidFile)) + ".args"
+}
+
+//Daemon is "kubemrr watch" running in the background
+type Daemon interface {
+	//Start starts the process with the arguments and records its pid. If the recorded process
+	//is running, its pid is returned with errDaemonRunning
+	Start(args []string) (int, error)
+	//Pid returns pid of the recorded process, 0 if there is none, and whether the process is running.
+	//A pid file of a process which has exited is removed
+	Pid() (int, bool, error)
+	//Args returns the arguments of the recorded process
+	Args() ([]string, error)
+	//Stop terminates the recorded process and removes its pid file
+	Stop() error
+}
+
+//processDaemon runs the daemon as a detached child process
+type processDaemon struct {
+	files      DaemonFiles
+	executable string
+
+	//exited is closed when the process started by this daemon exits.
+	//Until it is waited for, the process is still seen as running
+	exited chan struct{}
+}
+
+func newProcessDaemon(files DaemonFiles) *processDaemon {
+	return &processDaemon{files: files}
+}
+
+//errDaemonRunning is returned by Start with the pid of the daemon which is already running
+var errDaemonRunning = errors.New("kubemrr daemon is already running")
+
+func (d *processDaemon) Start(args []string) (int, error) {
+	executable, err := d.executablePath()
+	if err != nil {
+		return 0, fmt.Errorf("could not find kubemrr executable: %v", err)
+	}
+
+	for _, file := range []string{d.files.PidFile, d.files.LogFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return 0, err
+		}
+	}
+
+	//the pid file is locked until the pid is written, so that commands autostarting the daemon
+	//at the same time start it once
+	pidFile, err := d.lockPidFile(syscall.LOCK_EX)
+	if err != nil {
+		return 0, fmt.Errorf("could not lock pid file: %v", err)
+	}
+	defer pidFile.Close()
+	raw, err := ioutil.ReadAll(pidFile)
+	if err != nil {
+		return 0, fmt.Errorf("could not read pid file: %v", err)
+	}
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(raw))); err == nil && pid > 0 && d.isRunning(pid) {
+		return pid, errDaemonRunning
+	}
+
+	out, err := os.OpenFile(d.files.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("could not open log file: %v", err)
+	}
+	defer out.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	//the daemon must not be stopped together with the shell that started it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("could not start daemon: %v", err)
+	}
+
+	d.exited = make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(d.exited)
+	}()
+
+	pid := cmd.Process.Pid
+	if err := ioutil.WriteFile(d.files.argsFile(), []byte(strings.Join(args, "\n")+"\n"), 0600); err != nil {
+		log.WithField("error", err).Warn("could not write arguments of daemon, restart will require them")
+	}
+	if err := pidFile.Truncate(0); err != nil {
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("could not write pid file: %v", err)
+	}
+	if _, err := pidFile.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0); err != nil {
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("could not write pid file: %v", err)
+	}
+	return pid, nil
+}
+
+//lockPidFile opens and locks the pid file, creating it if needed. The lock is released
+//when the file is closed
+func (d *processDaemon) lockPidFile(how int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(d.files.PidFile, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), how); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		//the file may have been removed while the lock was awaited
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(d.files.PidFile); err == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+//removePidFile removes the pid file, unless the daemon is being started
+func (d *processDaemon) removePidFile() {
+	f, err := d.lockPidFile(syscall.LOCK_EX | syscall.LOCK_NB)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	os.Remove(d.files.PidFile)
+}
+
+//executablePath returns the path of kubemrr executable which runs the daemon
+func (d *processDaemon) executablePath() (string, error) {
+	if d.executable == "" {
+		return os.Executable()
+	}
+	return exec.LookPath(d.executable)
+}
+
+func (d *processDaemon) Pid() (int, bool, error) {
+	raw, err := ioutil.ReadFile(d.files.PidFile)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	//the pid file is empty while the daemon is being started
+	if strings.TrimSpace(string(raw)) == "" {
+		return 0, false, nil
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil || pid <= 0 {
+		return 0, false, fmt.Errorf("pid file %s is corrupted, remove it", d.files.PidFile)
+	}
+
+	if !d.isRunning(pid) {
+		log.WithField("pid", pid).WithField("file", d.files.PidFile).Debug("removing pid file of exited daemon")
+		d.removePidFile()
+		return pid, false, nil
+	}
+	return pid, true, nil
+}
+
+//isRunning tells whether the process is alive and is still kubemrr. The pid of an exited
+//daemon can be reused by another process, which is recognised on Linux by its executable and command line:
+//the daemon runs this executable with the arguments it was started with
+func (d *processDaemon) isRunning(pid int) bool {
+	if d.exited != nil {
+		select {
+		case <-d.exited:
+			return false
+		default:
+		}
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if err := p.Signal(syscall.Signal(0)); err != nil {
+		return false
+	}
+
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		//the executable of a running daemon is shown as deleted when kubemrr is upgraded
+		if expected, err := d.executablePath(); err == nil && !sameFile(strings.TrimSuffix(exe, " (deleted)"), expected) {
+			return false
+		}
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return true
+	}
+	argv := strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	if expected, err := d.executablePath(); err == nil && !sameFile(argv[0], expected) {
+		return false
+	}
+	args, err := d.Args()
+	if err != nil || len(args) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(argv[1:], args)
+}
+
+//sameFile tells whether the paths point to the same file after symlinks are resolved
+func sameFile(a, b string) bool {
+	if ra, err := filepath.EvalSymlinks(a); err == nil {
+		a = ra
+	}
+	if rb, err := filepath.EvalSymlinks(b); err == nil {
+		b = rb
+	}
+	return a == b
+}
+
+func (d *processDaemon) Args() ([]string, error) {
+	raw, err := ioutil.ReadFile(d.files.argsFile())
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(raw), "\n"), "\n"), nil
+}
+
+func (d *processDaemon) Stop() error {
+	pid, running, err := d.Pid()
+	if err != nil {
+		return err
+	}
+	if !running {
+		return nil
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("could not stop daemon %d: %v", pid, err)
+	}
+
+	deadline := time.Now().Add(daemonStopTimeout)
+	for d.isRunning(pid) {
+		if time.Now().After(deadline) {
+			log.WithField("pid", pid).Warn("daemon did not stop in time, killing")
+			p.Kill()
+			break
+		}
+		time.Sleep(daemonPollInterval)
+	}
+	d.removePidFile()
+	return nil
+}
+
+func NewDaemonCommand(f Factory) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "daemon",
+		Short: "Run the mirror in the background",
+		Long: `
+DESCRIPTION:
+  Start, stop and inspect "kubemrr watch" running in the background.
+
+  The pid of the daemon is kept in --pid-file, and its output is appended to --log-file.
+  A pid file of a daemon which has exited is detected and removed. The daemon is
+  reported as stale when it does not respond, or runs another version of kubemrr.
+
+  Flags of the mirror endpoint, such as --address, --port, --socket and the TLS flags,
+  are passed to "kubemrr watch". Other flags of watch are given with --watch-flags.
+  Restart without contexts reuses the arguments of the running daemon.
+
+EXAMPLE:
+  kubemrr daemon start --all-contexts
+  kubemrr daemon start --socket=~/.kubemrr/kubemrr.sock --watch-flags="--exclude=events,secrets" 'prod-*'
+  kubemrr daemon status
+  kubemrr daemon restart
+  kubemrr daemon stop
+`,
+	}
+
+	start := &cobra.Command{
+		Use:   "start [flags] [context]...",
+		Short: "Start the mirror in the background",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := RunCommon(cmd); err != nil {
+				return err
+			}
+			return RunDaemonStart(f, cmd, args)
+		},
+	}
+	addDaemonWatchFlags(start)
+
+	stop := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the mirror running in the background",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := RunCommon(cmd); err != nil {
+				return err
+			}
+			return RunDaemonStop(f, cmd, args)
+		},
+	}
+	AddCommonFlags(stop)
+	AddDaemonFlags(stop)
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Tell whether the mirror is running in the background",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := RunCommon(cmd); err != nil {
+				return err
+			}
+			return RunDaemonStatus(f, cmd, args)
+		},
+	}
+	AddCommonFlags(status)
+	AddEndpointSecurityFlags(status)
+	AddDaemonFlags(status)
+
+	restart := &cobra.Command{
+		Use:   "restart [flags] [context]...",
+		Short: "Restart the mirror running in the background",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := RunCommon(cmd); err != nil {
+				return err
+			}
+			return RunDaemonRestart(f, cmd, args)
+		},
+	}
+	addDaemonWatchFlags(restart)
+
+	cmd.AddCommand(start, stop, status, restart)
+	return cmd
+}
+
+//AddDaemonFlags adds flags with the files of the daemon
+func AddDaemonFlags(cmd *cobra.Command) {
+	cmd.Flags().String("pid-file", "~/.kubemrr/kubemrr.pid", "File with the pid of the daemon")
+	cmd.Flags().String("log-file", "~/.kubemrr/kubemrr.log", "File where output of the daemon is appended")
+}
+
+func addDaemonWatchFlags(cmd *cobra.Command) {
+	AddCommonFlags(cmd)
+	AddEndpointSecurityFlags(cmd)
+	AddDaemonFlags(cmd)
+	cmd.Flags().Duration("wait", 10*time.Second, "How long to wait until the started daemon responds")
+	cmd.Flags().Bool("all-contexts", false, "Mirror servers of all contexts in kubeconfig")
+	cmd.Flags().String("watch-flags", "", "Other flags passed to kubemrr watch")
+}
+
+//GetDaemonFiles returns the files given by --pid-file and --log-file
+func GetDaemonFiles(cmd *cobra.Command) (DaemonFiles, error) {
+	var df DaemonFiles
+	for flag, value := range map[string]*string{"pid-file": &df.PidFile, "log-file": &df.LogFile} {
+		raw, err := cmd.Flags().GetString(flag)
+		if err != nil {
+			return df, err
+		}
+		if *value, err = substituteUserHome(raw); err != nil {
+			return df, fmt.Errorf("invalid --%s: %v", flag, err)
+		}
+	}
+	return df, nil
+}
+
+//daemonForwardedFlags are flags given to daemon commands that are passed to "kubemrr watch"
+var daemonForwardedFlags = []string{
+	"address", "port", "socket", "kubeconfig", "verbose",
+	"tls", "tls-cert", "tls-key", "tls-ca", "token-file",
+}
+
+//daemonWatchArgs returns arguments of "kubemrr watch" for the flags and contexts given to the command
+func daemonWatchArgs(cmd *cobra.Command, contexts []string) ([]string, error) {
+	res := []string{"watch"}
+	for _, name := range daemonForwardedFlags {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || !flag.Changed {
+			continue
+		}
+		value := flag.Value.String()
+		//the daemon may run in another directory than the one where it was started
+		if strings.HasSuffix(name, "-file") || strings.HasPrefix(name, "tls-") || name == "socket" || name == "kubeconfig" {
+			abs, err := substituteUserHome(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --%s: %v", name, err)
+			}
+			if value, err = filepath.Abs(abs); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, "--"+name+"="+value)
+	}
+
+	if allContexts, _ := cmd.Flags().GetBool("all-contexts"); allContexts {
+		res = append(res, "--all-contexts")
+	}
+	if watchFlags, _ := cmd.Flags().GetString("watch-flags"); watchFlags != "" {
+		res = append(res, strings.Fields(watchFlags)...)
+	}
+	return append(res, contexts...), nil
+}
+
+func RunDaemonStart(f Factory, cmd *cobra.Command, args []string) error {
+	allContexts, err := cmd.Flags().GetBool("all-contexts")
+	if err != nil {
+		return errors.New("could not parse value of --all-contexts")
+	}
+	if len(args) < 1 && !allContexts {
+		return errors.New("at least one argument is required, either url or context name")
+	}
+
+	watchArgs, err := daemonWatchArgs(cmd, args)
+	if err != nil {
+		return err
+	}
+	return startDaemon(f, cmd, watchArgs)
+}
+
+//startDaemon starts the daemon with the arguments, unless it is already running,
+//and waits until the mirror responds
+func startDaemon(f Factory, cmd *cobra.Command, watchArgs []string) error {
+	files, err := GetDaemonFiles(cmd)
+	if err != nil {
+		return err
+	}
+	d := f.Daemon(files)
+	if pid, running, err := d.Pid(); err != nil {
+		return err
+	} else if running {
+		return fmt.Errorf("kubemrr daemon is already running with pid %d", pid)
+	}
+
+	pid, err := d.Start(watchArgs)
+	if err == errDaemonRunning {
+		return fmt.Errorf("kubemrr daemon is already running with pid %d", pid)
+	}
+	if err != nil {
+		return err
+	}
+	if err := waitForDaemon(f, cmd, d); err != nil {
+		return err
+	}
+	fmt.Fprintf(f.StdOut(), "kubemrr daemon is started with pid %d\n", pid)
+	return nil
+}
+
+//waitForDaemon waits until the mirror started by the daemon responds
+func waitForDaemon(f Factory, cmd *cobra.Command, d Daemon) error {
+	wait, err := cmd.Flags().GetDuration("wait")
+	if err != nil {
+		return err
+	}
+	files, err := GetDaemonFiles(cmd)
+	if err != nil {
+		return err
+	}
+	endpoint, err := GetEndpoint(cmd)
+	if err != nil {
+		return err
+	}
+	client, err := f.MrrClient(endpoint)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		if _, err = client.Version(); err == nil {
+			return nil
+		}
+		if _, running, _ := d.Pid(); !running {
+			return fmt.Errorf("kubemrr daemon has exited, see %s", files.LogFile)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("kubemrr daemon does not respond after %s, see %s: %v", wait, files.LogFile, err)
+		}
+		time.Sleep(daemonPollInterval)
+	}
+}
+
+func RunDaemonStop(f Factory, cmd *cobra.Command, args []string) error {
+	files, err := GetDaemonFiles(cmd)
+	if err != nil {
+		return err
+	}
+	d := f.Daemon(files)
+	pid, running, err := d.Pid()
+	if err != nil {
+		return err
+	}
+	if !running {
+		fmt.Fprintln(f.StdOut(), "kubemrr daemon is not running")
+		return nil
+	}
+
+	if err := d.Stop(); err != nil {
+		return err
+	}
+	fmt.Fprintf(f.StdOut(), "kubemrr daemon with pid %d is stopped\n", pid)
+	return nil
+}
+
+//RunDaemonStatus prints the state of the daemon. An error is returned unless it runs and responds
+func RunDaemonStatus(f Factory, cmd *cobra.Command, args []string) error {
+	files, err := GetDaemonFiles(cmd)
+	if err != nil {
+		return err
+	}
+	pid, running, err := f.Daemon(files).Pid()
+	if err != nil {
+		return err
+	}
+	if !running {
+		if pid != 0 {
+			return fmt.Errorf("kubemrr daemon with pid %d has exited, see %s", pid, files.LogFile)
+		}
+		return errors.New("kubemrr daemon is not running")
+	}
+
+	endpoint, err := GetEndpoint(cmd)
+	if err != nil {
+		return err
+	}
+	client, err := f.MrrClient(endpoint)
+	if err != nil {
+		return err
+	}
+	versions, err := client.Version()
+	if err != nil {
+		return fmt.Errorf("kubemrr daemon with pid %d is stale, it does not respond at %s: %v", pid, endpoint.Address, err)
+	}
+	if versions.Kubemrr != VERSION {
+		return fmt.Errorf("kubemrr daemon with pid %d is stale, it runs kubemrr %s instead of %s; run kubemrr daemon restart", pid, versions.Kubemrr, VERSION)
+	}
+
+	fmt.Fprintf(f.StdOut(), "kubemrr daemon is running with pid %d at %s\n", pid, endpoint.Address)
+	return nil
+}
+
+func RunDaemonRestart(f Factory, cmd *cobra.Command, args []string) error {
+	files, err := GetDaemonFiles(cmd)
+	if err != nil {
+		return err
+	}
+	d := f.Daemon(files)
+
+	var watchArgs []string
+	if allContexts, _ := cmd.Flags().GetBool("all-contexts"); len(args) > 0 || allContexts {
+		if watchArgs, err = daemonWatchArgs(cmd, args); err != nil {
+			return err
+		}
+	} else if watchArgs, err = d.Args(); err != nil {
+		return fmt.Errorf("arguments of the previous daemon are unknown, give contexts to mirror: %v", err)
+	}
+
+	if err := d.Stop(); err != nil {
+		return err
+	}
+	return startDaemon(f, cmd, watchArgs)
+}
+
+//isUnreachable tells whether the error is caused by a mirror that does not accept connections
+func isUnreachable(err error) bool {
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	oe, ok := err.(*net.OpError)
+	return ok && oe.Op == "dial"
+}
+
+type TestDaemon struct {
+	pid     int
+	running bool
+	args    []string
+	started [][]string
+	stops   int
+	onStart func()
+}
+
+func (d *TestDaemon) Start(args []string) (int, error) {
+	d.pid += 1
+	d.running = true
+	d.args = args
+	d.started = append(d.started, args)
+	if d.onStart != nil {
+		d.onStart()
+	}
+	return d.pid, nil
+}
+
+func (d *TestDaemon) Pid() (int, bool, error) {
+	return d.pid, d.running, nil
+}
+
+func (d *TestDaemon) Args() ([]string, error) {
+	if d.args == nil {
+		return nil, errors.New("no arguments")
+	}
+	return d.args, nil
+}
+
+func (d *TestDaemon) Stop() error {
+	d.stops += 1
+	d.running = false
+	return nil
+}
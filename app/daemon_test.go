@@ -0,0 +1,226 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDaemonWatchArgs(t *testing.T) {
+	cmd := NewDaemonCommand(NewTestFactory())
+	start, _, err := cmd.Find([]string{"start"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start.Flags().Set("port", "34034")
+	start.Flags().Set("tls-ca", "ca.pem")
+	start.Flags().Set("all-contexts", "true")
+	start.Flags().Set("watch-flags", "--exclude=events,secrets  --cache-interval=5m")
+
+	ca, _ := filepath.Abs("ca.pem")
+	args, err := daemonWatchArgs(start, []string{"dev"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"watch", "--port=34034", "--tls-ca=" + ca, "--all-contexts",
+		"--exclude=events,secrets", "--cache-interval=5m", "dev",
+	}, args)
+}
+
+func TestProcessDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := DaemonFiles{PidFile: path.Join(dir, "run", "kubemrr.pid"), LogFile: path.Join(dir, "kubemrr.log")}
+	d := newProcessDaemon(files)
+	d.executable = "sleep"
+
+	pid, err := d.Start([]string{"30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, running, err := d.Pid()
+	assert.NoError(t, err)
+	assert.True(t, running)
+	assert.Equal(t, pid, actual)
+	args, err := newProcessDaemon(files).Args()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"30"}, args)
+
+	assert.NoError(t, d.Stop())
+	_, running, err = d.Pid()
+	assert.NoError(t, err)
+	assert.False(t, running)
+	_, err = os.Stat(files.PidFile)
+	assert.True(t, os.IsNotExist(err), "pid file must be removed")
+}
+
+func TestProcessDaemonStartsOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := DaemonFiles{PidFile: path.Join(dir, "kubemrr.pid"), LogFile: path.Join(dir, "kubemrr.log")}
+	type started struct {
+		pid int
+		err error
+	}
+	results := make(chan started)
+	for i := 0; i < 3; i++ {
+		go func() {
+			d := newProcessDaemon(files)
+			d.executable = "sleep"
+			pid, err := d.Start([]string{"30"})
+			results <- started{pid, err}
+		}()
+	}
+
+	pids := map[int]bool{}
+	runningErrors := 0
+	for i := 0; i < 3; i++ {
+		r := <-results
+		pids[r.pid] = true
+		if r.err == errDaemonRunning {
+			runningErrors++
+		} else {
+			assert.NoError(t, r.err)
+		}
+	}
+	assert.Equal(t, 1, len(pids), "daemon must be started once")
+	assert.Equal(t, 2, runningErrors)
+
+	d := newProcessDaemon(files)
+	d.executable = "sleep"
+	assert.NoError(t, d.Stop())
+}
+
+func TestProcessDaemonStalePidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := DaemonFiles{PidFile: path.Join(dir, "kubemrr.pid"), LogFile: path.Join(dir, "kubemrr.log")}
+	d := newProcessDaemon(files)
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(files.PidFile, []byte(strconv.Itoa(exited.ProcessState.Pid())), 0600)
+	pid, running, err := d.Pid()
+	assert.NoError(t, err)
+	assert.False(t, running, "pid of exited process")
+	assert.Equal(t, exited.ProcessState.Pid(), pid)
+	_, err = os.Stat(files.PidFile)
+	assert.True(t, os.IsNotExist(err), "stale pid file must be removed")
+
+	if _, err := os.Stat("/proc/self/cmdline"); err == nil {
+		//the pid is reused by a process which is not kubemrr watch
+		ioutil.WriteFile(files.PidFile, []byte(strconv.Itoa(os.Getpid())), 0600)
+		ioutil.WriteFile(files.argsFile(), []byte("watch\n--all-contexts\n"), 0600)
+		_, running, err = d.Pid()
+		assert.NoError(t, err)
+		assert.False(t, running, "pid of another process")
+
+		//the pid is of kubemrr started with the arguments
+		if len(os.Args) > 1 {
+			ioutil.WriteFile(files.PidFile, []byte(strconv.Itoa(os.Getpid())), 0600)
+			ioutil.WriteFile(files.argsFile(), []byte(strings.Join(os.Args[1:], "\n")+"\n"), 0600)
+			_, running, err = d.Pid()
+			assert.NoError(t, err)
+			assert.True(t, running, "pid of kubemrr with the same arguments")
+		}
+
+		//the pid is reused by another program with the same arguments
+		other := exec.Command("sleep", "30")
+		if err := other.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer other.Process.Kill()
+		ioutil.WriteFile(files.PidFile, []byte(strconv.Itoa(other.Process.Pid)), 0600)
+		ioutil.WriteFile(files.argsFile(), []byte("30\n"), 0600)
+		_, running, err = d.Pid()
+		assert.NoError(t, err)
+		assert.False(t, running, "pid of another program")
+	}
+
+	ioutil.WriteFile(files.PidFile, []byte("garbage"), 0600)
+	_, _, err = d.Pid()
+	assert.Error(t, err)
+}
+
+func TestRunDaemonStatus(t *testing.T) {
+	tc := &TestMirrorClient{}
+	buf := bytes.NewBuffer([]byte{})
+	f := &TestFactory{mrrClient: tc, stdOut: buf, daemon: &TestDaemon{}}
+	cmd, _, _ := NewDaemonCommand(f).Find([]string{"status"})
+
+	err := RunDaemonStatus(f, cmd, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not running")
+	}
+
+	f.daemon.Start([]string{"watch"})
+	tc.err = errors.New("timeout")
+	err = RunDaemonStatus(f, cmd, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is stale")
+	}
+
+	tc.err = nil
+	assert.NoError(t, RunDaemonStatus(f, cmd, nil))
+	assert.Equal(t, "kubemrr daemon is running with pid 1 at 127.0.0.1:33033\n", buf.String())
+}
+
+func TestRunDaemonRestart(t *testing.T) {
+	f := &TestFactory{mrrClient: &TestMirrorClient{}, stdOut: ioutil.Discard, daemon: &TestDaemon{}}
+	cmd, _, _ := NewDaemonCommand(f).Find([]string{"restart"})
+
+	err := RunDaemonRestart(f, cmd, nil)
+	assert.Error(t, err, "arguments of the previous daemon are unknown")
+
+	f.daemon.Start([]string{"watch", "dev"})
+	assert.NoError(t, RunDaemonRestart(f, cmd, nil))
+	assert.Equal(t, 1, f.daemon.stops)
+	assert.Equal(t, [][]string{{"watch", "dev"}, {"watch", "dev"}}, f.daemon.started)
+}
+
+func TestGetAutostart(t *testing.T) {
+	tc := &TestMirrorClient{
+		err:     &url.Error{Op: "Get", URL: "http://127.0.0.1:33033/api", Err: &net.OpError{Op: "dial", Net: "tcp"}},
+		objects: []KubeObject{{ObjectMeta: ObjectMeta{Name: "o1"}}},
+	}
+	buf := bytes.NewBuffer([]byte{})
+	f := &TestFactory{mrrClient: tc, stdOut: buf, daemon: &TestDaemon{}}
+	f.daemon.onStart = func() { tc.err = nil }
+
+	cmd := NewGetCommand(f)
+	err := RunGet(f, cmd, []string{"pod"})
+	assert.Error(t, err, "daemon must not be started without --autostart")
+	assert.Empty(t, f.daemon.started)
+
+	cmd.Flags().Set("autostart", "true")
+	cmd.Flags().Set("port", "34034")
+	err = RunGet(f, cmd, []string{"pod"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"watch", "--port=34034", "--all-contexts"}}, f.daemon.started)
+	assert.Equal(t, "o1", buf.String())
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"regexp"
+	"strings"
+)
+
+//APIResource is a description of a resource, as returned by the discovery endpoints
+//of Kubernetes API server
+type APIResource struct {
+	Name         string   `json:"name"`
+	SingularName string   `json:"singularName"`
+	Namespaced   bool     `json:"namespaced"`
+	Kind         string   `json:"kind"`
+	Verbs        []string `json:"verbs"`
+	ShortNames   []string `json:"shortNames,omitempty"`
+}
+
+type APIResourceList struct {
+	GroupVersion string        `json:"groupVersion"`
+	APIResources []APIResource `json:"resources"`
+}
+
+type APIVersions struct {
+	Versions []string `json:"versions"`
+}
+
+type GroupVersionForDiscovery struct {
+	GroupVersion string `json:"groupVersion"`
+	Version      string `json:"version"`
+}
+
+type APIGroup struct {
+	Name             string                     `json:"name"`
+	Versions         []GroupVersionForDiscovery `json:"versions"`
+	PreferredVersion GroupVersionForDiscovery   `json:"preferredVersion"`
+}
+
+type APIGroupList struct {
+	Groups []APIGroup `json:"groups"`
+}
+
+//KubeResource is a type of objects that kubemrr can mirror.
+//Objects of the resource are stored in the cache with kind equal to the singular name
+type KubeResource struct {
+	Group      string
+	Version    string
+	Name       string
+	Singular   string
+	Kind       string
+	ShortNames []string
+	Namespaced bool
+	Verbs      []string
+}
+
+func newKubeResource(group string, version string, r APIResource) KubeResource {
+	singular := r.SingularName
+	if singular == "" {
+		singular = strings.ToLower(r.Kind)
+	}
+
+	return KubeResource{
+		Group:      group,
+		Version:    version,
+		Name:       r.Name,
+		Singular:   singular,
+		Kind:       r.Kind,
+		ShortNames: r.ShortNames,
+		Namespaced: r.Namespaced,
+		Verbs:      r.Verbs,
+	}
+}
+
+//path returns the path to the collection of the resource relative to the server URL
+func (r KubeResource) path() string {
+	if r.Group == "" {
+		return "api/" + r.Version + "/" + r.Name
+	}
+	return "apis/" + r.Group + "/" + r.Version + "/" + r.Name
+}
+
+//key identifies the resource in the cache and among the loops of a mirror. Resources of different
+//groups may have the same singular name, e.g. services of the core group and of serving.knative.dev
+func (r KubeResource) key() resourceKey {
+	return newResourceKey(r.Group, r.Singular)
+}
+
+//servedAs tells whether objects of both resources are listed and watched in the same way
+func (r KubeResource) servedAs(x KubeResource) bool {
+	return r.Group == x.Group && r.Version == x.Version && r.Name == x.Name && r.Kind == x.Kind && r.Namespaced == x.Namespaced
+}
+
+func (r KubeResource) hasVerb(verb string) bool {
+	for _, v := range r.Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+//matches tells whether the resource can be referred by the given name.
+//As in kubectl, the name is either plural, singular, short name or kind of the resource,
+//optionally qualified by the group or by the version and the group, e.g. deployments.v1.apps
+func (r KubeResource) matches(name string) bool {
+	name = strings.ToLower(name)
+	base, qualifier := name, ""
+	if i := strings.Index(name, "."); i >= 0 {
+		base, qualifier = name[:i], name[i+1:]
+	}
+
+	if qualifier != "" && qualifier != r.Group && qualifier != r.Version+"."+r.Group {
+		return false
+	}
+
+	if base == r.Name || base == r.Singular || base == strings.ToLower(r.Kind) {
+		return true
+	}
+
+	for _, sn := range r.ShortNames {
+		if base == sn {
+			return true
+		}
+	}
+
+	return false
+}
+
+//ResourceRegistry keeps resources known to an API server in the order of priority:
+//core resources first, then resources of the groups in the order of discovery
+type ResourceRegistry struct {
+	resources []KubeResource
+}
+
+func NewResourceRegistry(rs []KubeResource) *ResourceRegistry {
+	return &ResourceRegistry{resources: rs}
+}
+
+//Lookup finds resource with the given name. If several resources match the name,
+//the one with the highest priority is returned, except that superseded resources are
+//returned only when nothing else matches
+func (reg *ResourceRegistry) Lookup(name string) (KubeResource, bool) {
+	var res KubeResource
+	found := false
+	for _, r := range reg.resources {
+		if !r.matches(name) {
+			continue
+		}
+		if !reg.isSuperseded(r) {
+			return r, true
+		}
+		if !found {
+			res, found = r, true
+		}
+	}
+	return res, found
+}
+
+//add adds the resource with the lowest priority, unless the registry already has it
+func (reg *ResourceRegistry) add(r KubeResource) {
+	for _, x := range reg.resources {
+		if x.Group == r.Group && x.Name == r.Name {
+			return
+		}
+	}
+	res := make([]KubeResource, len(reg.resources), len(reg.resources)+1)
+	copy(res, reg.resources)
+	reg.resources = append(res, r)
+}
+
+func (reg *ResourceRegistry) remove(r KubeResource) {
+	res := []KubeResource{}
+	for _, x := range reg.resources {
+		if x.Group != r.Group || x.Name != r.Name {
+			res = append(res, x)
+		}
+	}
+	reg.resources = res
+}
+
+//Resources returns resources which can be mirrored. Resources of different groups are different
+//resources even when their names are the same, except the resources of supersededGroups
+func (reg *ResourceRegistry) Resources() []KubeResource {
+	res := []KubeResource{}
+	for _, r := range reg.resources {
+		if r.hasVerb("list") && !reg.isSuperseded(r) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+//isSuperseded tells whether the same resource is served by a group that is preferred to the group of the resource
+func (reg *ResourceRegistry) isSuperseded(r KubeResource) bool {
+	for _, group := range supersededGroups[r.Group] {
+		for _, x := range reg.resources {
+			if x.Group == group && x.Name == r.Name && x.hasVerb("list") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	//supersededGroups maps groups that serve copies of resources of other groups to the groups
+	//that are preferred, e.g. deployments of extensions are served by apps as well.
+	//Only the preferred copy is mirrored
+	supersededGroups = map[string][]string{
+		"extensions":    {"apps", "networking.k8s.io", "policy"},
+		"events.k8s.io": {""},
+	}
+
+	//defaultResources are used when API server does not support discovery
+	defaultResources = []KubeResource{
+		{Version: "v1", Name: "pods", Singular: "pod", Kind: "Pod", ShortNames: []string{"po"}, Namespaced: true, Verbs: []string{"list", "watch"}},
+		{Version: "v1", Name: "services", Singular: "service", Kind: "Service", ShortNames: []string{"svc"}, Namespaced: true, Verbs: []string{"list", "watch"}},
+		{Version: "v1", Name: "configmaps", Singular: "configmap", Kind: "ConfigMap", ShortNames: []string{"cm"}, Namespaced: true, Verbs: []string{"list", "watch"}},
+		{Version: "v1", Name: "namespaces", Singular: "namespace", Kind: "Namespace", ShortNames: []string{"ns"}, Namespaced: false, Verbs: []string{"list", "watch"}},
+		{Version: "v1", Name: "nodes", Singular: "node", Kind: "Node", ShortNames: []string{"no"}, Namespaced: false, Verbs: []string{"list", "watch"}},
+		{Group: "extensions", Version: "v1beta1", Name: "deployments", Singular: "deployment", Kind: "Deployment", ShortNames: []string{"deploy"}, Namespaced: true, Verbs: []string{"list", "watch"}},
+	}
+
+	defaultRegistry = NewResourceRegistry(defaultResources)
+
+	resourceNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKubeResourceMatches(t *testing.T) {
+	r := KubeResource{Group: "apps", Version: "v1", Name: "deployments", Singular: "deployment", Kind: "Deployment", ShortNames: []string{"deploy"}}
+
+	for _, name := range []string{"deployments", "deployment", "deploy", "Deployment", "deployments.apps", "deploy.v1.apps"} {
+		assert.True(t, r.matches(name), "must match %s", name)
+	}
+
+	for _, name := range []string{"", "deploymentz", "deployments.extensions", "deployments.v2.apps", "apps"} {
+		assert.False(t, r.matches(name), "must not match %s", name)
+	}
+}
+
+func TestKubeResourcePath(t *testing.T) {
+	assert.Equal(t, "api/v1/pods", KubeResource{Version: "v1", Name: "pods"}.path())
+	assert.Equal(t, "apis/apps/v1/deployments", KubeResource{Group: "apps", Version: "v1", Name: "deployments"}.path())
+}
+
+func TestResourceRegistry(t *testing.T) {
+	apps := KubeResource{Group: "apps", Version: "v1", Name: "deployments", Singular: "deployment", Verbs: []string{"list"}}
+	extensions := KubeResource{Group: "extensions", Version: "v1beta1", Name: "deployments", Singular: "deployment", Verbs: []string{"list"}}
+	bindings := KubeResource{Version: "v1", Name: "bindings", Singular: "binding", Verbs: []string{"create"}}
+	services := KubeResource{Version: "v1", Name: "services", Singular: "service", Verbs: []string{"list"}}
+	knative := KubeResource{Group: "serving.knative.dev", Version: "v1", Name: "services", Singular: "service", Verbs: []string{"list"}}
+	registry := NewResourceRegistry([]KubeResource{services, extensions, apps, bindings, knative})
+
+	r, ok := registry.Lookup("deployments")
+	if assert.True(t, ok) {
+		assert.Equal(t, apps, r, "must prefer resource of the preferred group")
+	}
+
+	r, ok = registry.Lookup("services")
+	if assert.True(t, ok) {
+		assert.Equal(t, services, r, "must prefer resource discovered first")
+	}
+
+	r, ok = registry.Lookup("services.serving.knative.dev")
+	if assert.True(t, ok) {
+		assert.Equal(t, knative, r)
+	}
+
+	r, ok = registry.Lookup("deployments.extensions")
+	if assert.True(t, ok) {
+		assert.Equal(t, extensions, r)
+	}
+
+	_, ok = registry.Lookup("pods")
+	assert.False(t, ok)
+
+	assert.Equal(t, []KubeResource{services, apps, knative}, registry.Resources(), "must keep resources of different groups with the same name")
+
+	registry = NewResourceRegistry([]KubeResource{extensions})
+	assert.Equal(t, []KubeResource{extensions}, registry.Resources(), "must keep superseded resource when the preferred group does not serve it")
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+//MirrorEndpoint tells where the mirror is accessible and how the connections to it are secured.
+//The same flags configure both sides: the mirror started by watch, and its clients
+type MirrorEndpoint struct {
+	Network string
+	Address string
+
+	//TLS is true when the mirror is served over HTTPS
+	TLS bool
+	//CertFile and KeyFile are the certificate of the mirror, or the client certificate of a client
+	CertFile string
+	KeyFile  string
+	//CAFile verifies client certificates on the mirror, or the certificate of the mirror on a client
+	CAFile string
+	//TokenFile has the bearer token that clients must present to the mirror
+	TokenFile string
+}
+
+func AddEndpointSecurityFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("tls", false, "Use HTTPS between the mirror and its clients, implied by the other --tls flags")
+	cmd.Flags().String("tls-cert", "", "Certificate file of the mirror, or the client certificate for mutual TLS")
+	cmd.Flags().String("tls-key", "", "Key file of the --tls-cert certificate")
+	cmd.Flags().String("tls-ca", "", "CA file that verifies client certificates on the mirror, or the certificate of the mirror on clients")
+	cmd.Flags().String("token-file", "", "File with the bearer token that clients must present to the mirror")
+}
+
+//GetEndpoint returns the endpoint of the mirror: the Unix socket given by --socket,
+//or the TCP address given by --address and --port, secured as given by the TLS and token flags
+func GetEndpoint(cmd *cobra.Command) (MirrorEndpoint, error) {
+	var e MirrorEndpoint
+	socket, err := cmd.Flags().GetString("socket")
+	if err != nil {
+		return e, err
+	}
+
+	if socket != "" {
+		e.Network = "unix"
+		if e.Address, err = substituteUserHome(socket); err != nil {
+			return e, err
+		}
+	} else {
+		e.Network = "tcp"
+		if e.Address, err = GetBind(cmd); err != nil {
+			return e, err
+		}
+	}
+
+	if e.TLS, err = cmd.Flags().GetBool("tls"); err != nil {
+		return e, err
+	}
+	for flag, value := range map[string]*string{
+		"tls-cert":   &e.CertFile,
+		"tls-key":    &e.KeyFile,
+		"tls-ca":     &e.CAFile,
+		"token-file": &e.TokenFile,
+	} {
+		raw, err := cmd.Flags().GetString(flag)
+		if err != nil {
+			return e, err
+		}
+		if *value, err = substituteUserHome(raw); err != nil {
+			return e, fmt.Errorf("invalid --%s: %v", flag, err)
+		}
+	}
+
+	if (e.CertFile == "") != (e.KeyFile == "") {
+		return e, fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	if e.CertFile != "" || e.CAFile != "" {
+		e.TLS = true
+	}
+	return e, nil
+}
+
+//serverTLSConfig returns configuration of the HTTPS server of the mirror, or nil if TLS is not enabled.
+//Client certificates are required when the CA file is given
+func (e MirrorEndpoint) serverTLSConfig() (*tls.Config, error) {
+	if !e.TLS {
+		return nil, nil
+	}
+	if e.CertFile == "" {
+		return nil, fmt.Errorf("--tls-cert and --tls-key are required to serve over HTTPS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load certificate of the mirror: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if e.CAFile != "" {
+		pool, err := readCertPool(e.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+//clientTLSConfig returns configuration of HTTPS connections to the mirror, or nil if TLS is not enabled.
+//Without the CA file the certificate of the mirror is verified with the system roots
+func (e MirrorEndpoint) clientTLSConfig() (*tls.Config, error) {
+	if !e.TLS {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if e.CAFile != "" {
+		pool, err := readCertPool(e.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if e.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+//token reads the bearer token, it is empty if the token file is not given
+func (e MirrorEndpoint) token() (string, error) {
+	if e.TokenFile == "" {
+		return "", nil
+	}
+	raw, err := ioutil.ReadFile(e.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read token: %v", err)
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", e.TokenFile)
+	}
+	return token, nil
+}
+
+func readCertPool(file string) (*x509.CertPool, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+//requireToken rejects requests without the bearer token
+func requireToken(token string, h http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(expected, actual) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kubemrr"`)
+			writeJSON(w, http.StatusUnauthorized, APIError{"invalid or missing bearer token"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
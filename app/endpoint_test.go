@@ -0,0 +1,252 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//writeTestCertificates writes a CA, and server and client certificates signed by it:
+//ca.pem, server.pem, server-key.pem, client.pem and client-key.pem
+func writeTestCertificates(t *testing.T, dir string) {
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubemrr-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caKey := writeTestCertificate(t, dir, "ca", ca, ca, nil)
+
+	writeTestCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "kubemrr"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	writeTestCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "user"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+}
+
+//writeTestCertificate writes the certificate signed by the parent, and its key.
+//The certificate is self-signed when the parent key is nil
+func writeTestCertificate(t *testing.T, dir string, name string, cert *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parentKey == nil {
+		parentKey = key
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, cert, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(path.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}), 0600)
+	ioutil.WriteFile(path.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600)
+	return key
+}
+
+//serveTestMirror serves a cache with one pod at the endpoint, as watch does
+func serveTestMirror(t *testing.T, e MirrorEndpoint) net.Listener {
+	c := NewMrrCache()
+	c.updateKubeObject(KubeServer{"http://a.com"}, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1"}})
+
+	config, err := e.serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := e.token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h http.Handler = NewMirrorHandler(c)
+	if token != "" {
+		h = requireToken(token, h)
+	}
+	if config != nil {
+		go http.Serve(tls.NewListener(l, config), h)
+	} else {
+		go http.Serve(l, h)
+	}
+	return l
+}
+
+func TestMirrorEndpointTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestCertificates(t, dir)
+
+	l := serveTestMirror(t, MirrorEndpoint{
+		TLS:      true,
+		CertFile: path.Join(dir, "server.pem"),
+		KeyFile:  path.Join(dir, "server-key.pem"),
+		CAFile:   path.Join(dir, "ca.pem"),
+	})
+	defer l.Close()
+
+	tests := []struct {
+		endpoint MirrorEndpoint
+		complain string
+	}{
+		{
+			endpoint: MirrorEndpoint{
+				TLS:      true,
+				CertFile: path.Join(dir, "client.pem"),
+				KeyFile:  path.Join(dir, "client-key.pem"),
+				CAFile:   path.Join(dir, "ca.pem"),
+			},
+		},
+		{
+			endpoint: MirrorEndpoint{TLS: true, CAFile: path.Join(dir, "ca.pem")},
+			complain: "certificate",
+		},
+		{
+			endpoint: MirrorEndpoint{
+				TLS:      true,
+				CertFile: path.Join(dir, "client.pem"),
+				KeyFile:  path.Join(dir, "client-key.pem"),
+			},
+			complain: "certificate",
+		},
+		{
+			endpoint: MirrorEndpoint{},
+			complain: "is served over HTTPS, use --tls",
+		},
+	}
+
+	for i, test := range tests {
+		test.endpoint.Network = "tcp"
+		test.endpoint.Address = l.Addr().String()
+		client, err := NewMrrClient(test.endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		objects, err := client.Objects(MrrFilter{Kind: "pod"})
+		if test.complain == "" {
+			assert.NoError(t, err, "test %d", i)
+			assert.Equal(t, 1, len(objects), "test %d", i)
+		} else if assert.Error(t, err, "test %d", i) {
+			assert.Contains(t, err.Error(), test.complain, "test %d", i)
+		}
+	}
+}
+
+func TestMirrorEndpointToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	token := path.Join(dir, "token")
+	ioutil.WriteFile(token, []byte("secret\n"), 0600)
+	wrong := path.Join(dir, "wrong")
+	ioutil.WriteFile(wrong, []byte("guess"), 0600)
+
+	l := serveTestMirror(t, MirrorEndpoint{TokenFile: token})
+	defer l.Close()
+
+	for _, test := range []struct {
+		tokenFile string
+		complain  string
+	}{
+		{token, ""},
+		{wrong, "invalid or missing bearer token"},
+		{"", "invalid or missing bearer token"},
+	} {
+		client, err := NewMrrClient(MirrorEndpoint{Network: "tcp", Address: l.Addr().String(), TokenFile: test.tokenFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Objects(MrrFilter{Kind: "pod"})
+		if test.complain == "" {
+			assert.NoError(t, err, test.tokenFile)
+		} else if assert.Error(t, err, test.tokenFile) {
+			assert.Contains(t, err.Error(), test.complain)
+		}
+	}
+
+	_, err = NewMrrClient(MirrorEndpoint{Network: "tcp", TokenFile: path.Join(dir, "missing")})
+	assert.Error(t, err, "missing token file must be reported")
+}
+
+func TestGetEndpoint(t *testing.T) {
+	tests := []struct {
+		flags    map[string]string
+		expected MirrorEndpoint
+		isError  bool
+	}{
+		{
+			flags:    map[string]string{},
+			expected: MirrorEndpoint{Network: "tcp", Address: "127.0.0.1:33033"},
+		},
+		{
+			flags:    map[string]string{"socket": "/tmp/kubemrr.sock", "token-file": "/tmp/token"},
+			expected: MirrorEndpoint{Network: "unix", Address: "/tmp/kubemrr.sock", TokenFile: "/tmp/token"},
+		},
+		{
+			flags:    map[string]string{"tls-ca": "/tmp/ca.pem"},
+			expected: MirrorEndpoint{Network: "tcp", Address: "127.0.0.1:33033", TLS: true, CAFile: "/tmp/ca.pem"},
+		},
+		{
+			flags:   map[string]string{"tls-cert": "/tmp/cert.pem"},
+			isError: true,
+		},
+	}
+
+	for _, test := range tests {
+		cmd := NewGetCommand(NewTestFactory())
+		for name, value := range test.flags {
+			cmd.Flags().Set(name, value)
+		}
+
+		actual, err := GetEndpoint(cmd)
+		if test.isError {
+			assert.Error(t, err, "%v", test.flags)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, actual, "%v", test.flags)
+	}
+}
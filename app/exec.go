@@ -0,0 +1,151 @@
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const defaultExecAPIVersion = "client.authentication.k8s.io/v1beta1"
+
+//ExecCredential is the output of a credential plugin, see
+//https://kubernetes.io/docs/reference/access-authn-authz/authentication/#client-go-credential-plugins
+type ExecCredential struct {
+	APIVersion string                `json:"apiVersion"`
+	Kind       string                `json:"kind"`
+	Spec       ExecCredentialSpec    `json:"spec"`
+	Status     *ExecCredentialStatus `json:"status,omitempty"`
+}
+
+type ExecCredentialSpec struct {
+	Interactive bool `json:"interactive"`
+}
+
+type ExecCredentialStatus struct {
+	ExpirationTimestamp   *time.Time `json:"expirationTimestamp,omitempty"`
+	Token                 string     `json:"token,omitempty"`
+	ClientCertificateData string     `json:"clientCertificateData,omitempty"`
+	ClientKeyData         string     `json:"clientKeyData,omitempty"`
+}
+
+//execCredentials runs the credential plugin and caches its output until the credentials expire
+type execCredentials struct {
+	config ExecConfig
+
+	mu     *sync.Mutex
+	status *ExecCredentialStatus
+	cert   *tls.Certificate
+	now    func() time.Time
+}
+
+func newExecCredentials(config *ExecConfig) *execCredentials {
+	if config == nil {
+		return nil
+	}
+
+	return &execCredentials{
+		config: *config,
+		mu:     &sync.Mutex{},
+		now:    time.Now,
+	}
+}
+
+func (e *execCredentials) token() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.refresh(); err != nil {
+		return "", err
+	}
+	return e.status.Token, nil
+}
+
+//clientCertificate is used by TLS handshake, so that the certificate returned by the plugin
+//is refreshed together with the token
+func (e *execCredentials) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.refresh(); err != nil {
+		return nil, err
+	}
+	if e.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return e.cert, nil
+}
+
+func (e *execCredentials) invalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status = nil
+	e.cert = nil
+}
+
+//refresh runs the plugin if there are no credentials yet or they have expired. Must be called under lock
+func (e *execCredentials) refresh() error {
+	if e.status != nil && (e.status.ExpirationTimestamp == nil || e.now().Before(*e.status.ExpirationTimestamp)) {
+		return nil
+	}
+
+	status, err := e.run()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if status.ClientCertificateData != "" || status.ClientKeyData != "" {
+		c, err := tls.X509KeyPair([]byte(status.ClientCertificateData), []byte(status.ClientKeyData))
+		if err != nil {
+			return fmt.Errorf("credential plugin %s returned invalid client certificate: %s", e.config.Command, err)
+		}
+		cert = &c
+	}
+
+	e.status = status
+	e.cert = cert
+	return nil
+}
+
+func (e *execCredentials) run() (*ExecCredentialStatus, error) {
+	apiVersion := e.config.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultExecAPIVersion
+	}
+
+	info, err := json.Marshal(ExecCredential{APIVersion: apiVersion, Kind: "ExecCredential"})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(e.config.Command, e.config.Args...)
+	cmd.Env = append(os.Environ(), "KUBERNETES_EXEC_INFO="+string(info))
+	for _, env := range e.config.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential plugin %s failed: %s %s", e.config.Command, err, stderr.String())
+	}
+
+	var cred ExecCredential
+	if err := json.Unmarshal(stdout.Bytes(), &cred); err != nil {
+		return nil, fmt.Errorf("could not parse output of credential plugin %s: %s", e.config.Command, err)
+	}
+	if cred.APIVersion != apiVersion {
+		return nil, fmt.Errorf("credential plugin %s returned apiVersion %s, expected %s", e.config.Command, cred.APIVersion, apiVersion)
+	}
+	if cred.Status == nil || (cred.Status.Token == "" && cred.Status.ClientCertificateData == "") {
+		return nil, fmt.Errorf("credential plugin %s returned neither token nor client certificate", e.config.Command)
+	}
+
+	return cred.Status, nil
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func execPlugin(output string) *ExecConfig {
+	return &ExecConfig{
+		Command: "sh",
+		Args:    []string{"-c", "echo \"$OUTPUT\""},
+		Env:     []ExecEnvVar{{"OUTPUT", output}},
+	}
+}
+
+func TestExecCredentialsToken(t *testing.T) {
+	e := newExecCredentials(execPlugin(`{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","status":{"token":"abc"}}`))
+
+	token, err := e.token()
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", token)
+}
+
+func TestExecCredentialsExpiration(t *testing.T) {
+	e := newExecCredentials(&ExecConfig{
+		Command: "sh",
+		Args:    []string{"-c", `echo "{\"apiVersion\":\"client.authentication.k8s.io/v1beta1\",\"status\":{\"token\":\"$(date +%N)\",\"expirationTimestamp\":\"2020-01-01T10:00:00Z\"}}"`},
+	})
+	now := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	first, err := e.token()
+	assert.Nil(t, err)
+	second, _ := e.token()
+	assert.Equal(t, first, second, "token must be cached until it expires")
+
+	now = time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC)
+	third, _ := e.token()
+	assert.NotEqual(t, first, third, "expired token must be refreshed")
+}
+
+func TestExecCredentialsInfo(t *testing.T) {
+	e := newExecCredentials(&ExecConfig{
+		Command: "sh",
+		Args:    []string{"-c", `echo "{\"apiVersion\":\"client.authentication.k8s.io/v1beta1\",\"status\":{\"token\":\"$GREETING\"}}"; echo "$KUBERNETES_EXEC_INFO" >&2; exit $CODE`},
+		Env:     []ExecEnvVar{{"GREETING", "hello"}, {"CODE", "1"}},
+	})
+
+	_, err := e.token()
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), `"kind":"ExecCredential"`, "exec info must be passed to plugin")
+	}
+
+	e.config.Env[1].Value = "0"
+	token, err := e.token()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", token)
+}
+
+func TestExecCredentialsInvalidOutput(t *testing.T) {
+	tests := []struct {
+		output   string
+		complain string
+	}{
+		{"not json", "could not parse"},
+		{`{"apiVersion":"client.authentication.k8s.io/v1alpha1","status":{"token":"abc"}}`, "expected client.authentication.k8s.io/v1beta1"},
+		{`{"apiVersion":"client.authentication.k8s.io/v1beta1","status":{}}`, "neither token nor client certificate"},
+		{`{"apiVersion":"client.authentication.k8s.io/v1beta1","status":{"clientCertificateData":"x","clientKeyData":"y"}}`, "invalid client certificate"},
+	}
+
+	for _, test := range tests {
+		_, err := newExecCredentials(execPlugin(test.output)).token()
+		if err == nil {
+			t.Errorf("Expected an error for output %s", test.output)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.complain) {
+			t.Errorf("Error [%s] does not contain [%s]", err, test.complain)
+		}
+	}
+}
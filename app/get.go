@@ -8,6 +8,7 @@ import (
 	"io"
 	"regexp"
 	"strings"
+	"time"
 )
 
 func NewGetCommand(f Factory) *cobra.Command {
@@ -18,20 +19,45 @@ func NewGetCommand(f Factory) *cobra.Command {
 DESCRIPTION:
   Ask "kubemrr watch" process for the names of alive resources
 
-  Supported resources are:
-    - po, pod, pod
-    - svc, service, services
-    - deployment, deployments
-    - ns, namespace, namespaces
-    - configmap, configmaps
-    - no, node, nodes
+  Resource is referred the same way as in kubectl: by its plural, singular or short name,
+  optionally followed by the group, e.g. "po", "secrets", "deployments.apps".
+  Any resource discovered by "kubemrr watch" is supported.
 
   To filter alive resources it uses current context from the ~/.kube/conf file.
-  Additionally, it accepts --namespace, --context, --server and --cluster parameters
-  in "kubectl-flags".
+  Additionally, it accepts --namespace, --context, --server, --cluster, -l/--selector
+  and --field-selector parameters in "kubectl-flags".
+
+  Only names matching --word are returned, so that completion scripts receive only
+  relevant candidates. Names are matched by prefix, substring or fuzzy, where all
+  characters of the word appear in the name in the same order. --limit caps the number
+  of returned names.
+
+  "containers" returns names of the containers and init containers of the pod given with --pod.
+  The pod is looked up in the namespace from "kubectl-flags", and --word, --match and --limit
+  apply to the names of the containers.
+
+  --selector returns only objects whose labels match the selector, and --field-selector only objects
+  whose fields match the selector, both in the syntax of kubectl. Field selectors support metadata.name,
+  metadata.namespace and, for pods, spec.nodeName and status.phase. Selectors in "kubectl-flags"
+  are combined with them.
+  --running returns only pods that are in phase Running and are not being deleted, which
+  completion of kubectl exec, attach and port-forward asks for. Completion of kubectl logs
+  still offers terminated pods.
+  "labels" completes the selector typed in --word from labels of objects of the resource given
+  with --kind: keys of labels followed by "=", or values of the label whose key is typed.
+
+  With --autostart, a mirror that is not running is started as "kubemrr daemon start --all-contexts",
+  and the names are returned once it responds.
 
 EXAMPLE
   kubemrr -a 0.0.0.0 -p 33033 --kubect-flags="--namespace prod" get pod
+  kubemrr -a 0.0.0.0 -p 33033 --word=web --match=substring --limit=100 get pod
+  kubemrr --autostart get pod
+  kubemrr --pod=web-1 --word=ng get containers
+  kubemrr -l app=web,tier!=db get pod
+  kubemrr --field-selector status.phase=Running,spec.nodeName=node-3 get pod
+  kubemrr --running get pod
+  kubemrr --kind=pod --word=app=w get labels
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := RunCommon(cmd); err != nil {
@@ -42,7 +68,19 @@ EXAMPLE
 	}
 
 	AddCommonFlags(cmd)
+	AddEndpointSecurityFlags(cmd)
 	cmd.Flags().String("kubectl-flags", "", "An arbitrary string that contains flags accepted by kubectl")
+	cmd.Flags().String("word", "", "Partially typed name, only matching names are returned")
+	cmd.Flags().String("match", MatchPrefix, "How names are matched with --word: prefix, substring or fuzzy")
+	cmd.Flags().Int("limit", 0, "Maximum number of returned names, 0 for no limit")
+	cmd.Flags().String("pod", "", "Name of the pod whose containers are returned by \"get containers\"")
+	cmd.Flags().String("kind", "", "Resource whose labels are completed by \"get labels\"")
+	cmd.Flags().StringP("selector", "l", "", "Selector of labels of the returned objects, e.g. app=web,tier!=db")
+	cmd.Flags().String("field-selector", "", "Selector of fields of the returned objects, e.g. status.phase=Running")
+	cmd.Flags().Bool("running", false, "Return only running pods that are not being deleted")
+	cmd.Flags().Bool("autostart", false, "Start the daemon with all contexts of kubeconfig if the mirror is not running")
+	cmd.Flags().Duration("wait", 10*time.Second, "How long to wait until the daemon started by --autostart responds")
+	AddDaemonFlags(cmd)
 	return cmd
 }
 
@@ -55,14 +93,37 @@ func RunGet(f Factory, cmd *cobra.Command, args []string) error {
 		return errors.New("only one argument is expected")
 	}
 
-	regex := "(po|pod|pods|svc|service|services|deployment|deployments|ns|namespace|namespaces|configmap|configmaps|no|node|nodes)"
-	argMatcher, err := regexp.Compile(regex)
+	kind, err := resourceKind(args[0])
 	if err != nil {
-		return fmt.Errorf("unexpected error: %s", err)
+		return err
 	}
 
-	if !argMatcher.MatchString(args[0]) {
-		return fmt.Errorf("unsupported resource type: %s", args[0])
+	pod, err := cmd.Flags().GetString("pod")
+	if err != nil {
+		return fmt.Errorf("unexpected error: %s", err)
+	}
+	labelsOf, err := cmd.Flags().GetString("kind")
+	if err != nil {
+		return fmt.Errorf("unexpected error: %s", err)
+	}
+	switch kind {
+	case "container", "containers":
+		if pod == "" {
+			return errors.New("--pod is required to get containers")
+		}
+		kind = "pod"
+		labelsOf = ""
+	case "label", "labels":
+		if labelsOf == "" {
+			return errors.New("--kind is required to get labels")
+		}
+		if kind, err = resourceKind(labelsOf); err != nil {
+			return err
+		}
+		pod = ""
+	default:
+		pod = ""
+		labelsOf = ""
 	}
 
 	conf, err := f.HomeKubeconfig()
@@ -76,30 +137,83 @@ func RunGet(f Factory, cmd *cobra.Command, args []string) error {
 	}
 	kubectlFlags := parseKubectlFlags(rawKubectlFlags)
 
-	bind, err := GetBind(cmd)
+	if labelsOf != "" {
+		//the label selector in kubectl-flags is the one being completed
+		kubectlFlags.selector = ""
+	}
+
+	filter := makeFilterFor(kind, &conf, kubectlFlags)
+	if filter.Word, err = cmd.Flags().GetString("word"); err != nil {
+		return fmt.Errorf("unexpected error: %s", err)
+	}
+	if filter.Match, err = cmd.Flags().GetString("match"); err != nil {
+		return fmt.Errorf("unexpected error: %s", err)
+	}
+	if !isMatchMode(filter.Match) {
+		return fmt.Errorf("unsupported match mode %s, expected one of %s", filter.Match, strings.Join(matchModes, ", "))
+	}
+	if filter.Limit, err = cmd.Flags().GetInt("limit"); err != nil {
+		return fmt.Errorf("unexpected error: %s", err)
+	}
+	if filter.Limit < 0 {
+		return errors.New("--limit must not be negative")
+	}
+	selector, err := cmd.Flags().GetString("selector")
+	if err != nil {
+		return fmt.Errorf("unexpected error: %s", err)
+	}
+	if _, err := parseLabelSelector(selector); err != nil {
+		return err
+	}
+	filter.LabelSelector = joinSelectors(filter.LabelSelector, selector)
+	fieldSelector, err := cmd.Flags().GetString("field-selector")
 	if err != nil {
 		return fmt.Errorf("unexpected error: %s", err)
 	}
+	if _, err := parseFieldSelector(fieldSelector); err != nil {
+		return err
+	}
+	filter.FieldSelector = joinSelectors(filter.FieldSelector, fieldSelector)
+	if filter.Running, err = cmd.Flags().GetBool("running"); err != nil {
+		return fmt.Errorf("unexpected error: %s", err)
+	}
+	if filter.Running && kind != "pod" {
+		return errors.New("--running is supported only for pods")
+	}
 
-	client, err := f.MrrClient(bind)
+	endpoint, err := GetEndpoint(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := f.MrrClient(endpoint)
 	if err != nil {
 		return fmt.Errorf("could not create client to kubemrr: %s", err)
 	}
 
-	if strings.HasPrefix(args[0], "p") {
-		err = outputNames(client, makeFilterFor("pod", &conf, kubectlFlags), f.StdOut())
-	} else if strings.HasPrefix(args[0], "s") {
-		err = outputNames(client, makeFilterFor("service", &conf, kubectlFlags), f.StdOut())
-	} else if strings.HasPrefix(args[0], "c") {
-		err = outputNames(client, makeFilterFor("configmap", &conf, kubectlFlags), f.StdOut())
-	} else if strings.HasPrefix(args[0], "na") || args[0] == "ns" {
-		err = outputNames(client, makeFilterFor("namespace", &conf, kubectlFlags), f.StdOut())
-	} else if strings.HasPrefix(args[0], "no") {
-		err = outputNames(client, makeFilterFor("node", &conf, kubectlFlags), f.StdOut())
-	} else {
-		err = outputNames(client, makeFilterFor("deployment", &conf, kubectlFlags), f.StdOut())
+	output := func() error {
+		switch {
+		case pod != "":
+			return outputContainers(client, filter, pod, f.StdOut())
+		case labelsOf != "":
+			return outputLabels(client, filter, f.StdOut())
+		}
+		return outputNames(client, filter, f.StdOut())
 	}
 
+	err = output()
+	if isUnreachable(err) {
+		autostart, flagErr := cmd.Flags().GetBool("autostart")
+		if flagErr != nil {
+			return fmt.Errorf("unexpected error: %s", flagErr)
+		}
+		if autostart {
+			if err := autostartDaemon(f, cmd); err != nil {
+				return err
+			}
+			err = output()
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -107,18 +221,62 @@ func RunGet(f Factory, cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+//resourceKind returns the singular name of the resource given as in kubectl. Names that are not
+//known to kubemrr are returned in lowercase, the mirror may have discovered them
+func resourceKind(name string) (string, error) {
+	kind := strings.ToLower(name)
+	if !resourceNameRegex.MatchString(kind) {
+		return "", fmt.Errorf("unsupported resource type: %s", name)
+	}
+	if r, ok := defaultRegistry.Lookup(kind); ok {
+		kind = r.Singular
+	}
+	return kind, nil
+}
+
+//autostartDaemon starts the daemon that mirrors all contexts of kubeconfig, unless a daemon
+//is already running and only does not respond yet
+func autostartDaemon(f Factory, cmd *cobra.Command) error {
+	files, err := GetDaemonFiles(cmd)
+	if err != nil {
+		return err
+	}
+	d := f.Daemon(files)
+	pid, running, err := d.Pid()
+	if err != nil {
+		return err
+	}
+
+	if !running {
+		args, err := daemonWatchArgs(cmd, nil)
+		if err != nil {
+			return err
+		}
+		//another command may have started the daemon meanwhile
+		if pid, err = d.Start(append(args, "--all-contexts")); err != nil && err != errDaemonRunning {
+			return err
+		}
+	}
+	log.WithField("pid", pid).Debug("waiting for kubemrr daemon")
+	return waitForDaemon(f, cmd, d)
+}
+
 type KubectlFlags struct {
-	namespace string
-	context   string
-	cluster   string
-	server    string
+	namespace     string
+	context       string
+	cluster       string
+	server        string
+	selector      string
+	fieldSelector string
 }
 
 var (
-	namespaceFlagRegex = regexp.MustCompile(`--namespace[ =]([\S]+)`)
-	serverFlagRegex    = regexp.MustCompile(`--server[ =]([\S]+)`)
-	contextFlagRegex   = regexp.MustCompile(`--context[ =]([\S]+)`)
-	clusterFlagRegex   = regexp.MustCompile(`--cluster[ =]([\S]+)`)
+	namespaceFlagRegex     = regexp.MustCompile(`--namespace[ =]([\S]+)`)
+	serverFlagRegex        = regexp.MustCompile(`--server[ =]([\S]+)`)
+	contextFlagRegex       = regexp.MustCompile(`--context[ =]([\S]+)`)
+	clusterFlagRegex       = regexp.MustCompile(`--cluster[ =]([\S]+)`)
+	selectorFlagRegex      = regexp.MustCompile(`(?:^|\s)(?:-l|--selector)[ =]([\S]+)`)
+	fieldSelectorFlagRegex = regexp.MustCompile(`--field-selector[ =]([\S]+)`)
 )
 
 func parseKubectlFlags(in string) *KubectlFlags {
@@ -140,10 +298,54 @@ func parseKubectlFlags(in string) *KubectlFlags {
 		res.cluster = matches[1]
 	}
 
+	//selectors that cannot be parsed, e.g. quoted ones with spaces, are ignored rather than failing the completion
+	for _, matches := range selectorFlagRegex.FindAllStringSubmatch(in, -1) {
+		if selector, ok := unquote(matches[1]); ok && isLabelSelector(selector) {
+			res.selector = selector
+		}
+	}
+
+	for _, matches := range fieldSelectorFlagRegex.FindAllStringSubmatch(in, -1) {
+		if selector, ok := unquote(matches[1]); ok && isFieldSelector(selector) {
+			res.fieldSelector = selector
+		}
+	}
+
 	log.WithField("in", in).WithField("out", res).Debug("parsed kubectl flags")
 	return &res
 }
 
+//unquote removes the quotes around the value of a flag. It returns false if the quotes are not closed
+func unquote(value string) (string, bool) {
+	for _, q := range []string{`'`, `"`} {
+		if strings.HasPrefix(value, q) {
+			if len(value) < 2 || !strings.HasSuffix(value, q) {
+				return "", false
+			}
+			return value[1 : len(value)-1], true
+		}
+	}
+	return value, true
+}
+
+func isLabelSelector(s string) bool {
+	_, err := parseLabelSelector(s)
+	return err == nil
+}
+
+func isFieldSelector(s string) bool {
+	_, err := parseFieldSelector(s)
+	return err == nil
+}
+
+//joinSelectors returns the selector that requires all requirements of both selectors
+func joinSelectors(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "," + b
+}
+
 func makeFilterFor(kind string, conf *Config, flags *KubectlFlags) MrrFilter {
 	f := MrrFilter{}
 	if conf != nil {
@@ -162,10 +364,12 @@ func makeFilterFor(kind string, conf *Config, flags *KubectlFlags) MrrFilter {
 		if flags.server != "" {
 			f.Server = flags.server
 		}
+		f.LabelSelector = flags.selector
+		f.FieldSelector = flags.fieldSelector
 	}
 	f.Kind = kind
 
-	if kind == "node" {
+	if r, ok := defaultRegistry.Lookup(kind); ok && !r.Namespaced {
 		f.Namespace = ""
 	}
 
@@ -191,3 +395,89 @@ func outputNames(c MrrClient, f MrrFilter, out io.Writer) error {
 
 	return nil
 }
+
+//outputContainers writes names of containers of the pod that match the word of the filter.
+//The word, match mode and limit of the filter apply to the containers, not to the pod
+func outputContainers(c MrrClient, f MrrFilter, pod string, out io.Writer) error {
+	podFilter := f
+	podFilter.Word = pod
+	podFilter.Match = MatchPrefix
+	podFilter.Limit = 0
+	objects, err := c.Objects(podFilter)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, o := range objects {
+		if o.Name != pod {
+			continue
+		}
+		for _, name := range o.containerNames() {
+			if f.Limit > 0 && len(names) == f.Limit {
+				break
+			}
+			if matches(f.Match, f.Word, name) {
+				names = append(names, name)
+			}
+		}
+		break
+	}
+	log.
+		WithField("pod", pod).
+		WithField("containers", names).
+		Debugf("got containers")
+
+	out.Write([]byte(strings.Join(names, " ")))
+	return nil
+}
+
+//outputLabels writes completions of the label selector typed in the word of the filter.
+//Requirements before the last comma are complete, they select the objects whose labels are completed.
+//The last requirement is completed with keys of labels followed by "=", or with values of its key
+func outputLabels(c MrrClient, f MrrFilter, out io.Writer) error {
+	prefix, term := "", f.Word
+	if i := strings.LastIndex(f.Word, ","); i >= 0 {
+		prefix, term = f.Word[:i+1], f.Word[i+1:]
+		if f.LabelSelector != "" {
+			f.LabelSelector += ","
+		}
+		f.LabelSelector += f.Word[:i]
+	}
+
+	key, operator, word := "", "", term
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(term, op); i > 0 {
+			key, operator, word = term[:i], op, term[i+len(op):]
+			break
+		}
+	}
+	if key == "" && strings.HasPrefix(term, "!") {
+		operator, word = "!", term[1:]
+	}
+
+	f.Word = word
+	labels, err := c.Labels(f, key)
+	if err != nil {
+		return err
+	}
+
+	completions := make([]string, len(labels))
+	for i, l := range labels {
+		switch {
+		case key != "":
+			completions[i] = prefix + key + operator + l
+		case operator == "!":
+			completions[i] = prefix + "!" + l
+		default:
+			completions[i] = prefix + l + "="
+		}
+	}
+	log.
+		WithField("filter", f).
+		WithField("labels", completions).
+		Debugf("got labels")
+
+	out.Write([]byte(strings.Join(completions, " ")))
+	return nil
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"bytes"
 	"fmt"
+	"github.com/stretchr/testify/assert"
 	"reflect"
 	"strings"
 	"testing"
@@ -22,7 +23,7 @@ func TestRunGetInvalidArgs(t *testing.T) {
 			output: "one argument",
 		},
 		{
-			args:   []string{"k8s-resource"},
+			args:   []string{"k8s/resource"},
 			output: "unsupported resource type",
 		},
 	}
@@ -82,9 +83,18 @@ func TestRunGet(t *testing.T) {
 			aliases:        []string{"no", "node", "nodes"},
 			expectedFilter: MrrFilter{Kind: "node"},
 		},
+		{
+			aliases:        []string{"secrets"},
+			expectedFilter: MrrFilter{Kind: "secrets"},
+		},
+		{
+			aliases:        []string{"Deployments.Apps"},
+			expectedFilter: MrrFilter{Kind: "deployments.apps"},
+		},
 	}
 
 	for _, test := range tests {
+		test.expectedFilter.Match = MatchPrefix
 		for _, alias := range test.aliases {
 			buf.Reset()
 			err := cmd.RunE(cmd, []string{alias})
@@ -208,6 +218,189 @@ func TestRunGetWithKubectlFlags(t *testing.T) {
 	}
 }
 
+func TestRunGetWithWord(t *testing.T) {
+	tc := &TestMirrorClient{}
+	f := &TestFactory{mrrClient: tc, stdOut: bytes.NewBuffer([]byte{})}
+	cmd := NewGetCommand(f)
+	cmd.Flags().Set("word", "web")
+	cmd.Flags().Set("match", "fuzzy")
+	cmd.Flags().Set("limit", "10")
+
+	err := cmd.RunE(cmd, []string{"pod"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, MrrFilter{Kind: "pod", Word: "web", Match: MatchFuzzy, Limit: 10}, tc.lastFilter)
+	}
+
+	cmd.Flags().Set("match", "regex")
+	err = cmd.RunE(cmd, []string{"pod"})
+	assert.Error(t, err, "must reject unknown match mode")
+
+	cmd.Flags().Set("match", "prefix")
+	cmd.Flags().Set("limit", "-1")
+	err = cmd.RunE(cmd, []string{"pod"})
+	assert.Error(t, err, "must reject negative limit")
+}
+
+func TestRunGetContainers(t *testing.T) {
+	spec := &PodSpec{
+		Containers:     []Container{{Name: "nginx"}, {Name: "sidecar"}, {Name: "nginx-exporter"}},
+		InitContainers: []Container{{Name: "init"}},
+	}
+	tc := &TestMirrorClient{
+		objects: []KubeObject{
+			{ObjectMeta: ObjectMeta{Name: "web-1"}, Spec: spec},
+			{ObjectMeta: ObjectMeta{Name: "web-10"}, Spec: &PodSpec{Containers: []Container{{Name: "other"}}}},
+		},
+	}
+	buf := bytes.NewBuffer([]byte{})
+	f := &TestFactory{mrrClient: tc, stdOut: buf}
+	cmd := NewGetCommand(f)
+
+	err := cmd.RunE(cmd, []string{"containers"})
+	assert.Error(t, err, "must require --pod")
+
+	cmd.Flags().Set("pod", "web-1")
+	cmd.Flags().Set("kubectl-flags", "--namespace=prod")
+	err = cmd.RunE(cmd, []string{"containers"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, MrrFilter{Kind: "pod", Namespace: "prod", Word: "web-1", Match: MatchPrefix}, tc.lastFilter)
+		assert.Equal(t, "nginx sidecar nginx-exporter init", buf.String())
+	}
+
+	buf.Reset()
+	cmd.Flags().Set("word", "ngi")
+	cmd.Flags().Set("limit", "1")
+	err = cmd.RunE(cmd, []string{"containers"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "nginx", buf.String())
+	}
+}
+
+func TestRunGetWithSelector(t *testing.T) {
+	tc := &TestMirrorClient{}
+	f := &TestFactory{mrrClient: tc, stdOut: bytes.NewBuffer([]byte{})}
+	cmd := NewGetCommand(f)
+	cmd.Flags().Set("selector", "app=web,tier!=db")
+
+	err := cmd.RunE(cmd, []string{"pod"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, MrrFilter{Kind: "pod", Match: MatchPrefix, LabelSelector: "app=web,tier!=db"}, tc.lastFilter)
+	}
+
+	cmd.Flags().Set("selector", "app=web app")
+	err = cmd.RunE(cmd, []string{"pod"})
+	assert.Error(t, err, "must reject invalid selector")
+
+	cmd.Flags().Set("selector", "")
+	cmd.Flags().Set("field-selector", "status.phase=Running,spec.nodeName=node-3")
+	err = cmd.RunE(cmd, []string{"pod"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, MrrFilter{Kind: "pod", Match: MatchPrefix, FieldSelector: "status.phase=Running,spec.nodeName=node-3"}, tc.lastFilter)
+	}
+
+	cmd.Flags().Set("field-selector", "status.hostIP=1.2.3.4")
+	err = cmd.RunE(cmd, []string{"pod"})
+	assert.Error(t, err, "must reject unsupported field")
+}
+
+func TestRunGetRunning(t *testing.T) {
+	tc := &TestMirrorClient{}
+	f := &TestFactory{mrrClient: tc, stdOut: bytes.NewBuffer([]byte{})}
+	cmd := NewGetCommand(f)
+	cmd.Flags().Set("running", "true")
+
+	err := cmd.RunE(cmd, []string{"po"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, MrrFilter{Kind: "pod", Match: MatchPrefix, Running: true}, tc.lastFilter)
+	}
+
+	err = cmd.RunE(cmd, []string{"svc"})
+	assert.Error(t, err, "must reject --running for other resources")
+}
+
+func TestRunGetWithKubectlSelectors(t *testing.T) {
+	tc := &TestMirrorClient{}
+	f := &TestFactory{mrrClient: tc, stdOut: bytes.NewBuffer([]byte{})}
+	cmd := NewGetCommand(f)
+
+	tests := []struct {
+		kubectlCmd            string
+		selector              string
+		expectedSelector      string
+		expectedFieldSelector string
+	}{
+		{kubectlCmd: "logs -l app=api", expectedSelector: "app=api"},
+		{kubectlCmd: "logs -l=app=api", expectedSelector: "app=api"},
+		{kubectlCmd: "get po --selector app=api", expectedSelector: "app=api"},
+		{kubectlCmd: "get po --selector='app=api,tier!=cache'", expectedSelector: "app=api,tier!=cache"},
+		{kubectlCmd: "get po -l app=web -l app=api", expectedSelector: "app=api"},
+		{kubectlCmd: "get po -l app=api", selector: "tier!=cache", expectedSelector: "app=api,tier!=cache"},
+		{kubectlCmd: "get po --namespace=kube-lb"},
+		{kubectlCmd: "get po -l 'env in (a,b)'"},
+		{kubectlCmd: "get po --field-selector status.phase=Running", expectedFieldSelector: "status.phase=Running"},
+		{kubectlCmd: "get po --field-selector=spec.nodeName=node-3 -l app", expectedSelector: "app", expectedFieldSelector: "spec.nodeName=node-3"},
+		{kubectlCmd: "get po --field-selector=status.podIP=1.2.3.4"},
+	}
+
+	for _, test := range tests {
+		cmd.Flags().Set("kubectl-flags", test.kubectlCmd)
+		cmd.Flags().Set("selector", test.selector)
+		err := cmd.RunE(cmd, []string{"po"})
+		if assert.NoError(t, err, test.kubectlCmd) {
+			assert.Equal(t, test.expectedSelector, tc.lastFilter.LabelSelector, test.kubectlCmd)
+			assert.Equal(t, test.expectedFieldSelector, tc.lastFilter.FieldSelector, test.kubectlCmd)
+		}
+	}
+
+	cmd.Flags().Set("selector", "")
+	cmd.Flags().Set("kubectl-flags", "get po -l app=")
+	cmd.Flags().Set("kind", "po")
+	cmd.Flags().Set("word", "app=")
+	err := cmd.RunE(cmd, []string{"labels"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "", tc.lastFilter.LabelSelector, "must not apply the selector being completed")
+	}
+}
+
+func TestRunGetLabels(t *testing.T) {
+	tc := &TestMirrorClient{}
+	buf := bytes.NewBuffer([]byte{})
+	f := &TestFactory{mrrClient: tc, stdOut: buf}
+	cmd := NewGetCommand(f)
+
+	err := cmd.RunE(cmd, []string{"labels"})
+	assert.Error(t, err, "must require --kind")
+
+	cmd.Flags().Set("kind", "po")
+	tests := []struct {
+		word     string
+		labels   []string
+		filter   MrrFilter
+		key      string
+		expected string
+	}{
+		{"", []string{"app", "tier"}, MrrFilter{Kind: "pod", Match: MatchPrefix}, "", "app= tier="},
+		{"ap", []string{"app"}, MrrFilter{Kind: "pod", Match: MatchPrefix, Word: "ap"}, "", "app="},
+		{"app=w", []string{"web", "worker"}, MrrFilter{Kind: "pod", Match: MatchPrefix, Word: "w"}, "app", "app=web app=worker"},
+		{"app!=", []string{"web"}, MrrFilter{Kind: "pod", Match: MatchPrefix}, "app", "app!=web"},
+		{"!ti", []string{"tier"}, MrrFilter{Kind: "pod", Match: MatchPrefix, Word: "ti"}, "", "!tier"},
+		{"app=web,tier=f", []string{"frontend"}, MrrFilter{Kind: "pod", Match: MatchPrefix, Word: "f", LabelSelector: "app=web"}, "tier", "app=web,tier=frontend"},
+		{"app=web,", []string{"tier"}, MrrFilter{Kind: "pod", Match: MatchPrefix, LabelSelector: "app=web"}, "", "app=web,tier="},
+	}
+
+	for _, test := range tests {
+		buf.Reset()
+		tc.labels = test.labels
+		cmd.Flags().Set("word", test.word)
+		err := cmd.RunE(cmd, []string{"labels"})
+		if assert.NoError(t, err, test.word) {
+			assert.Equal(t, test.filter, tc.lastFilter, test.word)
+			assert.Equal(t, test.key, tc.lastKey, test.word)
+			assert.Equal(t, test.expected, buf.String(), test.word)
+		}
+	}
+}
+
 func TestRunGetClientError(t *testing.T) {
 	tc := &TestMirrorClient{
 		err: fmt.Errorf("TestFailure"),
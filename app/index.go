@@ -0,0 +1,275 @@
+package app
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+//ObjectKey identifies an object in the cache. Kind is the lowercase singular name of the resource.
+//Kinds and namespaces are case-insensitive, keys made by newObjectKey are normalised to lowercase
+type ObjectKey struct {
+	Server    KubeServer
+	Group     string
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func newObjectKey(server KubeServer, o KubeObject) ObjectKey {
+	return ObjectKey{
+		Server:    server,
+		Group:     strings.ToLower(o.Group),
+		Kind:      strings.ToLower(o.Kind),
+		Namespace: strings.ToLower(o.Namespace),
+		Name:      o.Name,
+	}
+}
+
+//resourceKey identifies objects of one resource of a server
+type resourceKey struct {
+	Group string
+	Kind  string
+}
+
+func newResourceKey(group string, kind string) resourceKey {
+	return resourceKey{strings.ToLower(group), strings.ToLower(kind)}
+}
+
+func (k ObjectKey) resource() resourceKey {
+	return newResourceKey(k.Group, k.Kind)
+}
+
+//objectIndex keeps objects of one kind in one namespace. Objects are added, updated and deleted
+//in constant time. The names are sorted on the first lookup after a change, so that objects
+//can be found by prefix with binary search. Lookups may run at the same time, changes may not
+type objectIndex struct {
+	objects map[string]KubeObject
+	//names are guarded by mu, because concurrent lookups sort them
+	names []string
+	dirty bool
+	mu    *sync.Mutex
+}
+
+func newObjectIndex() *objectIndex {
+	return &objectIndex{objects: make(map[string]KubeObject), mu: &sync.Mutex{}}
+}
+
+func (i *objectIndex) put(name string, o KubeObject) {
+	if _, ok := i.objects[name]; !ok {
+		i.dirty = true
+	}
+	i.objects[name] = o
+}
+
+func (i *objectIndex) get(name string) (KubeObject, bool) {
+	o, ok := i.objects[name]
+	return o, ok
+}
+
+func (i *objectIndex) delete(name string) {
+	if _, ok := i.objects[name]; ok {
+		delete(i.objects, name)
+		i.dirty = true
+	}
+}
+
+func (i *objectIndex) len() int {
+	return len(i.objects)
+}
+
+//sorted returns the sorted names. The returned slice is not changed afterwards,
+//changes of the index make a new one
+func (i *objectIndex) sorted() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.dirty {
+		return i.names
+	}
+
+	names := make([]string, 0, len(i.objects))
+	for name := range i.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	i.names = names
+	i.dirty = false
+	return names
+}
+
+//withPrefix returns at most limit objects whose names start with the prefix, sorted by name.
+//Limit 0 means no limit
+func (i *objectIndex) withPrefix(prefix string, limit int) []KubeObject {
+	return i.match(MatchPrefix, prefix, objectSelector{}, limit)
+}
+
+//namesWithPrefix returns the sorted names that start with the prefix, found with binary search
+func (i *objectIndex) namesWithPrefix(prefix string) []string {
+	names := i.sorted()
+	start := sort.SearchStrings(names, prefix)
+	end := start
+	for end < len(names) && strings.HasPrefix(names[end], prefix) {
+		end++
+	}
+	return names[start:end]
+}
+
+//Modes of matching names of objects with the word given in a filter
+const (
+	MatchPrefix    = "prefix"
+	MatchSubstring = "substring"
+	MatchFuzzy     = "fuzzy"
+)
+
+var matchModes = []string{MatchPrefix, MatchSubstring, MatchFuzzy}
+
+func isMatchMode(mode string) bool {
+	for _, m := range matchModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+//matches tells whether the name matches the word in the given mode. Empty mode is the prefix mode.
+//In the fuzzy mode all characters of the word must appear in the name in the same order
+func matches(mode string, word string, name string) bool {
+	switch mode {
+	case MatchSubstring:
+		return strings.Contains(name, word)
+	case MatchFuzzy:
+		for _, r := range word {
+			i := strings.IndexRune(name, r)
+			if i < 0 {
+				return false
+			}
+			name = name[i+len(string(r)):]
+		}
+		return true
+	default:
+		return strings.HasPrefix(name, word)
+	}
+}
+
+//match returns at most limit objects whose names match the word and which match the selector,
+//sorted by name. Limit 0 means no limit. Prefixes are found with binary search, other modes scan all names
+func (i *objectIndex) match(mode string, word string, selector objectSelector, limit int) []KubeObject {
+	var names []string
+	if mode == "" || mode == MatchPrefix {
+		names = i.namesWithPrefix(word)
+	} else {
+		names = i.sorted()
+	}
+
+	res := []KubeObject{}
+	for _, name := range names {
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+		o := i.objects[name]
+		if matches(mode, word, name) && selector.matches(o) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+//kindIndex keeps objects of one kind by their lowercase namespace. Objects of cluster-scoped
+//resources are kept under the empty namespace
+type kindIndex map[string]*objectIndex
+
+func (k kindIndex) put(key ObjectKey, o KubeObject) {
+	i, ok := k[key.Namespace]
+	if !ok {
+		i = newObjectIndex()
+		k[key.Namespace] = i
+	}
+	i.put(key.Name, o)
+}
+
+func (k kindIndex) delete(key ObjectKey) {
+	if i, ok := k[key.Namespace]; ok {
+		i.delete(key.Name)
+		if i.len() == 0 {
+			delete(k, key.Namespace)
+		}
+	}
+}
+
+func (k kindIndex) get(key ObjectKey) (KubeObject, bool) {
+	if i, ok := k[key.Namespace]; ok {
+		return i.get(key.Name)
+	}
+	return KubeObject{}, false
+}
+
+//names returns the sorted namespaces that have objects
+func (k kindIndex) names() []string {
+	keys := make([]string, 0, len(k))
+	for ns := range k {
+		keys = append(keys, ns)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//namespaces returns the indexes of all namespaces, sorted by namespace
+func (k kindIndex) namespaces() []*objectIndex {
+	keys := k.names()
+	res := make([]*objectIndex, len(keys))
+	for j, ns := range keys {
+		res[j] = k[ns]
+	}
+	return res
+}
+
+//serverIndex keeps objects of one server by their group and kind
+type serverIndex map[resourceKey]kindIndex
+
+func (s serverIndex) put(key ObjectKey, o KubeObject) {
+	k, ok := s[key.resource()]
+	if !ok {
+		k = make(kindIndex)
+		s[key.resource()] = k
+	}
+	k.put(key, o)
+}
+
+func (s serverIndex) delete(key ObjectKey) {
+	if k, ok := s[key.resource()]; ok {
+		k.delete(key)
+		if len(k) == 0 {
+			delete(s, key.resource())
+		}
+	}
+}
+
+func (s serverIndex) get(key ObjectKey) (KubeObject, bool) {
+	if k, ok := s[key.resource()]; ok {
+		return k.get(key)
+	}
+	return KubeObject{}, false
+}
+
+//all returns all objects sorted by kind, group, namespace and name
+func (s serverIndex) all() []KubeObject {
+	keys := make([]resourceKey, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Kind != keys[j].Kind {
+			return keys[i].Kind < keys[j].Kind
+		}
+		return keys[i].Group < keys[j].Group
+	})
+
+	res := []KubeObject{}
+	for _, key := range keys {
+		for _, i := range s[key].namespaces() {
+			res = append(res, i.withPrefix("", 0)...)
+		}
+	}
+	return res
+}
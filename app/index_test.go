@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func names(objects []KubeObject) []string {
+	res := []string{}
+	for _, o := range objects {
+		res = append(res, o.Name)
+	}
+	return res
+}
+
+func TestObjectIndex(t *testing.T) {
+	i := newObjectIndex()
+	for _, name := range []string{"web-2", "api-1", "web-1", "web-10", "db"} {
+		i.put(name, KubeObject{ObjectMeta: ObjectMeta{Name: name}})
+	}
+
+	assert.Equal(t, []string{"api-1", "db", "web-1", "web-10", "web-2"}, names(i.withPrefix("", 0)))
+	assert.Equal(t, []string{"web-1", "web-10"}, names(i.withPrefix("web-1", 0)))
+	assert.Equal(t, []string{}, names(i.withPrefix("x", 0)))
+
+	i.put("web-1", KubeObject{ObjectMeta: ObjectMeta{Name: "web-1", ResourceVersion: "2"}})
+	i.delete("web-10")
+	i.delete("missing")
+	i.put("web-0", KubeObject{ObjectMeta: ObjectMeta{Name: "web-0"}})
+
+	assert.Equal(t, []KubeObject{
+		{ObjectMeta: ObjectMeta{Name: "web-0"}},
+		{ObjectMeta: ObjectMeta{Name: "web-1", ResourceVersion: "2"}},
+		{ObjectMeta: ObjectMeta{Name: "web-2"}},
+	}, i.withPrefix("web", 0))
+	assert.Equal(t, 5, i.len())
+}
+
+func TestObjectIndexConcurrentLookups(t *testing.T) {
+	i := newObjectIndex()
+	for n := 0; n < 100; n++ {
+		name := fmt.Sprintf("pod-%d", n)
+		i.put(name, KubeObject{ObjectMeta: ObjectMeta{Name: name}})
+	}
+
+	found := make(chan int)
+	for n := 0; n < 10; n++ {
+		go func() {
+			found <- len(i.match(MatchSubstring, "-1", objectSelector{}, 0)) + len(i.withPrefix("pod-2", 0))
+		}()
+	}
+	for n := 0; n < 10; n++ {
+		assert.Equal(t, 22, <-found)
+	}
+}
+
+func TestServerIndex(t *testing.T) {
+	s := make(serverIndex)
+	objects := []KubeObject{
+		{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "b", Namespace: "ns2"}},
+		{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "b", Namespace: "ns1"}},
+		{TypeMeta: TypeMeta{Kind: "Pod"}, ObjectMeta: ObjectMeta{Name: "a", Namespace: "NS1"}},
+		{TypeMeta: TypeMeta{Kind: "node"}, ObjectMeta: ObjectMeta{Name: "n"}},
+	}
+	for _, o := range objects {
+		s.put(newObjectKey(KubeServer{}, o), o)
+	}
+
+	assert.Equal(t, []KubeObject{objects[3], objects[2], objects[1], objects[0]}, s.all())
+	assert.Equal(t, 1, len(s[resourceKey{Kind: "pod"}]), "namespaces must be case-insensitive")
+
+	s.delete(newObjectKey(KubeServer{}, objects[3]))
+	s.delete(newObjectKey(KubeServer{}, objects[0]))
+	_, ok := s[resourceKey{Kind: "node"}]
+	assert.False(t, ok, "empty kinds must be removed")
+	assert.Equal(t, []KubeObject{objects[2], objects[1]}, s.all())
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		mode     string
+		word     string
+		name     string
+		expected bool
+	}{
+		{"", "web", "web-1", true},
+		{MatchPrefix, "", "web-1", true},
+		{MatchPrefix, "eb", "web-1", false},
+		{MatchSubstring, "eb", "web-1", true},
+		{MatchSubstring, "wb", "web-1", false},
+		{MatchFuzzy, "wb1", "web-1", true},
+		{MatchFuzzy, "1w", "web-1", false},
+		{MatchFuzzy, "ww", "web-1", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, matches(test.mode, test.word, test.name), "%+v", test)
+	}
+}
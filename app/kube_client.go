@@ -2,14 +2,19 @@ package app
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	log "github.com/Sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"time"
 )
 
 type EventType string
@@ -18,6 +23,8 @@ const (
 	Added    EventType = "ADDED"
 	Modified EventType = "MODIFIED"
 	Deleted  EventType = "DELETED"
+	Error    EventType = "ERROR"
+	Bookmark EventType = "BOOKMARK"
 )
 
 type ObjectEvent struct {
@@ -25,36 +32,107 @@ type ObjectEvent struct {
 	Object *KubeObject `json:"object"`
 }
 
+type ListMeta struct {
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
 type ObjectList struct {
-	Objects []KubeObject `json:"items"`
+	ListMeta `json:"metadata,omitempty"`
+	Objects  []KubeObject `json:"items"`
+}
+
+//Status is returned by API server when a request fails
+type Status struct {
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+type StatusError struct {
+	Status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.Code, e.Reason)
+}
+
+//isGone tells whether the error is caused by a resource version which is too old to watch from
+func isGone(err error) bool {
+	se, ok := err.(*StatusError)
+	return ok && se.Code == http.StatusGone
 }
 
 type KubeClient interface {
 	Server() KubeServer
-	Ping() error
-	WatchObjects(kind string, out chan *ObjectEvent) error
-	GetObjects(kind string) ([]KubeObject, error)
+	Ping(ctx context.Context) error
+	Resources(ctx context.Context) ([]KubeResource, error)
+	WatchObjects(ctx context.Context, r KubeResource, resourceVersion string, out chan *ObjectEvent) error
+	GetObjects(ctx context.Context, r KubeResource) (*ObjectList, error)
+	GetCustomResourceDefinitions(ctx context.Context) (*CustomResourceDefinitionList, error)
+	WatchCustomResourceDefinitions(ctx context.Context, resourceVersion string, out chan *CRDEvent) error
+	CanList(ctx context.Context, token string, r KubeResource, namespace string) (bool, error)
+	Authenticate(ctx context.Context, token string) error
 }
 
 type DefaultKubeClient struct {
 	client  *http.Client
 	baseURL *url.URL
+	//reviewClient sends requests without credentials of kubeconfig,
+	//so that access of other users can be reviewed with their tokens
+	reviewClient *http.Client
+
+	registry   *ResourceRegistry
+	registryMu *sync.Mutex
 }
 
 //NewKubeClient returns a client that talks to Kubenetes API server.
 //It talks to only one server, and uses configuration of the current context in the
 //given config
 func NewKubeClient(config *Config) KubeClient {
-	tlsConfig, _ := config.GenerateTLSConfig()
+	tlsConfig, err := config.GenerateTLSConfig()
+	if err != nil {
+		log.WithField("error", err).Warn("could not configure TLS")
+	}
+	proxy, err := config.GenerateProxy()
+	if err != nil {
+		log.WithField("error", err).Warn("could not configure proxy, connecting directly")
+	}
+	user := config.getUser(config.getCurrentContext().User)
+	exec := newExecCredentials(user.Exec)
+	if exec != nil && tlsConfig != nil && len(tlsConfig.Certificates) == 0 {
+		tlsConfig.GetClientCertificate = exec.clientCertificate
+	}
 	tr := &http.Transport{
 		TLSClientConfig: tlsConfig,
+		Proxy:           proxy,
+	}
+
+	var rt http.RoundTripper = tr
+	if auth, err := newAuthTransport(user, exec, tr); err != nil {
+		log.WithField("error", err).Warn("could not configure authentication, requests will be anonymous")
+	} else {
+		rt = auth
+	}
+	httpClient := &http.Client{Transport: rt}
+
+	var reviewTLSConfig *tls.Config
+	if tlsConfig != nil {
+		reviewTLSConfig = tlsConfig.Clone()
+		reviewTLSConfig.Certificates = nil
+		reviewTLSConfig.GetClientCertificate = nil
+	}
+	reviewClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: reviewTLSConfig, Proxy: proxy},
+		Timeout:   10 * time.Second,
 	}
-	httpClient := &http.Client{Transport: tr}
 
 	url, _ := url.Parse(config.getCurrentCluster().Server)
 	return &DefaultKubeClient{
-		client:  httpClient,
-		baseURL: url,
+		client:       httpClient,
+		baseURL:      url,
+		reviewClient: reviewClient,
+		registryMu:   &sync.Mutex{},
 	}
 }
 
@@ -62,99 +140,342 @@ func (kc *DefaultKubeClient) Server() KubeServer {
 	return KubeServer{kc.baseURL.String()}
 }
 
-func (kc *DefaultKubeClient) Ping() error {
-	req, err := kc.newRequest("GET", "/", nil)
+func (kc *DefaultKubeClient) Ping(ctx context.Context) error {
+	req, err := kc.newRequest(ctx, "GET", "/", nil)
 	if err != nil {
 		return err
 	}
 	return kc.do(req, nil)
 }
 
-func (kc *DefaultKubeClient) WatchObjects(kind string, out chan *ObjectEvent) error {
-	switch kind {
-	case "pod":
-		return kc.watch("api/v1/pods?watch=true", out)
-	case "service":
-		return kc.watch("api/v1/services?watch=true", out)
-	case "deployment":
-		return kc.watch("/apis/extensions/v1beta1/deployments?watch=true", out)
-	default:
-		return fmt.Errorf("unsupported kind: %s", kind)
+//Resources asks API server for the resources it serves. Only the preferred version of each
+//group is considered, and only the resources that can be listed are returned
+func (kc *DefaultKubeClient) Resources(ctx context.Context) ([]KubeResource, error) {
+	res := []KubeResource{}
+
+	var versions APIVersions
+	if err := kc.getJSON(ctx, "api", &versions); err != nil {
+		return nil, fmt.Errorf("could not discover core API versions: %s", err)
+	}
+	if len(versions.Versions) > 0 {
+		rs, err := kc.discoverGroupVersion(ctx, "", versions.Versions[0])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rs...)
+	}
+
+	var groups APIGroupList
+	if err := kc.getJSON(ctx, "apis", &groups); err != nil {
+		return nil, fmt.Errorf("could not discover API groups: %s", err)
+	}
+	for _, g := range groups.Groups {
+		rs, err := kc.discoverGroupVersion(ctx, g.Name, g.PreferredVersion.Version)
+		if err != nil {
+			//aggregated APIs are often unavailable, kubectl ignores them as well
+			log.WithField("server", kc.baseURL.String()).WithField("error", err).Warn("skipped API group")
+			continue
+		}
+		res = append(res, rs...)
+	}
+
+	kc.registryMu.Lock()
+	kc.registry = NewResourceRegistry(res)
+	kc.registryMu.Unlock()
+
+	return res, nil
+}
+
+func (kc *DefaultKubeClient) discoverGroupVersion(ctx context.Context, group string, version string) ([]KubeResource, error) {
+	var list APIResourceList
+	path := "api/" + version
+	if group != "" {
+		path = "apis/" + group + "/" + version
+	}
+	if err := kc.getJSON(ctx, path, &list); err != nil {
+		return nil, fmt.Errorf("could not discover resources of %s: %s", path, err)
+	}
+
+	res := []KubeResource{}
+	for _, r := range list.APIResources {
+		//subresources, such as pods/log, are not objects
+		if strings.Contains(r.Name, "/") {
+			continue
+		}
+		kr := newKubeResource(group, version, r)
+		if kr.hasVerb("list") {
+			res = append(res, kr)
+		}
+	}
+	return res, nil
+}
+
+//resource finds resource by the given name in the discovered resources.
+//If discovery is not supported by the server, the default resources are used
+func (kc *DefaultKubeClient) resource(ctx context.Context, kind string) (KubeResource, error) {
+	kc.registryMu.Lock()
+	registry := kc.registry
+	kc.registryMu.Unlock()
+
+	if registry == nil {
+		rs, err := kc.Resources(ctx)
+		if err != nil {
+			log.WithField("server", kc.baseURL.String()).WithField("error", err).Warn("discovery failed, using default resources")
+			rs = defaultResources
+		}
+		registry = NewResourceRegistry(rs)
+		kc.registryMu.Lock()
+		kc.registry = registry
+		kc.registryMu.Unlock()
+	}
+
+	r, ok := registry.Lookup(kind)
+	if !ok {
+		//the resource might have been defined after the last discovery, e.g. by a new CRD
+		if rs, err := kc.Resources(ctx); err == nil {
+			r, ok = NewResourceRegistry(rs).Lookup(kind)
+		}
+	}
+	if !ok {
+		return r, fmt.Errorf("unsupported kind: %s", kind)
+	}
+	return r, nil
+}
+
+//WatchObjects sends changes of the objects to the given channel, starting from the given resource version.
+//Bookmarks are requested, so that the resource version is known even when the objects do not change.
+//It returns when the server closes the connection or the context is canceled.
+//If the resource version is too old, an error recognised by isGone is returned
+func (kc *DefaultKubeClient) WatchObjects(ctx context.Context, r KubeResource, resourceVersion string, out chan *ObjectEvent) error {
+	params := url.Values{}
+	params.Set("watch", "true")
+	params.Set("allowWatchBookmarks", "true")
+	if resourceVersion != "" {
+		params.Set("resourceVersion", resourceVersion)
+	}
+
+	return kc.watch(ctx, r.path()+"?"+params.Encode(), r, func(d *json.Decoder) error {
+		var event struct {
+			Type   EventType       `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := d.Decode(&event); err != nil {
+			return err
+		}
+
+		if event.Type == Error {
+			var status Status
+			if err := json.Unmarshal(event.Object, &status); err != nil {
+				return err
+			}
+			return &StatusError{status}
+		}
+
+		var o KubeObject
+		if err := json.Unmarshal(event.Object, &o); err != nil {
+			return err
+		}
+		o.Kind = r.Singular
+		o.Group = r.Group
+
+		select {
+		case out <- &ObjectEvent{event.Type, &o}:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+}
+
+func (kc *DefaultKubeClient) GetCustomResourceDefinitions(ctx context.Context) (*CustomResourceDefinitionList, error) {
+	r, err := kc.resource(ctx, crdResourceName)
+	if err != nil {
+		return nil, err
+	}
+	var list CustomResourceDefinitionList
+	if err := kc.getJSON(ctx, r.path(), &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+//WatchCustomResourceDefinitions sends changes of the definitions to the given channel, starting from the given resource version.
+//If the resource version is too old, an error recognised by isGone is returned
+func (kc *DefaultKubeClient) WatchCustomResourceDefinitions(ctx context.Context, resourceVersion string, out chan *CRDEvent) error {
+	r, err := kc.resource(ctx, crdResourceName)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("watch", "true")
+	if resourceVersion != "" {
+		params.Set("resourceVersion", resourceVersion)
+	}
+
+	return kc.watch(ctx, r.path()+"?"+params.Encode(), r, func(d *json.Decoder) error {
+		var event struct {
+			Type   EventType       `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := d.Decode(&event); err != nil {
+			return err
+		}
+
+		if event.Type == Error {
+			var status Status
+			if err := json.Unmarshal(event.Object, &status); err != nil {
+				return err
+			}
+			return &StatusError{status}
+		}
+
+		var crd CustomResourceDefinition
+		if err := json.Unmarshal(event.Object, &crd); err != nil {
+			return err
+		}
+
+		select {
+		case out <- &CRDEvent{event.Type, &crd}:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+}
+
+func (kc *DefaultKubeClient) GetObjects(ctx context.Context, r KubeResource) (*ObjectList, error) {
+	return kc.get(ctx, r.path(), r)
+}
+
+//SelfSubjectAccessReview asks API server what the user who sends it can do
+type SelfSubjectAccessReview struct {
+	APIVersion string                      `json:"apiVersion"`
+	Kind       string                      `json:"kind"`
+	Spec       SelfSubjectAccessReviewSpec `json:"spec"`
+	Status     SubjectAccessReviewStatus   `json:"status,omitempty"`
+}
+
+type SelfSubjectAccessReviewSpec struct {
+	ResourceAttributes ResourceAttributes `json:"resourceAttributes"`
+}
+
+type ResourceAttributes struct {
+	Namespace string `json:"namespace,omitempty"`
+	Verb      string `json:"verb"`
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource"`
+}
+
+type SubjectAccessReviewStatus struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+//CanList asks API server whether the user with the given token can list objects of the resource
+//in the namespace, or in all namespaces if the namespace is empty
+func (kc *DefaultKubeClient) CanList(ctx context.Context, token string, r KubeResource, namespace string) (bool, error) {
+	review := SelfSubjectAccessReview{
+		APIVersion: "authorization.k8s.io/v1",
+		Kind:       "SelfSubjectAccessReview",
+		Spec: SelfSubjectAccessReviewSpec{ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "list",
+			Group:     r.Group,
+			Resource:  r.Name,
+		}},
+	}
+	req, err := kc.newRequest(ctx, "POST", "apis/authorization.k8s.io/v1/selfsubjectaccessreviews", review)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var res SelfSubjectAccessReview
+	if err := kc.doWith(kc.reviewClient, req, &res); err != nil {
+		return false, err
 	}
+	return res.Status.Allowed, nil
 }
 
-func (kc *DefaultKubeClient) GetObjects(kind string) ([]KubeObject, error) {
-	switch kind {
-	case "node":
-		return kc.get("api/v1/nodes", kind)
-	case "configmap":
-		return kc.get("api/v1/configmaps", kind)
-	case "service":
-		return kc.get("api/v1/services", kind)
-	case "deployment":
-		return kc.get("/apis/extensions/v1beta1/deployments", kind)
-	case "namespace":
-		return kc.get("api/v1/namespaces", kind)
-	default:
-		return []KubeObject{}, fmt.Errorf("unsupported kind: %s", kind)
+//Authenticate returns an error if API server does not accept the token. A SelfSubjectAccessReview
+//is sent, because any authenticated user may create it, while TokenReview needs privileges
+func (kc *DefaultKubeClient) Authenticate(ctx context.Context, token string) error {
+	namespaces, _ := defaultRegistry.Lookup("namespaces")
+	_, err := kc.CanList(ctx, token, namespaces, "")
+	return err
+}
+
+func (kc *DefaultKubeClient) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := kc.newRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return err
 	}
+	return kc.do(req, v)
 }
 
-func (kc *DefaultKubeClient) get(url string, kind string) ([]KubeObject, error) {
-	req, err := kc.newRequest("GET", url, nil)
+func (kc *DefaultKubeClient) get(ctx context.Context, url string, r KubeResource) (*ObjectList, error) {
+	req, err := kc.newRequest(ctx, "GET", url, nil)
 	if err != nil {
-		return []KubeObject{}, err
+		return nil, err
 	}
 
 	var list ObjectList
 	err = kc.do(req, &list)
 	if err != nil {
-		return []KubeObject{}, err
+		return nil, err
 	}
 
 	for i := range list.Objects {
-		list.Objects[i].Kind = kind
+		list.Objects[i].Kind = r.Singular
+		list.Objects[i].Group = r.Group
 	}
 
-	return list.Objects, nil
+	return &list, nil
 }
 
-func (kc *DefaultKubeClient) watch(url string, out chan *ObjectEvent) error {
-	req, err := kc.newRequest("GET", url, nil)
+//watch opens a stream of events of the given resource. Each event is decoded
+//by the given function until the server closes the stream or the context is canceled
+func (kc *DefaultKubeClient) watch(ctx context.Context, url string, r KubeResource, decode func(d *json.Decoder) error) error {
+	req, err := kc.newRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
 
 	res, err := kc.client.Do(req)
 	if err != nil {
+		if isStopped(ctx) {
+			return nil
+		}
 		return err
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("Failed to watch pods: %d", res.StatusCode)
+		var status Status
+		if err := json.NewDecoder(res.Body).Decode(&status); err == nil && status.Code != 0 {
+			return &StatusError{status}
+		}
+		return fmt.Errorf("Failed to watch %s: %d", r.Name, res.StatusCode)
 	}
 
 	d := json.NewDecoder(res.Body)
 
 	for {
-		var event ObjectEvent
-		err := d.Decode(&event)
+		err := decode(d)
 
-		if err == io.EOF {
+		if err == io.EOF || isStopped(ctx) {
 			return nil
 		}
 
-		if err != nil {
-			return fmt.Errorf("Could not decode data into pod event: %s", err)
+		if _, ok := err.(*StatusError); ok {
+			return err
 		}
 
-		out <- &event
+		if err != nil {
+			return fmt.Errorf("Could not decode data into %s event: %s", r.Singular, err)
+		}
 	}
-
-	return nil
 }
 
-func (kc *DefaultKubeClient) newRequest(method string, urlStr string, body interface{}) (*http.Request, error) {
+func (kc *DefaultKubeClient) newRequest(ctx context.Context, method string, urlStr string, body interface{}) (*http.Request, error) {
 	rel, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, err
@@ -174,6 +495,7 @@ func (kc *DefaultKubeClient) newRequest(method string, urlStr string, body inter
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
@@ -183,7 +505,11 @@ func (kc *DefaultKubeClient) newRequest(method string, urlStr string, body inter
 }
 
 func (c *DefaultKubeClient) do(req *http.Request, v interface{}) error {
-	resp, err := c.client.Do(req)
+	return c.doWith(c.client, req, v)
+}
+
+func (c *DefaultKubeClient) doWith(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -216,23 +542,50 @@ type TestKubeClient struct {
 	baseURL *url.URL
 	pings   int
 
+	resources      []KubeResource
+	resourcesError error
+	//resourcesHang makes discovery wait until its context is done
+	resourcesHang bool
+
 	objectEvents  []*ObjectEvent
 	objectEventsF func() []*ObjectEvent
 
-	watchObjectHits  map[string]int
-	watchObjectLock  *sync.RWMutex
-	watchObjectError error
-
-	objects       []KubeObject
-	objectsF      func() []KubeObject
-	getObjectHits map[string]int
+	watchObjectHits     map[string]int
+	watchObjectVersions map[string][]string
+	watchObjectLock     *sync.RWMutex
+	watchObjectError    error
+	openWatches         int
+	//watchObjectCloses makes watches end after the events are sent and the duration passes
+	watchObjectCloses time.Duration
+
+	objects         []KubeObject
+	objectsF        func() []KubeObject
+	getObjectHits   map[string]int
+	resourceVersion string
+	//objectsHang makes lists of objects wait until their context is done
+	objectsHang bool
+
+	crds        []CustomResourceDefinition
+	crdEvents   []*CRDEvent
+	crdLists    int
+	crdVersions []string
+	//crdWatchCloses makes watches of definitions end after the events are sent and the duration passes
+	crdWatchCloses time.Duration
+
+	canList     func(token string, r KubeResource, namespace string) (bool, error)
+	canListHits int
+
+	authenticate     func(token string) error
+	authenticateHits int
 }
 
 func NewTestKubeClient() *TestKubeClient {
 	kc := &TestKubeClient{}
 	kc.baseURL, _ = url.Parse(fmt.Sprintf("http://random-url-%d.com", rand.Intn(999)))
 	kc.watchObjectLock = &sync.RWMutex{}
+	kc.resources = defaultResources
 	kc.watchObjectHits = map[string]int{}
+	kc.watchObjectVersions = map[string][]string{}
 	kc.objectEventsF = func() []*ObjectEvent { return []*ObjectEvent{} }
 	kc.objects = []KubeObject{}
 	kc.objectsF = func() []KubeObject { return []KubeObject{} }
@@ -244,14 +597,36 @@ func (kc *TestKubeClient) Server() KubeServer {
 	return KubeServer{kc.baseURL.String()}
 }
 
-func (kc *TestKubeClient) Ping() error {
+func (kc *TestKubeClient) Ping(ctx context.Context) error {
+	kc.watchObjectLock.Lock()
 	kc.pings += 1
+	kc.watchObjectLock.Unlock()
 	return nil
 }
 
-func (kc *TestKubeClient) WatchObjects(kind string, out chan *ObjectEvent) error {
+func (kc *TestKubeClient) Resources(ctx context.Context) ([]KubeResource, error) {
+	if kc.resourcesHang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return kc.resources, kc.resourcesError
+}
+
+//hitKey is the key of the resource in the maps of hits of TestKubeClient,
+//the singular name qualified by the group, e.g. "pod" or "service.serving.knative.dev"
+func hitKey(r KubeResource) string {
+	if r.Group == "" {
+		return r.Singular
+	}
+	return r.Singular + "." + r.Group
+}
+
+func (kc *TestKubeClient) WatchObjects(ctx context.Context, r KubeResource, resourceVersion string, out chan *ObjectEvent) error {
+	kind := hitKey(r)
 	kc.watchObjectLock.Lock()
 	kc.watchObjectHits[kind] += 1
+	hits := kc.watchObjectHits[kind]
+	kc.watchObjectVersions[kind] = append(kc.watchObjectVersions[kind], resourceVersion)
 	kc.watchObjectLock.Unlock()
 
 	for i := range kc.objectEvents {
@@ -262,21 +637,154 @@ func (kc *TestKubeClient) WatchObjects(kind string, out chan *ObjectEvent) error
 		out <- o
 	}
 
-	if kc.watchObjectHits[kind] < 5 && kc.watchObjectError != nil {
+	if hits < 5 && kc.watchObjectError != nil {
 		return kc.watchObjectError
 	}
+	if kc.watchObjectCloses > 0 {
+		sleep(ctx, kc.watchObjectCloses)
+		return nil
+	}
+
+	kc.watchObjectLock.Lock()
+	kc.openWatches += 1
+	kc.watchObjectLock.Unlock()
+	<-ctx.Done()
+	kc.watchObjectLock.Lock()
+	kc.openWatches -= 1
+	kc.watchObjectLock.Unlock()
+	return nil
+}
+
+func (kc *TestKubeClient) watches() int {
+	kc.watchObjectLock.RLock()
+	defer kc.watchObjectLock.RUnlock()
+	return kc.openWatches
+}
+
+//watchHits returns the number of watches of the given kind, see hitKey
+func (kc *TestKubeClient) watchHits(kind string) int {
+	kc.watchObjectLock.RLock()
+	defer kc.watchObjectLock.RUnlock()
+	return kc.watchObjectHits[kind]
+}
+
+//watchVersions returns the resource versions the watches of the given kind were started from
+func (kc *TestKubeClient) watchVersions(kind string) []string {
+	kc.watchObjectLock.RLock()
+	defer kc.watchObjectLock.RUnlock()
+	return append([]string(nil), kc.watchObjectVersions[kind]...)
+}
+
+//getHits returns the number of lists of the given kind, see hitKey
+func (kc *TestKubeClient) getHits(kind string) int {
+	kc.watchObjectLock.RLock()
+	defer kc.watchObjectLock.RUnlock()
+	return kc.getObjectHits[kind]
+}
+
+//hits returns copies of the numbers of watches and lists of all kinds
+func (kc *TestKubeClient) hits() (watches map[string]int, gets map[string]int) {
+	kc.watchObjectLock.RLock()
+	defer kc.watchObjectLock.RUnlock()
+	watches = make(map[string]int)
+	for k, v := range kc.watchObjectHits {
+		watches[k] = v
+	}
+	gets = make(map[string]int)
+	for k, v := range kc.getObjectHits {
+		gets[k] = v
+	}
+	return watches, gets
+}
+
+//counter returns the value of a counter of the client, e.g. kc.counter(&kc.pings)
+func (kc *TestKubeClient) counter(c *int) int {
+	kc.watchObjectLock.RLock()
+	defer kc.watchObjectLock.RUnlock()
+	return *c
+}
 
-	select {}
+//crdWatchVersions returns the resource versions the watches of definitions were started from
+func (kc *TestKubeClient) crdWatchVersions() []string {
+	kc.watchObjectLock.RLock()
+	defer kc.watchObjectLock.RUnlock()
+	return append([]string(nil), kc.crdVersions...)
 }
 
-func (kc *TestKubeClient) GetObjects(kind string) ([]KubeObject, error) {
+func (kc *TestKubeClient) GetObjects(ctx context.Context, r KubeResource) (*ObjectList, error) {
 	kc.watchObjectLock.Lock()
-	kc.getObjectHits[kind] += 1
+	kc.getObjectHits[hitKey(r)] += 1
+	hang := kc.objectsHang
 	kc.watchObjectLock.Unlock()
+	if hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
 
+	list := &ObjectList{ListMeta: ListMeta{ResourceVersion: kc.resourceVersion}}
 	if len(kc.objects) == 0 {
-		return kc.objectsF(), nil
+		list.Objects = kc.objectsF()
 	} else {
-		return kc.objects, nil
+		list.Objects = kc.objects
+	}
+	return list, nil
+}
+
+func (kc *TestKubeClient) GetCustomResourceDefinitions(ctx context.Context) (*CustomResourceDefinitionList, error) {
+	kc.watchObjectLock.Lock()
+	defer kc.watchObjectLock.Unlock()
+	kc.crdLists += 1
+	items := make([]CustomResourceDefinition, len(kc.crds))
+	copy(items, kc.crds)
+	return &CustomResourceDefinitionList{ListMeta: ListMeta{ResourceVersion: kc.resourceVersion}, Items: items}, nil
+}
+
+func (kc *TestKubeClient) setCRDs(crds []CustomResourceDefinition) {
+	kc.watchObjectLock.Lock()
+	defer kc.watchObjectLock.Unlock()
+	kc.crds = crds
+}
+
+func (kc *TestKubeClient) WatchCustomResourceDefinitions(ctx context.Context, resourceVersion string, out chan *CRDEvent) error {
+	kc.watchObjectLock.Lock()
+	kc.crdVersions = append(kc.crdVersions, resourceVersion)
+	closes := kc.crdWatchCloses
+	kc.watchObjectLock.Unlock()
+
+	for i := range kc.crdEvents {
+		select {
+		case out <- kc.crdEvents[i]:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	if closes > 0 {
+		sleep(ctx, closes)
+		return nil
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (kc *TestKubeClient) CanList(ctx context.Context, token string, r KubeResource, namespace string) (bool, error) {
+	kc.watchObjectLock.Lock()
+	kc.canListHits += 1
+	kc.watchObjectLock.Unlock()
+
+	if kc.canList == nil {
+		return false, nil
+	}
+	return kc.canList(token, r, namespace)
+}
+
+func (kc *TestKubeClient) Authenticate(ctx context.Context, token string) error {
+	kc.watchObjectLock.Lock()
+	kc.authenticateHits += 1
+	kc.watchObjectLock.Unlock()
+
+	if kc.authenticate == nil {
+		return nil
 	}
+	return kc.authenticate(token)
 }
@@ -1,12 +1,15 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var (
@@ -30,6 +33,12 @@ func teardown() {
 	server.Close()
 }
 
+//defaultResource returns the default resource with the given name
+func defaultResource(name string) KubeResource {
+	r, _ := defaultRegistry.Lookup(name)
+	return r
+}
+
 func stream(w http.ResponseWriter, items []string) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -51,9 +60,9 @@ func stream(w http.ResponseWriter, items []string) {
 
 func TestWatchPods(t *testing.T) {
 	events := []interface{}{
-		&ObjectEvent{Added, &KubeObject{ObjectMeta: ObjectMeta{Name: "first"}}},
-		&ObjectEvent{Modified, &KubeObject{ObjectMeta: ObjectMeta{Name: "second"}}},
-		&ObjectEvent{Deleted, &KubeObject{ObjectMeta: ObjectMeta{Name: "last"}}},
+		&ObjectEvent{Added, &KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "first"}}},
+		&ObjectEvent{Modified, &KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "second"}}},
+		&ObjectEvent{Deleted, &KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "last"}}},
 	}
 
 	setup()
@@ -71,7 +80,7 @@ func TestWatchPods(t *testing.T) {
 	)
 
 	inEvents := make(chan *ObjectEvent, 10)
-	err := client.WatchObjects("pod", inEvents)
+	err := client.WatchObjects(context.Background(), defaultResource("pod"), "", inEvents)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -87,9 +96,9 @@ func TestWatchPods(t *testing.T) {
 
 func TestWatchServices(t *testing.T) {
 	events := []interface{}{
-		&ObjectEvent{Added, &KubeObject{ObjectMeta: ObjectMeta{Name: "first"}}},
-		&ObjectEvent{Modified, &KubeObject{ObjectMeta: ObjectMeta{Name: "second"}}},
-		&ObjectEvent{Deleted, &KubeObject{ObjectMeta: ObjectMeta{Name: "last"}}},
+		&ObjectEvent{Added, &KubeObject{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "first"}}},
+		&ObjectEvent{Modified, &KubeObject{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "second"}}},
+		&ObjectEvent{Deleted, &KubeObject{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "last"}}},
 	}
 
 	setup()
@@ -107,7 +116,7 @@ func TestWatchServices(t *testing.T) {
 	)
 
 	inEvents := make(chan *ObjectEvent, 10)
-	err := client.WatchObjects("service", inEvents)
+	err := client.WatchObjects(context.Background(), defaultResource("service"), "", inEvents)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -123,9 +132,9 @@ func TestWatchServices(t *testing.T) {
 
 func TestWatchDeployments(t *testing.T) {
 	events := []interface{}{
-		&ObjectEvent{Added, &KubeObject{ObjectMeta: ObjectMeta{Name: "first"}}},
-		&ObjectEvent{Modified, &KubeObject{ObjectMeta: ObjectMeta{Name: "second"}}},
-		&ObjectEvent{Deleted, &KubeObject{ObjectMeta: ObjectMeta{Name: "last"}}},
+		&ObjectEvent{Added, &KubeObject{TypeMeta: TypeMeta{Kind: "deployment", Group: "extensions"}, ObjectMeta: ObjectMeta{Name: "first"}}},
+		&ObjectEvent{Modified, &KubeObject{TypeMeta: TypeMeta{Kind: "deployment", Group: "extensions"}, ObjectMeta: ObjectMeta{Name: "second"}}},
+		&ObjectEvent{Deleted, &KubeObject{TypeMeta: TypeMeta{Kind: "deployment", Group: "extensions"}, ObjectMeta: ObjectMeta{Name: "last"}}},
 	}
 
 	setup()
@@ -143,7 +152,7 @@ func TestWatchDeployments(t *testing.T) {
 	)
 
 	inEvents := make(chan *ObjectEvent, 10)
-	err := client.WatchObjects("deployment", inEvents)
+	err := client.WatchObjects(context.Background(), defaultResource("deployment"), "", inEvents)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -172,17 +181,17 @@ func TestGetConfigmaps(t *testing.T) {
 	},
 	)
 
-	res, err := client.GetObjects("configmap")
+	res, err := client.GetObjects(context.Background(), defaultResource("configmap"))
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	expected := []KubeObject{
-		{TypeMeta: TypeMeta{"configmap"}, ObjectMeta: ObjectMeta{Name: "x1"}},
-		{TypeMeta: TypeMeta{"configmap"}, ObjectMeta: ObjectMeta{Name: "x2"}},
+		{TypeMeta: TypeMeta{Kind: "configmap"}, ObjectMeta: ObjectMeta{Name: "x1"}},
+		{TypeMeta: TypeMeta{Kind: "configmap"}, ObjectMeta: ObjectMeta{Name: "x2"}},
 	}
 
-	if !reflect.DeepEqual(res, expected) {
+	if !reflect.DeepEqual(res.Objects, expected) {
 		t.Errorf("Expected %+v, got %+v", expected, res)
 	}
 }
@@ -202,17 +211,17 @@ func TestGetNamespaces(t *testing.T) {
 	},
 	)
 
-	res, err := client.GetObjects("namespace")
+	res, err := client.GetObjects(context.Background(), defaultResource("namespace"))
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	expected := []KubeObject{
-		{TypeMeta: TypeMeta{"namespace"}, ObjectMeta: ObjectMeta{Name: "x1"}},
-		{TypeMeta: TypeMeta{"namespace"}, ObjectMeta: ObjectMeta{Name: "x2"}},
+		{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: "x1"}},
+		{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: "x2"}},
 	}
 
-	if !reflect.DeepEqual(res, expected) {
+	if !reflect.DeepEqual(res.Objects, expected) {
 		t.Errorf("Expected %+v, got %+v", expected, res)
 	}
 }
@@ -232,17 +241,17 @@ func TestGetDeployments(t *testing.T) {
 	},
 	)
 
-	res, err := client.GetObjects("deployment")
+	res, err := client.GetObjects(context.Background(), defaultResource("deployment"))
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	expected := []KubeObject{
-		{TypeMeta: TypeMeta{"deployment"}, ObjectMeta: ObjectMeta{Name: "x1"}},
-		{TypeMeta: TypeMeta{"deployment"}, ObjectMeta: ObjectMeta{Name: "x2"}},
+		{TypeMeta: TypeMeta{Kind: "deployment", Group: "extensions"}, ObjectMeta: ObjectMeta{Name: "x1"}},
+		{TypeMeta: TypeMeta{Kind: "deployment", Group: "extensions"}, ObjectMeta: ObjectMeta{Name: "x2"}},
 	}
 
-	if !reflect.DeepEqual(res, expected) {
+	if !reflect.DeepEqual(res.Objects, expected) {
 		t.Errorf("Expected %+v, got %+v", expected, res)
 	}
 }
@@ -262,17 +271,17 @@ func TestGetServices(t *testing.T) {
 	},
 	)
 
-	res, err := client.GetObjects("service")
+	res, err := client.GetObjects(context.Background(), defaultResource("service"))
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	expected := []KubeObject{
-		{TypeMeta: TypeMeta{"service"}, ObjectMeta: ObjectMeta{Name: "x1"}},
-		{TypeMeta: TypeMeta{"service"}, ObjectMeta: ObjectMeta{Name: "x2"}},
+		{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "x1"}},
+		{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "x2"}},
 	}
 
-	if !reflect.DeepEqual(res, expected) {
+	if !reflect.DeepEqual(res.Objects, expected) {
 		t.Errorf("Expected %+v, got %+v", expected, res)
 	}
 }
@@ -292,17 +301,17 @@ func TestGetNodes(t *testing.T) {
 	},
 	)
 
-	res, err := client.GetObjects("node")
+	res, err := client.GetObjects(context.Background(), defaultResource("node"))
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
 	expected := []KubeObject{
-		{TypeMeta: TypeMeta{"node"}, ObjectMeta: ObjectMeta{Name: "x1"}},
-		{TypeMeta: TypeMeta{"node"}, ObjectMeta: ObjectMeta{Name: "x2"}},
+		{TypeMeta: TypeMeta{Kind: "node"}, ObjectMeta: ObjectMeta{Name: "x1"}},
+		{TypeMeta: TypeMeta{Kind: "node"}, ObjectMeta: ObjectMeta{Name: "x2"}},
 	}
 
-	if !reflect.DeepEqual(res, expected) {
+	if !reflect.DeepEqual(res.Objects, expected) {
 		t.Errorf("Expected %+v, got %+v", expected, res)
 	}
 }
@@ -316,7 +325,7 @@ func TestPing(t *testing.T) {
 	},
 	)
 
-	err := client.Ping()
+	err := client.Ping(context.Background())
 	assert.NoError(t, err)
 }
 
@@ -329,6 +338,311 @@ func TestPingError(t *testing.T) {
 	},
 	)
 
-	err := client.Ping()
+	err := client.Ping(context.Background())
 	assert.Error(t, err)
 }
+
+func handleDiscovery() {
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "versions": ["v1"] }`)
+	})
+	mux.HandleFunc("/api/v1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `
+			{
+				"groupVersion": "v1",
+				"resources": [
+					{ "name": "pods", "singularName": "", "namespaced": true, "kind": "Pod", "verbs": ["list", "watch"], "shortNames": ["po"] },
+					{ "name": "pods/log", "singularName": "", "namespaced": true, "kind": "Pod", "verbs": ["get"] },
+					{ "name": "bindings", "singularName": "", "namespaced": true, "kind": "Binding", "verbs": ["create"] },
+					{ "name": "nodes", "singularName": "", "namespaced": false, "kind": "Node", "verbs": ["list", "watch"], "shortNames": ["no"] }
+				]
+			}`)
+	})
+	mux.HandleFunc("/apis", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `
+			{
+				"groups": [
+					{ "name": "apps", "preferredVersion": { "groupVersion": "apps/v1", "version": "v1" } },
+					{ "name": "metrics.k8s.io", "preferredVersion": { "groupVersion": "metrics.k8s.io/v1beta1", "version": "v1beta1" } }
+				]
+			}`)
+	})
+	mux.HandleFunc("/apis/apps/v1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `
+			{
+				"groupVersion": "apps/v1",
+				"resources": [
+					{ "name": "statefulsets", "singularName": "statefulset", "namespaced": true, "kind": "StatefulSet", "verbs": ["list", "watch"], "shortNames": ["sts"] }
+				]
+			}`)
+	})
+	mux.HandleFunc("/apis/metrics.k8s.io/v1beta1", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	})
+}
+
+func TestResources(t *testing.T) {
+	setup()
+	defer teardown()
+	handleDiscovery()
+
+	res, err := client.Resources(context.Background())
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	expected := []KubeResource{
+		{Version: "v1", Name: "pods", Singular: "pod", Kind: "Pod", ShortNames: []string{"po"}, Namespaced: true, Verbs: []string{"list", "watch"}},
+		{Version: "v1", Name: "nodes", Singular: "node", Kind: "Node", ShortNames: []string{"no"}, Verbs: []string{"list", "watch"}},
+		{Group: "apps", Version: "v1", Name: "statefulsets", Singular: "statefulset", Kind: "StatefulSet", ShortNames: []string{"sts"}, Namespaced: true, Verbs: []string{"list", "watch"}},
+	}
+	assert.Equal(t, expected, res)
+}
+
+func TestGetDiscoveredResource(t *testing.T) {
+	setup()
+	defer teardown()
+	handleDiscovery()
+
+	mux.HandleFunc("/apis/apps/v1/statefulsets", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "items": [ { "metadata": { "name": "x1" } } ] }`)
+	})
+
+	r, err := client.(*DefaultKubeClient).resource(context.Background(), "sts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := client.GetObjects(context.Background(), r)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	expected := []KubeObject{
+		{TypeMeta: TypeMeta{Kind: "statefulset", Group: "apps"}, ObjectMeta: ObjectMeta{Name: "x1"}},
+	}
+	assert.Equal(t, expected, res.Objects)
+
+	_, err = client.(*DefaultKubeClient).resource(context.Background(), "deployment")
+	assert.Error(t, err, "deployments were not discovered")
+}
+
+func TestWatchCustomResourceDefinitions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "versions": ["v1"] }`)
+	})
+	mux.HandleFunc("/api/v1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "groupVersion": "v1", "resources": [] }`)
+	})
+	mux.HandleFunc("/apis", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "groups": [ { "name": "apiextensions.k8s.io", "preferredVersion": { "version": "v1" } } ] }`)
+	})
+	mux.HandleFunc("/apis/apiextensions.k8s.io/v1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "groupVersion": "apiextensions.k8s.io/v1", "resources": [
+			{ "name": "customresourcedefinitions", "singularName": "customresourcedefinition", "kind": "CustomResourceDefinition", "verbs": ["list", "watch"], "shortNames": ["crd"] }
+		]}`)
+	})
+	mux.HandleFunc("/apis/apiextensions.k8s.io/v1/customresourcedefinitions", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") != "true" {
+			fmt.Fprint(w, `{ "metadata": { "resourceVersion": "7" }, "items": [ { "metadata": { "name": "certificates.cert-manager.io" },
+				"spec": { "group": "cert-manager.io", "scope": "Namespaced", "names": { "plural": "certificates", "kind": "Certificate" } } } ] }`)
+			return
+		}
+		assert.Equal(t, "7", r.URL.Query().Get("resourceVersion"), "must watch from the resource version of the list")
+		stream(w, []string{
+			`{"type": "ADDED", "object": {"metadata": {"name": "certificates.cert-manager.io"}, "spec": {"group": "cert-manager.io", "scope": "Namespaced", "names": {"plural": "certificates", "kind": "Certificate"}, "versions": [{"name": "v1", "served": true, "storage": true}]}}}`,
+			`{"type": "DELETED", "object": {"metadata": {"name": "certificates.cert-manager.io"}, "spec": {"group": "cert-manager.io"}}}`,
+		})
+	})
+
+	list, err := client.GetCustomResourceDefinitions(context.Background())
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(list.Items)) {
+		assert.Equal(t, "7", list.ResourceVersion)
+		assert.Equal(t, "certificates", list.Items[0].resource().Name)
+	}
+
+	events := make(chan *CRDEvent, 10)
+	err = client.WatchCustomResourceDefinitions(context.Background(), "7", events)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	expected := []*CRDEvent{
+		{Added, &CustomResourceDefinition{
+			ObjectMeta: ObjectMeta{Name: "certificates.cert-manager.io"},
+			Spec: CustomResourceDefinitionSpec{
+				Group:    "cert-manager.io",
+				Scope:    "Namespaced",
+				Names:    CustomResourceDefinitionNames{Plural: "certificates", Kind: "Certificate"},
+				Versions: []CustomResourceDefinitionVersion{{Name: "v1", Served: true, Storage: true}},
+			},
+		}},
+		{Deleted, &CustomResourceDefinition{
+			ObjectMeta: ObjectMeta{Name: "certificates.cert-manager.io"},
+			Spec:       CustomResourceDefinitionSpec{Group: "cert-manager.io"},
+		}},
+	}
+	for _, e := range expected {
+		assert.Equal(t, e, <-events)
+	}
+}
+
+func TestWatchFromResourceVersion(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "42", r.URL.Query().Get("resourceVersion"))
+		stream(w, []string{
+			`{"type": "ADDED", "object": {"metadata": {"name": "first", "resourceVersion": "43"}}}`,
+			`{"type": "ERROR", "object": {"kind": "Status", "status": "Failure", "message": "too old resource version: 42 (45)", "reason": "Expired", "code": 410}}`,
+		})
+	})
+
+	events := make(chan *ObjectEvent, 10)
+	err := client.WatchObjects(context.Background(), defaultResource("pod"), "42", events)
+	assert.True(t, isGone(err), "must recognise expired resource version, got %v", err)
+	assert.Equal(t, &ObjectEvent{Added, &KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "first", ResourceVersion: "43"}}}, <-events)
+}
+
+func TestWatchGone(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		fmt.Fprint(w, `{"kind": "Status", "status": "Failure", "message": "too old resource version", "reason": "Gone", "code": 410}`)
+	})
+
+	err := client.WatchObjects(context.Background(), defaultResource("pod"), "1", make(chan *ObjectEvent))
+	assert.True(t, isGone(err), "must recognise expired resource version, got %v", err)
+}
+
+func TestWatchStop(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		stream(w, []string{`{"type": "ADDED", "object": {"metadata": {"name": "first"}}}`})
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- client.WatchObjects(ctx, defaultResource("pod"), "", make(chan *ObjectEvent))
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Errorf("watch must return when stopped")
+	}
+}
+
+func TestGetObjectsResourceVersion(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "metadata": { "resourceVersion": "42" }, "items": [ { "metadata": { "name": "x1", "resourceVersion": "40" } } ] }`)
+	})
+
+	res, err := client.GetObjects(context.Background(), defaultResource("pod"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "42", res.ResourceVersion)
+		assert.Equal(t, []KubeObject{{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "x1", ResourceVersion: "40"}}}, res.Objects)
+	}
+}
+
+func TestGetObjectsContainers(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "metadata": {}, "items": [ { "metadata": { "name": "x1" }, "spec": {
+			"containers": [ { "name": "app", "image": "nginx" } ], "initContainers": [ { "name": "init" } ] } } ] }`)
+	})
+
+	res, err := client.GetObjects(context.Background(), defaultResource("pod"))
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(res.Objects)) {
+		assert.Equal(t, []string{"app", "init"}, res.Objects[0].containerNames())
+	}
+}
+
+func TestGetObjectsLabels(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "metadata": {}, "items": [ { "metadata": { "name": "x1",
+			"labels": { "app": "web" }, "annotations": { "owner": "team-a" } } } ] }`)
+	})
+
+	res, err := client.GetObjects(context.Background(), defaultResource("pod"))
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(res.Objects)) {
+		assert.Equal(t, map[string]string{"app": "web"}, res.Objects[0].Labels)
+		assert.Equal(t, map[string]string{"owner": "team-a"}, res.Objects[0].Annotations)
+	}
+}
+
+func TestWatchPodStatus(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		stream(w, []string{
+			`{"type": "MODIFIED", "object": {"metadata": {"name": "web-0", "deletionTimestamp": "2026-10-17T10:00:00Z"},
+				"status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "False"}]}}}`,
+		})
+	})
+
+	events := make(chan *ObjectEvent, 10)
+	err := client.WatchObjects(context.Background(), defaultResource("pod"), "", events)
+	if assert.NoError(t, err) {
+		o := (<-events).Object
+		assert.Equal(t, "Running", o.Status.Phase)
+		assert.False(t, o.isReady())
+		assert.Equal(t, time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC), o.DeletionTimestamp.UTC())
+		assert.False(t, o.isRunning(), "pod being deleted must not be running")
+	}
+}
+
+func TestWatchBookmarks(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("allowWatchBookmarks"))
+		stream(w, []string{
+			`{"type": "BOOKMARK", "object": {"kind": "Pod", "metadata": {"resourceVersion": "50"}}}`,
+		})
+	})
+
+	events := make(chan *ObjectEvent, 10)
+	err := client.WatchObjects(context.Background(), defaultResource("pod"), "42", events)
+	assert.NoError(t, err)
+	assert.Equal(t, &ObjectEvent{Bookmark, &KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{ResourceVersion: "50"}}}, <-events)
+}
+
+func TestCanList(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var review SelfSubjectAccessReview
+	mux.HandleFunc("/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "Bearer user-token", r.Header.Get("Authorization"), "review must be sent with the token of the client")
+		var req SelfSubjectAccessReview
+		json.NewDecoder(r.Body).Decode(&req)
+		req.Status.Allowed = req.Spec.ResourceAttributes.Namespace == "ns1"
+		review = req
+		json.NewEncoder(w).Encode(req)
+	})
+
+	r, _ := defaultRegistry.Lookup("deployment")
+	allowed, err := client.CanList(context.Background(), "user-token", r, "ns1")
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, ResourceAttributes{Namespace: "ns1", Verb: "list", Group: "extensions", Resource: "deployments"}, review.Spec.ResourceAttributes)
+
+	allowed, err = client.CanList(context.Background(), "user-token", r, "")
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+}
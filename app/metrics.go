@@ -0,0 +1,76 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//metricBuckets are the upper bounds of histogram buckets, in seconds
+var metricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+//histogram counts observations in buckets, as Prometheus histograms do
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(metricBuckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range metricBuckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+//metricsWriter writes metrics in the Prometheus text exposition format.
+//Labels are given as pairs of names and values
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (mw metricsWriter) family(name string, typ string, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (mw metricsWriter) sample(name string, labels []string, v float64) {
+	fmt.Fprintf(mw.w, "%s%s %s\n", name, formatLabels(labels), formatMetricValue(v))
+}
+
+func (mw metricsWriter) histogram(name string, labels []string, h *histogram) {
+	for i, upper := range metricBuckets {
+		mw.sample(name+"_bucket", append(labels[:len(labels):len(labels)], "le", formatMetricValue(upper)), float64(h.counts[i]))
+	}
+	mw.sample(name+"_bucket", append(labels[:len(labels):len(labels)], "le", "+Inf"), float64(h.count))
+	mw.sample(name+"_sum", labels, h.sum)
+	mw.sample(name+"_count", labels, float64(h.count))
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	h.observe(0.001)
+	h.observe(0.2)
+	h.observe(60)
+
+	buf := bytes.NewBuffer([]byte{})
+	metricsWriter{buf}.histogram("latency_seconds", []string{"path", "/api"}, h)
+	assert.Equal(t, `latency_seconds_bucket{path="/api",le="0.005"} 1
+latency_seconds_bucket{path="/api",le="0.01"} 1
+latency_seconds_bucket{path="/api",le="0.025"} 1
+latency_seconds_bucket{path="/api",le="0.05"} 1
+latency_seconds_bucket{path="/api",le="0.1"} 1
+latency_seconds_bucket{path="/api",le="0.25"} 2
+latency_seconds_bucket{path="/api",le="0.5"} 2
+latency_seconds_bucket{path="/api",le="1"} 2
+latency_seconds_bucket{path="/api",le="2.5"} 2
+latency_seconds_bucket{path="/api",le="5"} 2
+latency_seconds_bucket{path="/api",le="10"} 2
+latency_seconds_bucket{path="/api",le="30"} 2
+latency_seconds_bucket{path="/api",le="+Inf"} 3
+latency_seconds_sum{path="/api"} 60.201
+latency_seconds_count{path="/api"} 3
+`, buf.String())
+}
+
+func TestFormatLabels(t *testing.T) {
+	assert.Equal(t, "", formatLabels(nil))
+	assert.Equal(t, `{a="1",b="say \"hi\"\\n\n"}`, formatLabels([]string{"a", "1", "b", "say \"hi\"\\n\n"}))
+}
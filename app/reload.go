@@ -0,0 +1,364 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsnotify/fsnotify"
+)
+
+//reloadDelay is how long changes of kubeconfig are collected before the mirrors are updated.
+//Editors and kubectl often write a file in several steps
+var reloadDelay = 500 * time.Millisecond
+
+//discoveryTimeout is how long discovery of the resources of a server may take
+var discoveryTimeout = 30 * time.Second
+
+//supervisor runs a mirror for each API server. It starts, restarts and stops the mirrors
+//when the servers or their credentials change in kubeconfig
+type supervisor struct {
+	//ctx is the parent of contexts of the mirrors
+	ctx     context.Context
+	f       Factory
+	cache   *MrrCache
+	only    string
+	exclude string
+	backoff Backoff
+
+	mirrors map[KubeServer]*supervisedMirror
+	stopped bool
+	mu      *sync.Mutex
+	//syncMu lets only one sync run at a time, mu is not held while servers are discovered
+	syncMu *sync.Mutex
+}
+
+type supervisedMirror struct {
+	m           *mirror
+	fingerprint string
+}
+
+func newSupervisor(ctx context.Context, f Factory, c *MrrCache, only string, exclude string, b Backoff) *supervisor {
+	return &supervisor{
+		ctx:     ctx,
+		f:       f,
+		cache:   c,
+		only:    only,
+		exclude: exclude,
+		backoff: b,
+		mirrors: make(map[KubeServer]*supervisedMirror),
+		mu:      &sync.Mutex{},
+		syncMu:  &sync.Mutex{},
+	}
+}
+
+//sync makes the running mirrors match the given configs. Mirrors of new servers are started,
+//mirrors of servers with changed configuration are restarted with a new client,
+//and mirrors of servers which are not in the configs are stopped
+func (s *supervisor) sync(configs map[KubeServer]*Config) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	changed := []discoveredServer{}
+	removed := []*mirror{}
+	s.mu.Lock()
+	for server, sm := range s.mirrors {
+		if _, ok := configs[server]; !ok {
+			log.WithField("server", server.URL).Info("server is removed from configuration")
+			removed = append(removed, sm.m)
+			delete(s.mirrors, server)
+		}
+	}
+	for _, server := range sortedServers(configs) {
+		config := configs[server]
+		fingerprint := configFingerprint(config)
+		if sm, ok := s.mirrors[server]; ok && sm.fingerprint == fingerprint {
+			continue
+		}
+		changed = append(changed, discoveredServer{kc: s.f.KubeClient(config), fingerprint: fingerprint})
+	}
+	s.mu.Unlock()
+
+	//loops are stopped and servers are discovered without the lock, so that access reviews
+	//keep using the clients meanwhile
+	for _, m := range removed {
+		m.stopAll()
+	}
+	s.discover(changed)
+
+	for _, d := range changed {
+		server := d.kc.Server()
+		s.mu.Lock()
+		sm, ok := s.mirrors[server]
+		stopped := s.stopped
+		s.mu.Unlock()
+		if stopped {
+			return
+		}
+
+		//objects of the restarted server are served until the new mirror lists them again
+		var handed []KubeResource
+		if ok {
+			log.WithField("server", server.URL).Info("configuration of server has changed, restarting")
+			handed = sm.m.handOver()
+		}
+		m := s.start(d.kc, d.resources)
+		m.dropUnmirrored(handed)
+
+		s.mu.Lock()
+		stopped = s.stopped
+		if !stopped {
+			s.mirrors[server] = &supervisedMirror{m: m, fingerprint: d.fingerprint}
+		}
+		s.mu.Unlock()
+		if stopped {
+			m.stopAll()
+			return
+		}
+	}
+}
+
+//stopAll stops all mirrors and waits until their loops have stopped. Mirrors are not started afterwards
+func (s *supervisor) stopAll() {
+	s.mu.Lock()
+	s.stopped = true
+	mirrors := s.mirrors
+	s.mirrors = make(map[KubeServer]*supervisedMirror)
+	s.mu.Unlock()
+
+	for _, sm := range mirrors {
+		sm.m.stopAll()
+	}
+}
+
+//clients returns the clients of the mirrored servers
+func (s *supervisor) clients() []KubeClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]KubeClient, 0, len(s.mirrors))
+	for _, sm := range s.mirrors {
+		res = append(res, sm.m.kc)
+	}
+	return res
+}
+
+//discoveredServer is a server to be mirrored with the resources it serves
+type discoveredServer struct {
+	kc          KubeClient
+	fingerprint string
+	resources   []KubeResource
+}
+
+//discover finds resources of the servers at the same time. Servers which do not answer
+//within discoveryTimeout, or do not support discovery, are mirrored with the default resources
+func (s *supervisor) discover(servers []discoveredServer) {
+	wg := &sync.WaitGroup{}
+	for i := range servers {
+		wg.Add(1)
+		go func(d *discoveredServer) {
+			defer wg.Done()
+			l := log.WithField("server", d.kc.Server().URL)
+			l.Info("created client")
+
+			ctx, cancel := context.WithTimeout(s.ctx, discoveryTimeout)
+			defer cancel()
+			rs, err := d.kc.Resources(ctx)
+			if err != nil {
+				l.WithField("error", err).Warn("discovery failed, using default resources")
+				rs = defaultResources
+			}
+			d.resources = rs
+		}(&servers[i])
+	}
+	wg.Wait()
+}
+
+//start starts to mirror the resources of the server
+func (s *supervisor) start(kc KubeClient, rs []KubeResource) *mirror {
+	s.cache.setResources(kc.Server(), rs)
+
+	m := newMirror(s.ctx, s.cache, kc, s.only, s.exclude, s.backoff)
+	registry := NewResourceRegistry(rs)
+	for _, r := range registry.Resources() {
+		m.start(r)
+	}
+	if _, ok := registry.Lookup(crdResourceName); ok {
+		m.loopWatchCRDs()
+	}
+	return m
+}
+
+//selectContexts returns a copy of the config for each context which matches any of the patterns.
+//Patterns are globs as in filepath.Match. When several contexts point to the same server,
+//the first one is used
+func selectContexts(config *Config, patterns []string) map[KubeServer]*Config {
+	res := make(map[KubeServer]*Config)
+	for _, ctx := range config.Contexts {
+		if !matchesAnyPattern(ctx.Name, patterns) {
+			continue
+		}
+
+		c := *config
+		c.CurrentContext = ctx.Name
+		server := configServer(&c)
+		if _, ok := res[server]; ok {
+			log.
+				WithField("context", ctx.Name).
+				WithField("server", server.URL).
+				Warn("server is already mirrored by another context, ignoring")
+			continue
+		}
+		res[server] = &c
+	}
+	return res
+}
+
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+//configServer returns the server of the current context, in the form used by clients
+func configServer(config *Config) KubeServer {
+	u, err := url.Parse(config.getCurrentCluster().Server)
+	if err != nil {
+		return KubeServer{config.getCurrentCluster().Server}
+	}
+	return KubeServer{u.String()}
+}
+
+//configFingerprint changes whenever the connection to the server of the current context changes,
+//including content of the referenced certificate and token files, and the settings of exec credentials
+func configFingerprint(config *Config) string {
+	context := config.getCurrentContext()
+	cluster := config.getCluster(context.Cluster)
+	user := config.getUser(context.User)
+
+	var files []string
+	for _, file := range configFiles(config) {
+		raw, _ := ioutil.ReadFile(file)
+		files = append(files, string(raw))
+	}
+
+	raw, _ := json.Marshal(struct {
+		Cluster Cluster
+		User    User
+		Files   []string
+	}{cluster, user, files})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+//configFiles returns the certificate and token files referenced by the current context
+func configFiles(config *Config) []string {
+	context := config.getCurrentContext()
+	cluster := config.getCluster(context.Cluster)
+	user := config.getUser(context.User)
+
+	var files []string
+	for _, file := range []string{cluster.CertificateAuthority, user.ClientCertificate, user.ClientKey, user.TokenFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+//certificateFiles returns the certificate and token files of the servers, so that the mirrors
+//are restarted when the certificates or tokens are rotated
+func certificateFiles(configs map[KubeServer]*Config) []string {
+	var files []string
+	for _, config := range configs {
+		files = append(files, configFiles(config)...)
+	}
+	return files
+}
+
+func sortedServers(configs map[KubeServer]*Config) KubeServers {
+	servers := KubeServers{}
+	for server := range configs {
+		servers = append(servers, server)
+	}
+	sort.Sort(servers)
+	return servers
+}
+
+//watchFiles calls reload after any of the files changes. Reload returns the files to watch next.
+//Directories of the files are watched rather than the files, because editors and kubectl
+//replace files by renaming them
+func watchFiles(ctx context.Context, files []string, reload func() []string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	names := make(map[string]bool)
+	update := func(files []string) {
+		names = make(map[string]bool)
+		for _, file := range files {
+			file, err := substituteUserHome(file)
+			if err != nil {
+				continue
+			}
+			file, err = filepath.Abs(file)
+			if err != nil {
+				continue
+			}
+			names[file] = true
+
+			dir := filepath.Dir(file)
+			if dirs[dir] {
+				continue
+			}
+			if err := w.Add(dir); err != nil {
+				log.WithField("dir", dir).WithField("error", err).Warn("could not watch for changes")
+				continue
+			}
+			dirs[dir] = true
+		}
+	}
+
+	wait := reloadDelay
+	loop := func() {
+		defer w.Close()
+		var delay <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-w.Events:
+				if name, err := filepath.Abs(e.Name); err == nil && names[name] {
+					log.WithField("file", e.Name).WithField("op", e.Op).Debug("file has changed")
+					delay = time.After(wait)
+				}
+			case err := <-w.Errors:
+				log.WithField("error", err).Warn("error while watching for changes")
+			case <-delay:
+				delay = nil
+				update(reload())
+			}
+		}
+	}
+
+	update(files)
+	go loop()
+	return nil
+}
@@ -0,0 +1,302 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testConfig(contexts map[string]string) *Config {
+	config := &Config{}
+	for name, server := range contexts {
+		config.Contexts = append(config.Contexts, ContextWrap{name, Context{Cluster: name, User: name}})
+		config.Clusters = append(config.Clusters, ClusterWrap{name, Cluster{Server: server}})
+		config.Users = append(config.Users, UserWrap{name, User{}})
+	}
+	return config
+}
+
+func TestSelectContexts(t *testing.T) {
+	config := testConfig(map[string]string{
+		"dev":    "http://dev.com",
+		"prod-1": "http://prod1.com",
+		"prod-2": "http://prod2.com",
+	})
+
+	tests := []struct {
+		patterns []string
+		expected []string
+	}{
+		{[]string{"*"}, []string{"http://dev.com", "http://prod1.com", "http://prod2.com"}},
+		{[]string{"prod-*"}, []string{"http://prod1.com", "http://prod2.com"}},
+		{[]string{"dev", "prod-[2]"}, []string{"http://dev.com", "http://prod2.com"}},
+		{[]string{"stage"}, []string{}},
+	}
+
+	for _, test := range tests {
+		configs := selectContexts(config, test.patterns)
+		actual := []string{}
+		for server, c := range configs {
+			actual = append(actual, server.URL)
+			assert.Equal(t, server, configServer(c), "current context must point to the server")
+		}
+		sort.Strings(actual)
+		assert.Equal(t, test.expected, actual, "patterns %v", test.patterns)
+	}
+}
+
+func TestSelectContextsOfSameServer(t *testing.T) {
+	config := testConfig(map[string]string{"a": "http://same.com"})
+	config.Contexts = append(config.Contexts, ContextWrap{"b", Context{Cluster: "a", Namespace: "x"}})
+
+	configs := selectContexts(config, []string{"*"})
+	assert.Equal(t, 1, len(configs))
+	assert.Equal(t, "a", configs[KubeServer{"http://same.com"}].CurrentContext)
+}
+
+func TestConfigFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := path.Join(dir, "ca.pem")
+	ioutil.WriteFile(ca, []byte("first"), 0600)
+
+	config := testConfig(map[string]string{"a": "http://a.com"})
+	config.CurrentContext = "a"
+	config.Clusters[0].Cluster.CertificateAuthority = ca
+
+	first := configFingerprint(config)
+	assert.Equal(t, first, configFingerprint(config))
+
+	ioutil.WriteFile(ca, []byte("second"), 0600)
+	second := configFingerprint(config)
+	assert.NotEqual(t, first, second, "must change with content of certificates")
+
+	config.Users[0].User.Token = "token"
+	third := configFingerprint(config)
+	assert.NotEqual(t, second, third, "must change with credentials")
+
+	token := path.Join(dir, "token")
+	ioutil.WriteFile(token, []byte("first"), 0600)
+	config.Users[0].User.TokenFile = token
+	fourth := configFingerprint(config)
+	ioutil.WriteFile(token, []byte("second"), 0600)
+	fifth := configFingerprint(config)
+	assert.NotEqual(t, fourth, fifth, "must change with content of token file")
+
+	config.Users[0].User.Exec = &ExecConfig{Command: "aws", Args: []string{"eks", "get-token"}, Env: []ExecEnvVar{{"AWS_PROFILE", "dev"}}}
+	sixth := configFingerprint(config)
+	assert.NotEqual(t, fifth, sixth)
+	config.Users[0].User.Exec.Env[0].Value = "prod"
+	assert.NotEqual(t, sixth, configFingerprint(config), "must change with settings of exec credentials")
+}
+
+func TestSupervisorSync(t *testing.T) {
+	f := NewTestFactory()
+	c := NewMrrCache()
+	s := newSupervisor(context.Background(), f, c, "pod", "", testBackoff)
+
+	config := testConfig(map[string]string{"a": "http://a.com", "b": "http://b.com"})
+	configs := selectContexts(config, []string{"*"})
+	s.sync(configs)
+	time.Sleep(50 * time.Millisecond)
+
+	a := KubeServer{"http://a.com"}
+	b := KubeServer{"http://b.com"}
+	assert.Equal(t, 2, len(s.mirrors))
+	assert.Equal(t, 1, f.kubeClient("http://b.com").watchHits("pod"))
+	first := s.mirrors[a].m
+
+	s.sync(configs)
+	assert.True(t, first == s.mirrors[a].m, "unchanged server must not be restarted")
+
+	c.updateKubeObject(b, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p"}})
+	for i := range config.Users {
+		if config.Users[i].Name == "a" {
+			config.Users[i].User.Token = "rotated"
+		}
+	}
+	configs = selectContexts(config, []string{"a"})
+	s.sync(configs)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, 1, len(s.mirrors))
+	assert.True(t, first != s.mirrors[a].m, "server with changed credentials must be restarted")
+	assert.Equal(t, 2, f.kubeClient("http://a.com").watchHits("pod"))
+	assert.Equal(t, 1, f.kubeClient("http://a.com").watches(), "restarted mirror must watch again")
+	assert.Equal(t, 0, f.kubeClient("http://b.com").watches(), "must close watches of removed server")
+	c.mu.RLock()
+	_, ok := c.objects[b]
+	assert.False(t, ok, "must forget objects of removed server")
+	_, ok = c.resources[b]
+	assert.False(t, ok, "must forget resources of removed server")
+	c.mu.RUnlock()
+}
+
+func TestSupervisorSyncKeepsObjectsOfRestartedServer(t *testing.T) {
+	f := NewTestFactory()
+	c := NewMrrCache()
+	s := newSupervisor(context.Background(), f, c, "pod", "", testBackoff)
+	a := KubeServer{"http://a.com"}
+	pod := KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p", Namespace: "ns"}}
+
+	config := testConfig(map[string]string{"a": "http://a.com"})
+	s.sync(selectContexts(config, []string{"*"}))
+	kc := f.kubeClients["http://a.com"]
+	kc.watchObjectLock.Lock()
+	kc.objectsHang = true
+	kc.watchObjectLock.Unlock()
+	c.updateKubeObject(a, pod)
+
+	config.Users[0].User.Token = "rotated"
+	s.sync(selectContexts(config, []string{"*"}))
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, []KubeObject{pod}, c.serverObjects(a), "objects must be served until the new client lists them")
+	s.stopAll()
+	assert.Empty(t, c.serverObjects(a))
+}
+
+func TestSupervisorSyncDiscoversWithoutLock(t *testing.T) {
+	defer func(timeout time.Duration) { discoveryTimeout = timeout }(discoveryTimeout)
+	discoveryTimeout = 300 * time.Millisecond
+
+	f := NewTestFactory()
+	c := NewMrrCache()
+	s := newSupervisor(context.Background(), f, c, "pod", "", testBackoff)
+
+	s.sync(selectContexts(testConfig(map[string]string{"a": "http://a.com"}), []string{"*"}))
+	hanging := NewTestKubeClient()
+	hanging.baseURL, _ = url.Parse("http://b.com")
+	hanging.resourcesHang = true
+	f.kubeClients["http://b.com"] = hanging
+
+	synced := make(chan struct{})
+	go func() {
+		s.sync(selectContexts(testConfig(map[string]string{"a": "http://a.com", "b": "http://b.com"}), []string{"*"}))
+		close(synced)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	clients := make(chan []KubeClient)
+	go func() { clients <- s.clients() }()
+	select {
+	case kcs := <-clients:
+		assert.Equal(t, 1, len(kcs), "mirror of the discovered server must be in use")
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("clients must not wait for discovery")
+	}
+
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		t.Fatal("discovery must time out")
+	}
+	assert.Equal(t, 2, len(s.clients()))
+	c.mu.RLock()
+	registry, ok := c.resources[KubeServer{"http://b.com"}]
+	c.mu.RUnlock()
+	if assert.True(t, ok) {
+		assert.Equal(t, defaultResources, registry.resources, "server which does not answer discovery must be mirrored with default resources")
+	}
+	s.stopAll()
+}
+
+func TestWatchFiles(t *testing.T) {
+	defer func(d time.Duration) { reloadDelay = d }(reloadDelay)
+	reloadDelay = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	watched := path.Join(dir, "config")
+	ioutil.WriteFile(watched, []byte("a"), 0600)
+
+	reloads := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = watchFiles(ctx, []string{watched}, func() []string {
+		reloads <- struct{}{}
+		return []string{watched}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(path.Join(dir, "other"), []byte("b"), 0600)
+	ioutil.WriteFile(watched, []byte("b"), 0600)
+	ioutil.WriteFile(watched, []byte("c"), 0600)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, len(reloads), "changes must be reloaded once")
+
+	os.Rename(path.Join(dir, "other"), watched)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, len(reloads), "replaced file must be reloaded")
+}
+
+func TestRunWatchReloadsKubeconfig(t *testing.T) {
+	defer func(d time.Duration) { reloadDelay = d }(reloadDelay)
+	reloadDelay = 10 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kubeconfig := path.Join(dir, "config")
+	write := func(contexts ...string) {
+		content := "clusters:\n"
+		for _, ctx := range contexts {
+			content += "- name: " + ctx + "\n  cluster:\n    server: http://" + ctx + ".com\n"
+		}
+		content += "contexts:\n"
+		for _, ctx := range contexts {
+			content += "- name: " + ctx + "\n  context:\n    cluster: " + ctx + "\n"
+		}
+		ioutil.WriteFile(kubeconfig, []byte(content), 0600)
+	}
+	write("prod-a", "dev")
+
+	f := NewTestFactory()
+	cmd := NewWatchCommand(f)
+	cmd.Flags().Set("port", "0")
+	cmd.Flags().Set("only", "pod")
+	cmd.Flags().Set("kubeconfig", kubeconfig)
+	go cmd.RunE(cmd, []string{"prod-*"})
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, []string{"http://prod-a.com"}, testFactoryServers(f))
+
+	write("prod-b", "dev")
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, []string{"http://prod-a.com", "http://prod-b.com"}, testFactoryServers(f))
+	assert.Equal(t, 1, f.kubeClient("http://prod-b.com").watchHits("pod"), "must start mirror of new context")
+	f.mrrCache.mu.RLock()
+	_, ok := f.mrrCache.resources[KubeServer{"http://prod-a.com"}]
+	f.mrrCache.mu.RUnlock()
+	assert.False(t, ok, "must stop mirror of removed context")
+}
+
+func testFactoryServers(f *TestFactory) []string {
+	servers := []string{}
+	for _, kc := range f.clients() {
+		servers = append(servers, kc.Server().URL)
+	}
+	sort.Strings(servers)
+	return servers
+}
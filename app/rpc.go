@@ -1,173 +1,473 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"net/rpc"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 type MrrFilter struct {
 	Server    string
 	Namespace string
 	Kind      string
+
+	//Word is the partially typed name, only objects whose names match the word are returned
+	Word string
+	//Match is the mode of matching the names with Word, prefix if empty
+	Match string
+	//Limit is the maximum number of returned objects, 0 for no limit
+	Limit int
+	//LabelSelector selects objects by their labels, in the syntax of kubectl --selector
+	LabelSelector string
+	//FieldSelector selects objects by their fields, in the syntax of kubectl --field-selector
+	FieldSelector string
+	//Running selects only pods that are running and are not being deleted
+	Running bool
 }
 
+//MrrCache keeps objects of API servers indexed by server, group, kind, namespace and name
 type MrrCache struct {
-	objects map[KubeServer][]KubeObject
-	mu      *sync.RWMutex
+	objects   map[KubeServer]serverIndex
+	resources map[KubeServer]*ResourceRegistry
+	states    map[KubeServer]map[resourceKey]resourceState
+	restored  map[KubeServer]map[resourceKey]resourceSnapshot
+	monitor   *monitor
+	//annotations are glob patterns of keys of annotations that are kept with objects
+	annotations []string
+	mu          *sync.RWMutex
 }
 
 func NewMrrCache() *MrrCache {
 	c := &MrrCache{}
 	c.mu = &sync.RWMutex{}
-	c.objects = make(map[KubeServer][]KubeObject)
+	c.objects = make(map[KubeServer]serverIndex)
+	c.resources = make(map[KubeServer]*ResourceRegistry)
+	c.states = make(map[KubeServer]map[resourceKey]resourceState)
+	c.restored = make(map[KubeServer]map[resourceKey]resourceSnapshot)
+	c.monitor = newMonitor()
 	return c
 }
 
-func (c *MrrCache) Objects(f *MrrFilter, os *[]KubeObject) error {
+//Objects returns objects matching the filter that the authorizer allows to see
+func (c *MrrCache) Objects(ctx context.Context, f *MrrFilter, a Authorizer, os *[]KubeObject) error {
+	log.WithField("filter", f).Debug("Received request for objects")
+	if f == nil {
+		return errors.New("Cannot find pods with nil filter")
+	}
+
+	scopes, selector, err := c.authorizedScopes(ctx, f, a)
+	if err != nil {
+		return err
+	}
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	log.WithField("filter", f).Debug("Received request for objects")
+	res := []KubeObject{}
+	for _, s := range scopes {
+		kind := c.objects[s.server][newResourceKey(s.resource.Group, s.resource.Singular)]
+		for _, ns := range s.namespaces {
+			if f.Limit > 0 && len(res) >= f.Limit {
+				break
+			}
+			i, ok := kind[ns]
+			if !ok {
+				continue
+			}
+			limit := 0
+			if f.Limit > 0 {
+				limit = f.Limit - len(res)
+			}
+			res = append(res, i.match(f.Match, f.Word, selector, limit)...)
+		}
+	}
+	log.WithField("filter", f).WithField("objects", res).Debug("Returning result for objects")
+	*os = res
+	return nil
+}
 
+//Labels returns sorted keys of labels of the objects matching the filter, or values of the label with
+//the given key. The word, match mode and limit of the filter apply to the keys or values, not to the names
+func (c *MrrCache) Labels(ctx context.Context, f *MrrFilter, key string, a Authorizer, ls *[]string) error {
+	log.WithField("filter", f).WithField("key", key).Debug("Received request for labels")
 	if f == nil {
-		return errors.New("Cannot find pods with nil filter")
+		return errors.New("Cannot find labels with nil filter")
+	}
+
+	scopes, selector, err := c.authorizedScopes(ctx, f, a)
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	found := make(map[string]bool)
+	for _, s := range scopes {
+		kind := c.objects[s.server][newResourceKey(s.resource.Group, s.resource.Singular)]
+		for _, ns := range s.namespaces {
+			i, ok := kind[ns]
+			if !ok {
+				continue
+			}
+			for _, o := range i.objects {
+				if !selector.matches(o) {
+					continue
+				}
+				if key == "" {
+					for k := range o.Labels {
+						found[k] = true
+					}
+				} else if v, ok := o.Labels[key]; ok {
+					found[v] = true
+				}
+			}
+		}
+	}
+
+	res := []string{}
+	for l := range found {
+		if matches(f.Match, f.Word, l) {
+			res = append(res, l)
+		}
+	}
+	sort.Strings(res)
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+	}
+	*ls = res
+	return nil
+}
+
+//authorizedScopes returns the scopes of the filter reduced to what the authorizer allows to see,
+//and the parsed selectors of the filter
+func (c *MrrCache) authorizedScopes(ctx context.Context, f *MrrFilter, a Authorizer) ([]objectScope, objectSelector, error) {
+	selector, err := newObjectSelector(f)
+	if err != nil {
+		return nil, selector, err
+	}
+
+	scopes, err := c.scopes(f)
+	if err != nil {
+		return nil, selector, err
+	}
+	for _, s := range scopes {
+		if err := selector.fields.check(s.resource); err != nil {
+			return nil, selector, err
+		}
+	}
+	//authorizers may ask API servers, so they are not called while the cache is locked
+	for i := range scopes {
+		scopes[i].authorize(ctx, a)
+	}
+	return scopes, selector, nil
+}
+
+var (
+	//namespaceReviews is how many namespaces of a scope are authorized at the same time
+	namespaceReviews = 8
+	//namespaceReviewTimeout is how long authorization of the namespaces of a scope may take
+	namespaceReviewTimeout = 5 * time.Second
+)
+
+//objectScope is the namespaces of a resource on a server where objects are looked up
+type objectScope struct {
+	server     KubeServer
+	resource   KubeResource
+	namespaces []string
+}
+
+//namespaceAccess is the decision of the authorizer about a namespace
+type namespaceAccess struct {
+	namespace string
+	allowed   bool
+}
+
+//authorize leaves the namespaces that the authorizer allows to see
+func (s *objectScope) authorize(ctx context.Context, a Authorizer) {
+	if a.Allowed(ctx, s.server, s.resource, "") {
+		return
+	}
+	if !s.resource.Namespaced {
+		s.namespaces = nil
+		return
+	}
+
+	//the namespaces are authorized concurrently, the namespaces not authorized in time are left out,
+	//and their reviews are canceled
+	ctx, cancel := context.WithTimeout(ctx, namespaceReviewTimeout)
+	defer cancel()
+	jobs := make(chan string, len(s.namespaces))
+	for _, ns := range s.namespaces {
+		jobs <- ns
+	}
+	close(jobs)
+	results := make(chan namespaceAccess, len(s.namespaces))
+	for i := 0; i < namespaceReviews && i < len(s.namespaces); i++ {
+		go func() {
+			for ns := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				results <- namespaceAccess{ns, a.Allowed(ctx, s.server, s.resource, ns)}
+			}
+		}()
+	}
+
+	allowed := map[string]bool{}
+	for range s.namespaces {
+		select {
+		case r := <-results:
+			allowed[r.namespace] = r.allowed
+		case <-ctx.Done():
+			log.
+				WithField("server", s.server.URL).
+				WithField("kind", s.resource.Singular).
+				Warn("could not authorize all namespaces in time, leaving them out")
+			s.namespaces = filterNamespaces(s.namespaces, allowed)
+			return
+		}
+	}
+	s.namespaces = filterNamespaces(s.namespaces, allowed)
+}
+
+//filterNamespaces returns the allowed namespaces in their order
+func filterNamespaces(namespaces []string, allowed map[string]bool) []string {
+	res := []string{}
+	for _, ns := range namespaces {
+		if allowed[ns] {
+			res = append(res, ns)
+		}
+	}
+	return res
+}
+
+//scopes returns where the objects matching the filter are looked up, sorted by server
+func (c *MrrCache) scopes(f *MrrFilter) ([]objectScope, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if f.Match != "" && !isMatchMode(f.Match) {
+		return nil, fmt.Errorf("Unsupported match mode %s, expected one of %s", f.Match, strings.Join(matchModes, ", "))
 	}
 
 	keys := KubeServers{}
 	for k, _ := range c.objects {
-		if f.Server == "" || strings.EqualFold(trimPort(f.Server), trimPort(k.URL)) {
+		if f.Server == "" || isServer(f.Server, k) {
 			keys = append(keys, k)
 		}
 	}
 	if len(keys) == 0 {
 		log.WithField("server", f.Server).Error("unknown server")
-		return fmt.Errorf("Unknown server %s", f.Server)
+		return nil, fmt.Errorf("Unknown server %s", f.Server)
 	}
 
-	res := []KubeObject{}
+	res := []objectScope{}
 	sort.Sort(keys)
 	for _, k := range keys {
-		for _, o := range c.objects[k] {
-			if strings.EqualFold(o.Kind, f.Kind) &&
-				(f.Namespace == "" || o.Kind == "namespace" || strings.EqualFold(o.Namespace, f.Namespace)) {
-				res = append(res, o)
-			}
+		r, ok := c.resource(k, f.Kind)
+		if !ok {
+			continue
+		}
+
+		s := objectScope{server: k, resource: r}
+		if f.Namespace != "" && r.Namespaced {
+			s.namespaces = []string{strings.ToLower(f.Namespace)}
+		} else {
+			s.namespaces = c.objects[k][newResourceKey(r.Group, r.Singular)].names()
 		}
+		res = append(res, s)
 	}
-	log.WithField("filter", f).WithField("objects", res).Debug("Returning result for objects")
-	*os = res
-	return nil
+	if len(res) == 0 {
+		log.WithField("kind", f.Kind).Error("unsupported resource type")
+		return nil, fmt.Errorf("Unsupported resource type %s", f.Kind)
+	}
+	return res, nil
 }
 
-func (c *MrrCache) updateKubeObject(server KubeServer, o KubeObject) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+//resource finds resource by the name given in a filter. The resources discovered on the server
+//take precedence over the default ones
+func (c *MrrCache) resource(server KubeServer, name string) (KubeResource, bool) {
+	if registry, ok := c.resources[server]; ok {
+		return registry.Lookup(name)
+	}
+	return defaultRegistry.Lookup(name)
+}
 
-	os, ok := c.objects[server]
+//resourceByKey finds the resource of the server by its key
+func (c *MrrCache) resourceByKey(server KubeServer, key resourceKey) (KubeResource, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	registry, ok := c.resources[server]
 	if !ok {
-		os = make([]KubeObject, 0)
+		registry = defaultRegistry
 	}
-
-	found := false
-	for i := range os {
-		if os[i].Name == o.Name && os[i].Namespace == o.Namespace && os[i].Kind == o.Kind {
-			os[i] = o
-			found = true
-			break
+	for _, r := range registry.resources {
+		if r.key() == key {
+			return r, true
 		}
 	}
-
-	if !found {
-		os = append(os, o)
-	}
-	c.objects[server] = os
+	return KubeResource{}, false
 }
 
-func (c *MrrCache) deleteKubeObject(server KubeServer, o KubeObject) {
+func (c *MrrCache) setResources(server KubeServer, rs []KubeResource) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.resources[server] = NewResourceRegistry(rs)
+}
 
-	os, ok := c.objects[server]
+//addResource makes the resource known on the server, e.g. when a new CRD is created
+func (c *MrrCache) addResource(server KubeServer, r KubeResource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	registry, ok := c.resources[server]
 	if !ok {
-		return
+		registry = NewResourceRegistry([]KubeResource{})
+		c.resources[server] = registry
 	}
+	registry.add(r)
+}
 
-	idx := -1
-	for i := range os {
-		if os[i].Name == o.Name && os[i].Namespace == o.Namespace {
-			idx = i
-			break
-		}
+func (c *MrrCache) removeResource(server KubeServer, r KubeResource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if registry, ok := c.resources[server]; ok {
+		registry.remove(r)
 	}
+}
 
-	if idx >= 0 {
-		os = append(os[:idx], os[idx+1:]...)
-		c.objects[server] = os
-	}
+//deleteServer forgets objects and resources of the server
+func (c *MrrCache) deleteServer(server KubeServer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.objects, server)
+	delete(c.resources, server)
+	delete(c.states, server)
+	delete(c.restored, server)
 }
 
-func (c *MrrCache) deleteKubeObjects(s KubeServer, kind string) {
+//updateKubeObject adds the object or replaces the object with the same key
+func (c *MrrCache) updateKubeObject(server KubeServer, o KubeObject) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.put(newObjectKey(server, o), o)
+}
 
-	os, ok := c.objects[s]
-	if !ok {
-		return
+//deleteKubeObject removes the object with the same server, group, kind, namespace and name
+func (c *MrrCache) deleteKubeObject(server KubeServer, o KubeObject) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newObjectKey(server, o)
+	if index, ok := c.objects[key.Server]; ok {
+		index.delete(key)
 	}
+}
 
-	newObjects := []KubeObject{}
-	for i := range os {
-		if os[i].Kind != kind {
-			newObjects = append(newObjects, os[i])
-		}
+//lookupKubeObject finds the object by its key
+func (c *MrrCache) lookupKubeObject(key ObjectKey) (KubeObject, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if index, ok := c.objects[key.Server]; ok {
+		return index.get(key)
 	}
+	return KubeObject{}, false
+}
+
+//deleteKubeObjects removes all objects of the resource
+func (c *MrrCache) deleteKubeObjects(s KubeServer, r KubeResource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	c.objects[s] = newObjects
+	if index, ok := c.objects[s]; ok {
+		delete(index, newResourceKey(r.Group, r.Singular))
+	}
+	delete(c.states[s], newResourceKey(r.Group, r.Singular))
 }
 
-func trimPort(url string) string {
-	i := strings.LastIndex(url, ":")
-	if i < 7 {
-		return url
-	} else {
-		return url[:i]
+//replaceKubeObjects atomically replaces all objects of the resource with the given objects
+func (c *MrrCache) replaceKubeObjects(s KubeServer, r KubeResource, objects []KubeObject) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if index, ok := c.objects[s]; ok {
+		delete(index, newResourceKey(r.Group, r.Singular))
+	}
+	for _, o := range objects {
+		c.put(newObjectKey(s, o), o)
 	}
 }
 
-type MrrClient interface {
-	Objects(f MrrFilter) ([]KubeObject, error)
+func (c *MrrCache) put(key ObjectKey, o KubeObject) {
+	if key.Group != "" || key.Kind != "pod" {
+		o.Spec = nil
+		o.Status = nil
+	}
+	if o.Status != nil {
+		status := PodStatus{Phase: o.Status.Phase}
+		for _, c := range o.Status.Conditions {
+			if c.Type == podReady {
+				status.Conditions = append(status.Conditions, c)
+			}
+		}
+		o.Status = &status
+	}
+	o.Annotations = c.chosenAnnotations(o.Annotations)
+	index, ok := c.objects[key.Server]
+	if !ok {
+		index = make(serverIndex)
+		c.objects[key.Server] = index
+	}
+	index.put(key, o)
 }
 
-type MrrClientDefault struct {
-	conn *rpc.Client
+//keepAnnotations sets glob patterns of keys of annotations that are kept with objects put after the call.
+//Other annotations are dropped, none are kept by default
+func (c *MrrCache) keepAnnotations(patterns []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.annotations = patterns
 }
 
-func NewMrrClient(address string) (*MrrClientDefault, error) {
-	connection, err := rpc.DialHTTP("tcp", address)
-	if err != nil {
-		return nil, err
+func (c *MrrCache) chosenAnnotations(annotations map[string]string) map[string]string {
+	var res map[string]string
+	for k, v := range annotations {
+		if !matchesAnyPattern(k, c.annotations) {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string)
+		}
+		res[k] = v
 	}
-
-	return &MrrClientDefault{conn: connection}, nil
+	return res
 }
 
-func (mc *MrrClientDefault) Objects(f MrrFilter) ([]KubeObject, error) {
-	var os []KubeObject
-	err := mc.conn.Call("MrrCache.Objects", f, &os)
-	return os, err
+//serverObjects returns all objects of the server sorted by kind, group, namespace and name
+func (c *MrrCache) serverObjects(s KubeServer) []KubeObject {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if index, ok := c.objects[s]; ok {
+		return index.all()
+	}
+	return []KubeObject{}
 }
 
-type TestMirrorClient struct {
-	err        error
-	lastFilter MrrFilter
-	objects    []KubeObject
+//isServer tells whether the server is referred by the URL given in a filter, ports are ignored
+func isServer(url string, s KubeServer) bool {
+	return strings.EqualFold(trimPort(url), trimPort(s.URL))
 }
 
-func (mc *TestMirrorClient) Objects(f MrrFilter) ([]KubeObject, error) {
-	mc.lastFilter = f
-	return mc.objects, mc.err
+func trimPort(url string) string {
+	i := strings.LastIndex(url, ":")
+	if i < 7 {
+		return url
+	} else {
+		return url[:i]
+	}
 }
@@ -1,13 +1,17 @@
 package app
 
 import (
+	"context"
+	"github.com/stretchr/testify/assert"
 	"log"
 	"net"
 	"net/http"
-	"net/rpc"
 	"reflect"
+	"strconv"
 	"sync"
 	"testing"
+	"testing/quick"
+	"time"
 )
 
 var (
@@ -24,11 +28,9 @@ func setupRPC() {
 	f := &DefaultFactory{}
 	cache = f.MrrCache()
 	fillCache(cache)
-	rpc.Register(cache)
-	rpc.HandleHTTP()
-	go http.Serve(l, nil)
+	go http.Serve(l, NewMirrorHandler(cache))
 
-	mrrClient, err = f.MrrClient(l.Addr().String())
+	mrrClient, err = f.MrrClient(MirrorEndpoint{Network: "tcp", Address: l.Addr().String()})
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
@@ -39,13 +41,10 @@ func fillCache(c *MrrCache) {
 		ks := KubeServer{s}
 		for _, ns := range []string{"ns1", "ns2", "ns3"} {
 			for _, kind := range []string{"pod", "service", "deployment"} {
+				r, _ := defaultRegistry.Lookup(kind)
 				for _, name := range []string{"a", "b", "c"} {
-					if c.objects[ks] == nil {
-						c.objects[ks] = make([]KubeObject, 0)
-					}
-
-					o := KubeObject{TypeMeta{kind}, ObjectMeta{Name: s + "-" + name, Namespace: ns}}
-					c.objects[ks] = append(c.objects[ks], o)
+					o := KubeObject{TypeMeta: TypeMeta{Kind: kind, Group: r.Group}, ObjectMeta: ObjectMeta{Name: s + "-" + name, Namespace: ns}}
+					c.updateKubeObject(ks, o)
 				}
 			}
 		}
@@ -54,8 +53,8 @@ func fillCache(c *MrrCache) {
 	for _, s := range []string{"server1", "server2"} {
 		ks := KubeServer{s}
 		for _, name := range []string{"ns1", "ns2"} {
-			o := KubeObject{TypeMeta{"namespace"}, ObjectMeta{Name: s + "-" + name}}
-			c.objects[ks] = append(c.objects[ks], o)
+			o := KubeObject{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: s + "-" + name}}
+			c.updateKubeObject(ks, o)
 		}
 	}
 }
@@ -69,100 +68,111 @@ func TestClientObjects(t *testing.T) {
 		isError  bool
 	}{
 		{
-			filter: MrrFilter{},
+			filter:  MrrFilter{},
+			isError: true,
 		},
 		{
-			filter:  MrrFilter{"server_other", "ns1", "pod"},
+			filter:  MrrFilter{Server: "server_other", Namespace: "ns1", Kind: "pod"},
 			isError: true,
 		},
 		{
-			filter: MrrFilter{"server1", "ns_other", "pod"},
+			filter:   MrrFilter{Server: "server1", Namespace: "ns_other", Kind: "pod"},
+			expected: []KubeObject{},
 		},
 		{
-			filter: MrrFilter{"server1", "ns1", "pod_other"},
+			filter:  MrrFilter{Server: "server1", Namespace: "ns1", Kind: "pod_other"},
+			isError: true,
 		},
 		{
-			filter: MrrFilter{"SERVER1", "ns1", "pod"},
+			filter: MrrFilter{Server: "server1", Namespace: "ns1", Kind: "po"},
 			expected: []KubeObject{
-				{TypeMeta{"pod"}, ObjectMeta{"server1-a", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-b", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-c", "ns1", ""}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns1"}},
 			},
 		},
 		{
-			filter: MrrFilter{"server2:8443", "NS1", "pod"},
+			filter: MrrFilter{Server: "SERVER1", Namespace: "ns1", Kind: "pod"},
 			expected: []KubeObject{
-				{TypeMeta{"pod"}, ObjectMeta{"server2-a", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server2-b", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server2-c", "ns1", ""}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns1"}},
 			},
 		},
 		{
-			filter: MrrFilter{"server1", "ns2", "POD"},
+			filter: MrrFilter{Server: "server2:8443", Namespace: "NS1", Kind: "pod"},
 			expected: []KubeObject{
-				{TypeMeta{"pod"}, ObjectMeta{"server1-a", "ns2", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-b", "ns2", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-c", "ns2", ""}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server2-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server2-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server2-c", Namespace: "ns1"}},
 			},
 		},
 		{
-			filter: MrrFilter{"server1", "ns1", "service"},
+			filter: MrrFilter{Server: "server1", Namespace: "ns2", Kind: "POD"},
 			expected: []KubeObject{
-				{TypeMeta{"service"}, ObjectMeta{"server1-a", "ns1", ""}},
-				{TypeMeta{"service"}, ObjectMeta{"server1-b", "ns1", ""}},
-				{TypeMeta{"service"}, ObjectMeta{"server1-c", "ns1", ""}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns2"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns2"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns2"}},
 			},
 		},
 		{
-			filter: MrrFilter{"server1", "ns1", "deployment"},
+			filter: MrrFilter{Server: "server1", Namespace: "ns1", Kind: "service"},
 			expected: []KubeObject{
-				{TypeMeta{"deployment"}, ObjectMeta{"server1-a", "ns1", ""}},
-				{TypeMeta{"deployment"}, ObjectMeta{"server1-b", "ns1", ""}},
-				{TypeMeta{"deployment"}, ObjectMeta{"server1-c", "ns1", ""}},
+				{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns1"}},
 			},
 		},
 		{
-			filter: MrrFilter{"", "ns1", "pod"},
+			filter: MrrFilter{Server: "server1", Namespace: "ns1", Kind: "deployment"},
 			expected: []KubeObject{
-				{TypeMeta{"pod"}, ObjectMeta{"server1-a", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-b", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-c", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server2-a", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server2-b", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server2-c", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server3-a", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server3-b", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server3-c", "ns1", ""}},
+				{TypeMeta: TypeMeta{Kind: "deployment", Group: "extensions"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "deployment", Group: "extensions"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "deployment", Group: "extensions"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns1"}},
 			},
 		},
 		{
-			filter: MrrFilter{"server1", "", "pod"},
+			filter: MrrFilter{Server: "", Namespace: "ns1", Kind: "pod"},
 			expected: []KubeObject{
-				{TypeMeta{"pod"}, ObjectMeta{"server1-a", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-b", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-c", "ns1", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-a", "ns2", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-b", "ns2", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-c", "ns2", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-a", "ns3", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-b", "ns3", ""}},
-				{TypeMeta{"pod"}, ObjectMeta{"server1-c", "ns3", ""}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server2-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server2-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server2-c", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server3-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server3-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server3-c", Namespace: "ns1"}},
 			},
 		},
 		{
-			filter: MrrFilter{"server1", "should be ignored", "namespace"},
+			filter: MrrFilter{Server: "server1", Namespace: "", Kind: "pod"},
 			expected: []KubeObject{
-				{TypeMeta{"namespace"}, ObjectMeta{"server1-ns1", "", ""}},
-				{TypeMeta{"namespace"}, ObjectMeta{"server1-ns2", "", ""}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns1"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns2"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns2"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns2"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-a", Namespace: "ns3"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-b", Namespace: "ns3"}},
+				{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "server1-c", Namespace: "ns3"}},
 			},
 		},
 		{
-			filter: MrrFilter{"", "should be ignored", "namespace"},
+			filter: MrrFilter{Server: "server1", Namespace: "should be ignored", Kind: "namespace"},
 			expected: []KubeObject{
-				{TypeMeta{"namespace"}, ObjectMeta{"server1-ns1", "", ""}},
-				{TypeMeta{"namespace"}, ObjectMeta{"server1-ns2", "", ""}},
-				{TypeMeta{"namespace"}, ObjectMeta{"server2-ns1", "", ""}},
-				{TypeMeta{"namespace"}, ObjectMeta{"server2-ns2", "", ""}},
+				{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: "server1-ns1"}},
+				{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: "server1-ns2"}},
+			},
+		},
+		{
+			filter: MrrFilter{Server: "", Namespace: "should be ignored", Kind: "namespace"},
+			expected: []KubeObject{
+				{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: "server1-ns1"}},
+				{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: "server1-ns2"}},
+				{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: "server2-ns1"}},
+				{TypeMeta: TypeMeta{Kind: "namespace"}, ObjectMeta: ObjectMeta{Name: "server2-ns2"}},
 			},
 		},
 	}
@@ -182,14 +192,109 @@ func TestClientObjects(t *testing.T) {
 func TestDeleteKubeObjects(t *testing.T) {
 	c := NewMrrCache()
 	s := KubeServer{"s"}
-	o1 := KubeObject{TypeMeta: TypeMeta{"x"}, ObjectMeta: ObjectMeta{Name: "x1"}}
-	o2 := KubeObject{TypeMeta: TypeMeta{"y"}, ObjectMeta: ObjectMeta{Name: "y1"}}
+	o1 := KubeObject{TypeMeta: TypeMeta{Kind: "x"}, ObjectMeta: ObjectMeta{Name: "x1"}}
+	o2 := KubeObject{TypeMeta: TypeMeta{Kind: "y"}, ObjectMeta: ObjectMeta{Name: "y1"}}
 	c.updateKubeObject(s, o1)
 	c.updateKubeObject(s, o2)
 
-	c.deleteKubeObjects(s, "y")
-	if !reflect.DeepEqual(c.objects[s], []KubeObject{o1}) {
-		t.Errorf("Cache should contain only %+v, but it contains %+v", o1, c.objects[s])
+	c.deleteKubeObjects(s, KubeResource{Singular: "y"})
+	if !reflect.DeepEqual(c.serverObjects(s), []KubeObject{o1}) {
+		t.Errorf("Cache should contain only %+v, but it contains %+v", o1, c.serverObjects(s))
+	}
+}
+
+func TestDeleteKubeObject(t *testing.T) {
+	c := NewMrrCache()
+	s := KubeServer{"s"}
+	pod := KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "web", Namespace: "ns1"}}
+	service := KubeObject{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "web", Namespace: "ns1"}}
+	certificate := KubeObject{TypeMeta: TypeMeta{Kind: "certificate", Group: "cert-manager.io"}, ObjectMeta: ObjectMeta{Name: "web", Namespace: "ns1"}}
+	otherCertificate := KubeObject{TypeMeta: TypeMeta{Kind: "certificate", Group: "certificates.k8s.io"}, ObjectMeta: ObjectMeta{Name: "web", Namespace: "ns1"}}
+	for _, o := range []KubeObject{pod, service, certificate, otherCertificate} {
+		c.updateKubeObject(s, o)
+	}
+
+	c.deleteKubeObject(s, pod)
+	c.deleteKubeObject(s, certificate)
+	assert.Equal(t, []KubeObject{otherCertificate, service}, c.serverObjects(s), "must delete only objects of the same group and kind")
+
+	_, ok := c.lookupKubeObject(newObjectKey(s, pod))
+	assert.False(t, ok)
+	o, ok := c.lookupKubeObject(ObjectKey{Server: s, Kind: "service", Namespace: "ns1", Name: "web"})
+	assert.True(t, ok)
+	assert.Equal(t, service, o)
+}
+
+//cacheEvent is a random watch event. The fields are indexes in small sets of values,
+//so that random events often change the same objects
+type cacheEvent struct {
+	Type      uint8
+	Server    uint8
+	Resource  uint8
+	Namespace uint8
+	Name      uint8
+	Version   uint8
+}
+
+func (e cacheEvent) key() ObjectKey {
+	resources := []resourceKey{{"", "pod"}, {"", "service"}, {"cert-manager.io", "certificate"}, {"certificates.k8s.io", "certificate"}}
+	r := resources[int(e.Resource)%len(resources)]
+	return ObjectKey{
+		Server:    KubeServer{[]string{"s1", "s2"}[e.Server%2]},
+		Group:     r.Group,
+		Kind:      r.Kind,
+		Namespace: []string{"", "ns1", "ns2"}[e.Namespace%3],
+		Name:      []string{"a", "b", "c"}[e.Name%3],
+	}
+}
+
+func (e cacheEvent) object() KubeObject {
+	k := e.key()
+	return KubeObject{
+		TypeMeta:   TypeMeta{Kind: k.Kind, Group: k.Group},
+		ObjectMeta: ObjectMeta{Name: k.Name, Namespace: k.Namespace, ResourceVersion: strconv.Itoa(int(e.Version))},
+	}
+}
+
+//TestCacheConvergesWithEvents applies random sequences of events to the cache and to a map,
+//and checks that both end up with the same objects
+func TestCacheConvergesWithEvents(t *testing.T) {
+	property := func(events []cacheEvent) bool {
+		c := NewMrrCache()
+		model := make(map[ObjectKey]KubeObject)
+		for _, e := range events {
+			o := e.object()
+			typ := []EventType{Added, Modified, Deleted}[e.Type%3]
+			applyEvent(c, e.key().Server, &ObjectEvent{typ, &o})
+			if typ == Deleted {
+				delete(model, e.key())
+			} else {
+				model[e.key()] = o
+			}
+		}
+
+		actual := make(map[ObjectKey]KubeObject)
+		for _, s := range []KubeServer{{"s1"}, {"s2"}} {
+			for _, o := range c.serverObjects(s) {
+				actual[newObjectKey(s, o)] = o
+			}
+		}
+		if !reflect.DeepEqual(model, actual) {
+			return false
+		}
+
+		for _, e := range events {
+			o, ok := c.lookupKubeObject(e.key())
+			expected, exists := model[e.key()]
+			if ok != exists || !reflect.DeepEqual(o, expected) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
 	}
 }
 
@@ -198,16 +303,280 @@ func TestUpdateKubeObject(t *testing.T) {
 	s := KubeServer{"s"}
 
 	expected := []KubeObject{
-		{TypeMeta: TypeMeta{"x"}, ObjectMeta: ObjectMeta{Name: "x1"}},
-		{TypeMeta: TypeMeta{"y"}, ObjectMeta: ObjectMeta{Name: "x1"}},
-		{TypeMeta: TypeMeta{"y"}, ObjectMeta: ObjectMeta{Name: "x1", Namespace: "ns2"}},
+		{TypeMeta: TypeMeta{Kind: "x"}, ObjectMeta: ObjectMeta{Name: "x1"}},
+		{TypeMeta: TypeMeta{Kind: "y"}, ObjectMeta: ObjectMeta{Name: "x1"}},
+		{TypeMeta: TypeMeta{Kind: "y"}, ObjectMeta: ObjectMeta{Name: "x1", Namespace: "ns2"}},
 	}
 
 	for i := range expected {
 		c.updateKubeObject(s, expected[i])
 	}
 
-	if !reflect.DeepEqual(c.objects[s], expected) {
-		t.Errorf("Cache should all %d obejcts, but it contains %+v", len(expected), c.objects[s])
+	c.updateKubeObject(s, expected[0])
+
+	if !reflect.DeepEqual(c.serverObjects(s), expected) {
+		t.Errorf("Cache should all %d obejcts, but it contains %+v", len(expected), c.serverObjects(s))
+	}
+}
+
+func TestCacheKeepsSpecsOfPods(t *testing.T) {
+	c := NewMrrCache()
+	s := KubeServer{"s"}
+	spec := &PodSpec{Containers: []Container{{Name: "c1"}}}
+
+	c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1"}, Spec: spec})
+	c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "foo", Group: "example.com"}, ObjectMeta: ObjectMeta{Name: "f1"}, Spec: spec, Status: &PodStatus{Phase: "Running"}})
+
+	pod, _ := c.lookupKubeObject(ObjectKey{Server: s, Kind: "pod", Name: "p1"})
+	assert.Equal(t, []string{"c1"}, pod.containerNames())
+	foo, _ := c.lookupKubeObject(ObjectKey{Server: s, Group: "example.com", Kind: "foo", Name: "f1"})
+	assert.Nil(t, foo.Spec, "must not keep specs of other resources")
+	assert.Nil(t, foo.Status, "must not keep statuses of other resources")
+}
+
+func TestObjectsOfDiscoveredResource(t *testing.T) {
+	c := NewMrrCache()
+	s := KubeServer{"s"}
+	c.setResources(s, []KubeResource{
+		{Version: "v1", Name: "persistentvolumes", Singular: "persistentvolume", ShortNames: []string{"pv"}},
+		{Group: "apps", Version: "v1", Name: "statefulsets", Singular: "statefulset", ShortNames: []string{"sts"}, Namespaced: true},
+	})
+	pv := KubeObject{TypeMeta: TypeMeta{Kind: "persistentvolume"}, ObjectMeta: ObjectMeta{Name: "pv1"}}
+	sts := KubeObject{TypeMeta: TypeMeta{Kind: "statefulset", Group: "apps"}, ObjectMeta: ObjectMeta{Name: "sts1", Namespace: "ns1"}}
+	c.updateKubeObject(s, pv)
+	c.updateKubeObject(s, sts)
+
+	tests := []struct {
+		filter   MrrFilter
+		expected []KubeObject
+	}{
+		{
+			filter:   MrrFilter{Server: "s", Namespace: "ns1", Kind: "pv"},
+			expected: []KubeObject{pv},
+		},
+		{
+			filter:   MrrFilter{Server: "s", Namespace: "ns1", Kind: "statefulsets.apps"},
+			expected: []KubeObject{sts},
+		},
+		{
+			filter:   MrrFilter{Server: "s", Namespace: "ns2", Kind: "sts"},
+			expected: []KubeObject{},
+		},
+	}
+
+	for i, test := range tests {
+		var actual []KubeObject
+		err := c.Objects(context.Background(), &test.filter, allowAll{}, &actual)
+		if assert.NoError(t, err, "test %d", i) {
+			assert.Equal(t, test.expected, actual, "test %d", i)
+		}
+	}
+
+	var actual []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Server: "s", Namespace: "", Kind: "pod"}, allowAll{}, &actual)
+	assert.Error(t, err, "pods were not discovered on the server")
+}
+
+func TestObjectsWithWord(t *testing.T) {
+	c := NewMrrCache()
+	for _, s := range []string{"s1", "s2"} {
+		for _, name := range []string{"api-0", "web-0", "web-1", "worker-0", "db-web"} {
+			c.updateKubeObject(KubeServer{s}, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: name, Namespace: "ns"}})
+		}
+	}
+
+	tests := []struct {
+		filter   MrrFilter
+		expected []string
+	}{
+		{MrrFilter{Kind: "pod", Server: "s1", Word: "web"}, []string{"web-0", "web-1"}},
+		{MrrFilter{Kind: "pod", Server: "s1", Word: "web", Match: MatchPrefix}, []string{"web-0", "web-1"}},
+		{MrrFilter{Kind: "pod", Server: "s1", Word: "web", Match: MatchSubstring}, []string{"db-web", "web-0", "web-1"}},
+		{MrrFilter{Kind: "pod", Server: "s1", Word: "wr0", Match: MatchFuzzy}, []string{"worker-0"}},
+		{MrrFilter{Kind: "pod", Server: "s1", Word: "w0", Match: MatchFuzzy}, []string{"web-0", "worker-0"}},
+		{MrrFilter{Kind: "pod", Word: "web", Limit: 3}, []string{"web-0", "web-1", "web-0"}},
+		{MrrFilter{Kind: "pod", Namespace: "ns", Limit: 1}, []string{"api-0"}},
+		{MrrFilter{Kind: "pod", Word: "x"}, []string{}},
+	}
+
+	for i, test := range tests {
+		var actual []KubeObject
+		err := c.Objects(context.Background(), &test.filter, allowAll{}, &actual)
+		if assert.NoError(t, err, "test %d", i) {
+			assert.Equal(t, test.expected, names(actual), "test %d", i)
+		}
+	}
+
+	var actual []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Kind: "pod", Match: "regex"}, allowAll{}, &actual)
+	assert.Error(t, err, "must reject unknown match mode")
+}
+
+func TestObjectsWithLabelSelector(t *testing.T) {
+	c := NewMrrCache()
+	s := KubeServer{"s1"}
+	for name, labels := range map[string]map[string]string{
+		"web-0": {"app": "web", "tier": "frontend"},
+		"web-1": {"app": "web"},
+		"db-0":  {"app": "db", "tier": "backend"},
+		"misc":  nil,
+	} {
+		c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: name, Namespace: "ns", Labels: labels}})
 	}
+
+	tests := []struct {
+		filter   MrrFilter
+		expected []string
+	}{
+		{MrrFilter{Kind: "pod", LabelSelector: "app=web"}, []string{"web-0", "web-1"}},
+		{MrrFilter{Kind: "pod", LabelSelector: "app=web", Word: "web-1"}, []string{"web-1"}},
+		{MrrFilter{Kind: "pod", LabelSelector: "tier", Limit: 1}, []string{"db-0"}},
+		{MrrFilter{Kind: "pod", LabelSelector: "app!=web"}, []string{"db-0", "misc"}},
+		{MrrFilter{Kind: "pod", LabelSelector: "tier in (frontend,backend)", Match: MatchSubstring, Word: "0"}, []string{"db-0", "web-0"}},
+	}
+
+	for i, test := range tests {
+		var actual []KubeObject
+		err := c.Objects(context.Background(), &test.filter, allowAll{}, &actual)
+		if assert.NoError(t, err, "test %d", i) {
+			assert.Equal(t, test.expected, names(actual), "test %d", i)
+		}
+	}
+
+	var actual []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Kind: "pod", LabelSelector: "app=web app"}, allowAll{}, &actual)
+	assert.Error(t, err, "must reject invalid selector")
+}
+
+func TestObjectsWithFieldSelector(t *testing.T) {
+	c := NewMrrCache()
+	s := KubeServer{"s1"}
+	for name, node := range map[string]string{"web-0": "node-1", "web-1": "node-3", "db-0": "node-3"} {
+		phase := "Running"
+		if name == "db-0" {
+			phase = "Pending"
+		}
+		c.updateKubeObject(s, KubeObject{
+			TypeMeta:   TypeMeta{Kind: "pod"},
+			ObjectMeta: ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{"app": name[:len(name)-2]}},
+			Spec:       &PodSpec{NodeName: node},
+			Status:     &PodStatus{Phase: phase},
+		})
+	}
+
+	tests := []struct {
+		filter   MrrFilter
+		expected []string
+	}{
+		{MrrFilter{Kind: "pod", FieldSelector: "status.phase=Running"}, []string{"web-0", "web-1"}},
+		{MrrFilter{Kind: "pod", FieldSelector: "status.phase=Running,spec.nodeName=node-3"}, []string{"web-1"}},
+		{MrrFilter{Kind: "pod", FieldSelector: "spec.nodeName=node-3", LabelSelector: "app!=web"}, []string{"db-0"}},
+		{MrrFilter{Kind: "pod", FieldSelector: "metadata.name!=web-0"}, []string{"db-0", "web-1"}},
+	}
+
+	for i, test := range tests {
+		var actual []KubeObject
+		err := c.Objects(context.Background(), &test.filter, allowAll{}, &actual)
+		if assert.NoError(t, err, "test %d", i) {
+			assert.Equal(t, test.expected, names(actual), "test %d", i)
+		}
+	}
+
+	var actual []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Kind: "pod", FieldSelector: "status.podIP=1.2.3.4"}, allowAll{}, &actual)
+	assert.Error(t, err, "must reject unsupported field")
+
+	c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "service"}, ObjectMeta: ObjectMeta{Name: "web", Namespace: "ns"}})
+	err = c.Objects(context.Background(), &MrrFilter{Kind: "service", FieldSelector: "status.phase!=Running"}, allowAll{}, &actual)
+	if assert.Error(t, err, "must reject fields of pods for services") {
+		assert.Contains(t, err.Error(), "not supported for services")
+	}
+	err = c.Objects(context.Background(), &MrrFilter{Kind: "service", FieldSelector: "metadata.name=web"}, allowAll{}, &actual)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"web"}, names(actual))
+	}
+}
+
+func TestObjectsRunning(t *testing.T) {
+	c := NewMrrCache()
+	s := KubeServer{"s1"}
+	deleted := time.Now()
+	ready := []PodCondition{{Type: "Initialized", Status: "True"}, {Type: "Ready", Status: "True"}}
+	for _, o := range []KubeObject{
+		{ObjectMeta: ObjectMeta{Name: "running"}, Status: &PodStatus{Phase: "Running", Conditions: ready}},
+		{ObjectMeta: ObjectMeta{Name: "not-ready"}, Status: &PodStatus{Phase: "Running"}},
+		{ObjectMeta: ObjectMeta{Name: "terminating", DeletionTimestamp: &deleted}, Status: &PodStatus{Phase: "Running", Conditions: ready}},
+		{ObjectMeta: ObjectMeta{Name: "succeeded"}, Status: &PodStatus{Phase: "Succeeded"}},
+		{ObjectMeta: ObjectMeta{Name: "evicted"}, Status: &PodStatus{Phase: "Failed"}},
+		{ObjectMeta: ObjectMeta{Name: "unknown"}},
+	} {
+		o.Kind = "pod"
+		o.Namespace = "ns"
+		c.updateKubeObject(s, o)
+	}
+
+	var actual []KubeObject
+	err := c.Objects(context.Background(), &MrrFilter{Kind: "pod", Running: true}, allowAll{}, &actual)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"not-ready", "running"}, names(actual))
+	}
+
+	err = c.Objects(context.Background(), &MrrFilter{Kind: "pod"}, allowAll{}, &actual)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 6, len(actual), "terminated pods must be returned without the filter")
+	}
+
+	pod, _ := c.lookupKubeObject(ObjectKey{Server: s, Namespace: "ns", Kind: "pod", Name: "running"})
+	assert.Equal(t, []PodCondition{{Type: "Ready", Status: "True"}}, pod.Status.Conditions, "must keep only the Ready condition")
+	assert.True(t, pod.isReady())
+}
+
+func TestLabels(t *testing.T) {
+	c := NewMrrCache()
+	s := KubeServer{"s1"}
+	c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1", Namespace: "ns1", Labels: map[string]string{"app": "web", "tier": "frontend"}}})
+	c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p2", Namespace: "ns1", Labels: map[string]string{"app": "worker"}}})
+	c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p3", Namespace: "ns2", Labels: map[string]string{"app": "db", "team": "a"}}})
+
+	tests := []struct {
+		filter   MrrFilter
+		key      string
+		expected []string
+	}{
+		{MrrFilter{Kind: "pod"}, "", []string{"app", "team", "tier"}},
+		{MrrFilter{Kind: "pod", Namespace: "ns1"}, "", []string{"app", "tier"}},
+		{MrrFilter{Kind: "pod", Word: "t"}, "", []string{"team", "tier"}},
+		{MrrFilter{Kind: "pod"}, "app", []string{"db", "web", "worker"}},
+		{MrrFilter{Kind: "pod", Word: "w"}, "app", []string{"web", "worker"}},
+		{MrrFilter{Kind: "pod", Word: "w", Limit: 1}, "app", []string{"web"}},
+		{MrrFilter{Kind: "pod", LabelSelector: "tier=frontend"}, "app", []string{"web"}},
+		{MrrFilter{Kind: "pod"}, "unknown", []string{}},
+	}
+
+	for i, test := range tests {
+		var actual []string
+		err := c.Labels(context.Background(), &test.filter, test.key, allowAll{}, &actual)
+		if assert.NoError(t, err, "test %d", i) {
+			assert.Equal(t, test.expected, actual, "test %d", i)
+		}
+	}
+}
+
+func TestKeepAnnotations(t *testing.T) {
+	c := NewMrrCache()
+	s := KubeServer{"s1"}
+	annotations := map[string]string{
+		"owner":                         "team-a",
+		"example.com/ticket":            "T-1",
+		"kubectl.kubernetes.io/restart": "now",
+	}
+
+	c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1", Annotations: annotations}})
+	o, _ := c.lookupKubeObject(ObjectKey{Server: s, Kind: "pod", Name: "p1"})
+	assert.Nil(t, o.Annotations, "must not keep annotations by default")
+
+	c.keepAnnotations([]string{"owner", "example.com/*"})
+	c.updateKubeObject(s, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1", Annotations: annotations}})
+	o, _ = c.lookupKubeObject(ObjectKey{Server: s, Kind: "pod", Name: "p1"})
+	assert.Equal(t, map[string]string{"owner": "team-a", "example.com/ticket": "T-1"}, o.Annotations)
 }
@@ -0,0 +1,252 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//Operators of requirements of label selectors
+const (
+	selectorEquals       = "="
+	selectorNotEquals    = "!="
+	selectorIn           = "in"
+	selectorNotIn        = "notin"
+	selectorExists       = "exists"
+	selectorDoesNotExist = "!"
+)
+
+//labelRequirement is one comma-separated part of a label selector
+type labelRequirement struct {
+	key      string
+	operator string
+	values   []string
+}
+
+//labelSelector selects objects whose labels meet all requirements. Empty selector selects all objects
+type labelSelector []labelRequirement
+
+var (
+	labelKeyRegex   = regexp.MustCompile(`^([a-zA-Z0-9]([-a-zA-Z0-9.]*[a-zA-Z0-9])?/)?[a-zA-Z0-9]([-a-zA-Z0-9_.]*[a-zA-Z0-9])?$`)
+	labelValueRegex = regexp.MustCompile(`^([a-zA-Z0-9]([-a-zA-Z0-9_.]*[a-zA-Z0-9])?)?$`)
+	setRequirement  = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+//parseLabelSelector parses selector in the syntax of kubectl --selector, e.g. "app=web,tier!=db,env in (dev,qa),!canary"
+func parseLabelSelector(s string) (labelSelector, error) {
+	res := labelSelector{}
+	for _, term := range splitSelector(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		r, err := parseLabelRequirement(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector %q: %v", s, err)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+//splitSelector splits the selector by commas outside of parentheses
+func splitSelector(s string) []string {
+	res := []string{}
+	depth := 0
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(res, s[start:])
+}
+
+func parseLabelRequirement(term string) (labelRequirement, error) {
+	var r labelRequirement
+	switch {
+	case strings.HasPrefix(term, "!"):
+		r = labelRequirement{key: strings.TrimSpace(term[1:]), operator: selectorDoesNotExist}
+	case setRequirement.MatchString(term):
+		m := setRequirement.FindStringSubmatch(term)
+		r = labelRequirement{key: m[1], operator: m[2]}
+		for _, v := range strings.Split(m[3], ",") {
+			r.values = append(r.values, strings.TrimSpace(v))
+		}
+	case strings.Contains(term, "!="):
+		parts := strings.SplitN(term, "!=", 2)
+		r = labelRequirement{key: strings.TrimSpace(parts[0]), operator: selectorNotEquals, values: []string{strings.TrimSpace(parts[1])}}
+	case strings.Contains(term, "="):
+		parts := strings.SplitN(term, "=", 2)
+		value := strings.TrimPrefix(parts[1], "=")
+		r = labelRequirement{key: strings.TrimSpace(parts[0]), operator: selectorEquals, values: []string{strings.TrimSpace(value)}}
+	default:
+		r = labelRequirement{key: term, operator: selectorExists}
+	}
+
+	if !labelKeyRegex.MatchString(r.key) {
+		return r, fmt.Errorf("invalid label key %q", r.key)
+	}
+	for _, v := range r.values {
+		if !labelValueRegex.MatchString(v) {
+			return r, fmt.Errorf("invalid label value %q", v)
+		}
+	}
+	return r, nil
+}
+
+//matches tells whether the labels meet all requirements of the selector
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+//matches tells whether the labels meet the requirement. As in Kubernetes,
+//labels without the key meet requirements with operators != and notin
+func (r labelRequirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	switch r.operator {
+	case selectorExists:
+		return ok
+	case selectorDoesNotExist:
+		return !ok
+	case selectorEquals, selectorIn:
+		return ok && containsString(r.values, v)
+	case selectorNotEquals, selectorNotIn:
+		return !ok || !containsString(r.values, v)
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//fieldRequirement is one comma-separated part of a field selector
+type fieldRequirement struct {
+	field    string
+	operator string
+	value    string
+}
+
+//fieldSelector selects objects whose fields meet all requirements. Empty selector selects all objects
+type fieldSelector []fieldRequirement
+
+//selectableFields are the fields matched by field selectors. Fields of spec and status are selectable only for pods
+var selectableFields = []string{"metadata.name", "metadata.namespace", "spec.nodeName", "status.phase"}
+
+//metadataFields are the fields selectable for objects of all resources
+var metadataFields = []string{"metadata.name", "metadata.namespace"}
+
+//parseFieldSelector parses selector in the syntax of kubectl --field-selector, e.g. "status.phase=Running,spec.nodeName!=node-3"
+func parseFieldSelector(s string) (fieldSelector, error) {
+	res := fieldSelector{}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var r fieldRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			r = fieldRequirement{strings.TrimSpace(parts[0]), selectorNotEquals, strings.TrimSpace(parts[1])}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			r = fieldRequirement{strings.TrimSpace(parts[0]), selectorEquals, strings.TrimSpace(strings.TrimPrefix(parts[1], "="))}
+		default:
+			return nil, fmt.Errorf("invalid field selector %q: %q is not in the form field=value", s, term)
+		}
+		if !containsString(selectableFields, r.field) {
+			return nil, fmt.Errorf("invalid field selector %q: field %q is not supported, expected one of %s", s, r.field, strings.Join(selectableFields, ", "))
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+//matches tells whether the fields of the object meet all requirements of the selector
+func (s fieldSelector) matches(o KubeObject) bool {
+	for _, r := range s {
+		equal := objectField(o, r.field) == r.value
+		if equal != (r.operator == selectorEquals) {
+			return false
+		}
+	}
+	return true
+}
+
+//check returns an error if the selector has fields which objects of the resource do not have.
+//As in Kubernetes, only pods are selected by fields of spec and status
+func (s fieldSelector) check(r KubeResource) error {
+	if r.Group == "" && r.Singular == "pod" {
+		return nil
+	}
+	for _, req := range s {
+		if !containsString(metadataFields, req.field) {
+			return fmt.Errorf("field %q is not supported for %s, expected one of %s", req.field, r.Name, strings.Join(metadataFields, ", "))
+		}
+	}
+	return nil
+}
+
+//objectField returns the value of one of selectableFields of the object
+func objectField(o KubeObject, field string) string {
+	switch field {
+	case "metadata.name":
+		return o.Name
+	case "metadata.namespace":
+		return o.Namespace
+	case "spec.nodeName":
+		if o.Spec != nil {
+			return o.Spec.NodeName
+		}
+	case "status.phase":
+		if o.Status != nil {
+			return o.Status.Phase
+		}
+	}
+	return ""
+}
+
+//objectSelector selects objects by their labels and fields, and optionally only running pods
+type objectSelector struct {
+	labels  labelSelector
+	fields  fieldSelector
+	running bool
+}
+
+//newObjectSelector parses the label and field selectors of the filter
+func newObjectSelector(f *MrrFilter) (objectSelector, error) {
+	s := objectSelector{running: f.Running}
+	var err error
+	if s.labels, err = parseLabelSelector(f.LabelSelector); err != nil {
+		return s, err
+	}
+	if s.fields, err = parseFieldSelector(f.FieldSelector); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+func (s objectSelector) matches(o KubeObject) bool {
+	return s.labels.matches(o.Labels) && s.fields.matches(o) && (!s.running || o.isRunning())
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		expected labelSelector
+	}{
+		{"", labelSelector{}},
+		{"app=web", labelSelector{{"app", selectorEquals, []string{"web"}}}},
+		{"app==web", labelSelector{{"app", selectorEquals, []string{"web"}}}},
+		{"app = web , tier!=db", labelSelector{
+			{"app", selectorEquals, []string{"web"}},
+			{"tier", selectorNotEquals, []string{"db"}},
+		}},
+		{"env in (dev, qa),track notin (canary)", labelSelector{
+			{"env", selectorIn, []string{"dev", "qa"}},
+			{"track", selectorNotIn, []string{"canary"}},
+		}},
+		{"example.com/owner,!canary", labelSelector{
+			{"example.com/owner", selectorExists, nil},
+			{"canary", selectorDoesNotExist, nil},
+		}},
+		{"app=", labelSelector{{"app", selectorEquals, []string{""}}}},
+	}
+
+	for _, test := range tests {
+		actual, err := parseLabelSelector(test.selector)
+		if assert.NoError(t, err, test.selector) {
+			assert.Equal(t, test.expected, actual, test.selector)
+		}
+	}
+
+	for _, invalid := range []string{"=web", "app=web app", "-app", "app in (a b)", "app>1"} {
+		_, err := parseLabelSelector(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"app": "web", "env": "dev"}
+	tests := []struct {
+		selector string
+		expected bool
+	}{
+		{"", true},
+		{"app=web", true},
+		{"app=db", false},
+		{"app=web,env=prod", false},
+		{"app!=db", true},
+		{"tier!=db", true},
+		{"env in (dev,qa)", true},
+		{"env notin (dev,qa)", false},
+		{"tier notin (db)", true},
+		{"tier in (db)", false},
+		{"app", true},
+		{"!app", false},
+		{"!tier", true},
+	}
+
+	for _, test := range tests {
+		s, err := parseLabelSelector(test.selector)
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.expected, s.matches(labels), test.selector)
+		}
+	}
+}
+
+func TestParseFieldSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		expected fieldSelector
+	}{
+		{"", fieldSelector{}},
+		{"status.phase=Running", fieldSelector{{"status.phase", selectorEquals, "Running"}}},
+		{"status.phase==Running", fieldSelector{{"status.phase", selectorEquals, "Running"}}},
+		{"spec.nodeName = node-3 , metadata.name!=web", fieldSelector{
+			{"spec.nodeName", selectorEquals, "node-3"},
+			{"metadata.name", selectorNotEquals, "web"},
+		}},
+		{"spec.nodeName=", fieldSelector{{"spec.nodeName", selectorEquals, ""}}},
+	}
+
+	for _, test := range tests {
+		actual, err := parseFieldSelector(test.selector)
+		if assert.NoError(t, err, test.selector) {
+			assert.Equal(t, test.expected, actual, test.selector)
+		}
+	}
+
+	for _, invalid := range []string{"status.phase", "spec.hostIP=1.2.3.4", "=Running"} {
+		_, err := parseFieldSelector(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestFieldSelectorMatches(t *testing.T) {
+	pod := KubeObject{
+		ObjectMeta: ObjectMeta{Name: "web-0", Namespace: "prod"},
+		Spec:       &PodSpec{NodeName: "node-3"},
+		Status:     &PodStatus{Phase: "Running"},
+	}
+	service := KubeObject{ObjectMeta: ObjectMeta{Name: "web", Namespace: "prod"}}
+	tests := []struct {
+		selector string
+		object   KubeObject
+		expected bool
+	}{
+		{"", pod, true},
+		{"status.phase=Running", pod, true},
+		{"status.phase=Running,spec.nodeName=node-3", pod, true},
+		{"status.phase=Running,spec.nodeName=node-4", pod, false},
+		{"status.phase!=Pending", pod, true},
+		{"metadata.namespace=prod,metadata.name=web-0", pod, true},
+		{"metadata.name!=web-0", pod, false},
+		{"status.phase=Running", service, false},
+		{"spec.nodeName!=node-3", service, true},
+		{"metadata.name=web", service, true},
+	}
+
+	for _, test := range tests {
+		s, err := parseFieldSelector(test.selector)
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.expected, s.matches(test.object), "%s of %s", test.selector, test.object.Name)
+		}
+	}
+}
+
+func TestFieldSelectorCheck(t *testing.T) {
+	pod, _ := defaultRegistry.Lookup("pod")
+	service, _ := defaultRegistry.Lookup("service")
+	knative := KubeResource{Group: "serving.knative.dev", Name: "pods", Singular: "pod"}
+	tests := []struct {
+		selector string
+		r        KubeResource
+		valid    bool
+	}{
+		{"status.phase=Running,spec.nodeName=node-3", pod, true},
+		{"metadata.name=web", service, true},
+		{"status.phase!=Running", service, false},
+		{"metadata.name=web,spec.nodeName=node-3", service, false},
+		{"status.phase=Running", knative, false},
+	}
+
+	for _, test := range tests {
+		s, err := parseFieldSelector(test.selector)
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.valid, s.check(test.r) == nil, "%s of %s", test.selector, hitKey(test.r))
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//snapshotVersion is the version of the format of cache files. It must be increased
+//whenever the format changes, files of other versions are ignored
+const snapshotVersion = 1
+
+//cacheSnapshot is the content of the cache file. Objects are stored with the resource version
+//of their resource, so that watches are resumed from it after restart
+type cacheSnapshot struct {
+	Version int              `json:"version"`
+	Created time.Time        `json:"created"`
+	Servers []serverSnapshot `json:"servers"`
+}
+
+type serverSnapshot struct {
+	Server    string             `json:"server"`
+	Resources []resourceSnapshot `json:"resources"`
+}
+
+type resourceSnapshot struct {
+	Group           string       `json:"group,omitempty"`
+	Kind            string       `json:"kind"`
+	ResourceVersion string       `json:"resourceVersion,omitempty"`
+	Objects         []KubeObject `json:"objects"`
+}
+
+//resourceState is what the cache knows about objects of a resource beside the objects
+type resourceState struct {
+	//version is the last resource version seen by the mirror
+	version string
+	//stale is true while the objects are restored from a cache file and not confirmed by the server
+	stale bool
+}
+
+//snapshot returns all objects of the cache with their resource versions
+func (c *MrrCache) snapshot() *cacheSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := &cacheSnapshot{Version: snapshotVersion, Created: time.Now().UTC(), Servers: []serverSnapshot{}}
+	servers := KubeServers{}
+	for server := range c.objects {
+		servers = append(servers, server)
+	}
+	sort.Sort(servers)
+
+	for _, server := range servers {
+		s := serverSnapshot{Server: server.URL, Resources: []resourceSnapshot{}}
+		index := c.objects[server]
+		keys := make([]resourceKey, 0, len(index))
+		for key := range index {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].Kind != keys[j].Kind {
+				return keys[i].Kind < keys[j].Kind
+			}
+			return keys[i].Group < keys[j].Group
+		})
+
+		for _, key := range keys {
+			r := resourceSnapshot{
+				Group:           key.Group,
+				Kind:            key.Kind,
+				ResourceVersion: c.states[server][key].version,
+				Objects:         []KubeObject{},
+			}
+			for _, i := range index[key].namespaces() {
+				r.Objects = append(r.Objects, i.withPrefix("", 0)...)
+			}
+			s.Resources = append(s.Resources, r)
+		}
+		res.Servers = append(res.Servers, s)
+	}
+	return res
+}
+
+//loadSnapshot keeps objects of the snapshot until their resources are restored by the mirror
+func (c *MrrCache) loadSnapshot(s *cacheSnapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.restored = make(map[KubeServer]map[resourceKey]resourceSnapshot)
+	for _, server := range s.Servers {
+		rs := make(map[resourceKey]resourceSnapshot)
+		for _, r := range server.Resources {
+			rs[newResourceKey(r.Group, r.Kind)] = r
+		}
+		c.restored[KubeServer{server.Server}] = rs
+	}
+}
+
+//restore puts the objects of the resource from the loaded snapshot into the cache, and marks them stale.
+//It returns the resource version to resume the watch from, or empty string if the objects must be listed
+func (c *MrrCache) restore(server KubeServer, r KubeResource) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newResourceKey(r.Group, r.Singular)
+	snapshot, ok := c.restored[server][key]
+	if !ok {
+		return ""
+	}
+	delete(c.restored[server], key)
+	if _, ok := c.objects[server][key]; ok {
+		return ""
+	}
+
+	for _, o := range snapshot.Objects {
+		o.Group = r.Group
+		o.Kind = r.Singular
+		c.put(newObjectKey(server, o), o)
+	}
+	c.setState(server, key, resourceState{version: snapshot.ResourceVersion, stale: true})
+	log.
+		WithField("server", server.URL).
+		WithField("kind", r.Singular).
+		WithField("group", r.Group).
+		WithField("resourceVersion", snapshot.ResourceVersion).
+		Infof("restored %d objects from cache file", len(snapshot.Objects))
+	return snapshot.ResourceVersion
+}
+
+//confirm remembers the last seen resource version of the resource, and marks its objects as not stale
+func (c *MrrCache) confirm(server KubeServer, r KubeResource, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newResourceKey(r.Group, r.Singular)
+	if version == "" {
+		version = c.states[server][key].version
+	}
+	c.setState(server, key, resourceState{version: version})
+}
+
+func (c *MrrCache) setState(server KubeServer, key resourceKey, state resourceState) {
+	states, ok := c.states[server]
+	if !ok {
+		states = make(map[resourceKey]resourceState)
+		c.states[server] = states
+	}
+	states[key] = state
+}
+
+//readSnapshot reads the cache file. It returns nil if the file does not exist
+func readSnapshot(file string) (*cacheSnapshot, error) {
+	raw, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return nil, fmt.Errorf("could not parse cache file %s: %v", file, err)
+	}
+	if version.Version != snapshotVersion {
+		return nil, fmt.Errorf("cache file %s has version %d, expected %d", file, version.Version, snapshotVersion)
+	}
+
+	var s cacheSnapshot
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("could not parse cache file %s: %v", file, err)
+	}
+	return &s, nil
+}
+
+//writeSnapshot writes the cache file. The file is replaced atomically,
+//so that a crash while writing does not leave a broken file
+func writeSnapshot(file string, s *cacheSnapshot) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+//loopSaveSnapshots writes the cache to the file after each interval until the context is canceled.
+//The cache is written once more when the context is canceled, and the returned channel is closed after that
+func loopSaveSnapshots(ctx context.Context, c *MrrCache, file string, interval time.Duration) <-chan struct{} {
+	l := log.WithField("file", file)
+	write := func() {
+		if err := writeSnapshot(file, c.snapshot()); err != nil {
+			l.WithField("error", err).Warn("could not write cache file")
+			return
+		}
+		l.Debug("written cache file")
+	}
+
+	done := make(chan struct{})
+	save := func() {
+		defer close(done)
+		for !isStopped(ctx) {
+			sleep(ctx, interval)
+			write()
+		}
+	}
+	go save()
+	return done
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := KubeServer{"http://a.com"}
+	pods := KubeResource{Singular: "pod"}
+	certs := KubeResource{Group: "cert-manager.io", Singular: "certificate"}
+	pod := KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1", Namespace: "ns1", ResourceVersion: "5"}}
+	cert := KubeObject{TypeMeta: TypeMeta{Kind: "certificate", Group: "cert-manager.io"}, ObjectMeta: ObjectMeta{Name: "c1", Namespace: "ns1"}}
+
+	c := NewMrrCache()
+	c.replaceKubeObjects(s, pods, []KubeObject{pod})
+	c.confirm(s, pods, "10")
+	c.updateKubeObject(s, cert)
+
+	file := path.Join(dir, "kubemrr", "cache.json")
+	if err := writeSnapshot(file, c.snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := readSnapshot(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMrrCache()
+	restored.loadSnapshot(snapshot)
+	assert.Empty(t, restored.serverObjects(s), "objects must not be served before their resource is restored")
+
+	assert.Equal(t, "10", restored.restore(s, pods))
+	assert.Equal(t, "", restored.restore(s, certs), "resource without version must be listed")
+	assert.Equal(t, []KubeObject{cert, pod}, restored.serverObjects(s))
+	assert.True(t, restored.states[s][pods.key()].stale)
+
+	restored.confirm(s, pods, "")
+	assert.False(t, restored.states[s][pods.key()].stale)
+	assert.Equal(t, "", restored.restore(s, pods), "resource must be restored only once")
+}
+
+func TestReadSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot, err := readSnapshot(path.Join(dir, "missing"))
+	assert.Nil(t, snapshot)
+	assert.Nil(t, err, "missing file is not an error")
+
+	tests := []struct {
+		content  string
+		complain string
+	}{
+		{`{"version":2,"servers":[]}`, "has version 2, expected 1"},
+		{`{"servers":[]}`, "has version 0, expected 1"},
+		{`not json`, "could not parse"},
+	}
+
+	for _, test := range tests {
+		file := path.Join(dir, "cache.json")
+		ioutil.WriteFile(file, []byte(test.content), 0600)
+		_, err := readSnapshot(file)
+		if assert.Error(t, err, test.content) {
+			assert.Contains(t, err.Error(), test.complain)
+		}
+	}
+}
+
+func TestLoopWatchObjectsResumesFromSnapshot(t *testing.T) {
+	kc := NewTestKubeClient()
+	r := KubeResource{Singular: "x"}
+	old := KubeObject{TypeMeta: TypeMeta{Kind: "x"}, ObjectMeta: ObjectMeta{Name: "old"}}
+
+	c := NewMrrCache()
+	c.loadSnapshot(&cacheSnapshot{
+		Version: snapshotVersion,
+		Servers: []serverSnapshot{{
+			Server:    kc.Server().URL,
+			Resources: []resourceSnapshot{{Kind: "x", ResourceVersion: "7", Objects: []KubeObject{old}}},
+		}},
+	})
+
+	loopWatchObjects(context.Background(), c, kc, r, testBackoff, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, []KubeObject{old}, c.serverObjects(kc.Server()), "restored objects must be served at once")
+	if status := c.status(); assert.Equal(t, 1, len(status.Resources)) {
+		assert.True(t, status.Resources[0].Stale, "restored objects must be reported as stale")
+	}
+	assert.Equal(t, 0, kc.getHits("x"), "must not list objects")
+	assert.Equal(t, []string{"7"}, kc.watchVersions("x"), "must resume watch from the stored version")
+}
+
+func TestLoopWatchObjectsConfirmsSnapshot(t *testing.T) {
+	kc := NewTestKubeClient()
+	r := KubeResource{Singular: "x"}
+	old := KubeObject{TypeMeta: TypeMeta{Kind: "x"}, ObjectMeta: ObjectMeta{Name: "old"}}
+	created := KubeObject{TypeMeta: TypeMeta{Kind: "x"}, ObjectMeta: ObjectMeta{Name: "new", ResourceVersion: "8"}}
+	kc.objectEvents = []*ObjectEvent{{Added, &created}}
+
+	c := NewMrrCache()
+	c.loadSnapshot(&cacheSnapshot{
+		Version: snapshotVersion,
+		Servers: []serverSnapshot{{
+			Server:    kc.Server().URL,
+			Resources: []resourceSnapshot{{Kind: "x", ResourceVersion: "7", Objects: []KubeObject{old}}},
+		}},
+	})
+
+	loopWatchObjects(context.Background(), c, kc, r, testBackoff, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, []KubeObject{created, old}, c.serverObjects(kc.Server()))
+	if status := c.status(); assert.Equal(t, 1, len(status.Resources)) {
+		assert.False(t, status.Resources[0].Stale, "event from the server must confirm restored objects")
+	}
+}
+
+func TestLoopSaveSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewMrrCache()
+	o := KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1"}}
+	c.updateKubeObject(KubeServer{"http://a.com"}, o)
+
+	file := path.Join(dir, "cache.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	loopSaveSnapshots(ctx, c, file, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	snapshot, err := readSnapshot(file)
+	if assert.NoError(t, err) && assert.NotNil(t, snapshot) {
+		assert.Equal(t, []serverSnapshot{{
+			Server:    "http://a.com",
+			Resources: []resourceSnapshot{{Kind: "pod", Objects: []KubeObject{o}}},
+		}}, snapshot.Servers)
+	}
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+//listen starts to listen on the given network, either tcp or unix
+func listen(network string, address string) (net.Listener, error) {
+	if network == "unix" {
+		return listenUnix(address)
+	}
+	return net.Listen(network, address)
+}
+
+//listenUnix listens on the Unix socket that only the current user can connect to.
+//The directory of the socket is created if it does not exist. A socket left by
+//a crashed mirror is replaced, but a socket of a running mirror is not
+func listenUnix(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(dir); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.
+			WithField("dir", dir).
+			WithField("mode", info.Mode().Perm().String()).
+			Warn("directory of the socket is accessible by other users")
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is used by another process", path)
+		}
+		log.WithField("socket", path).Info("removing socket left by previous mirror")
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	//the socket is created without permissions for others, otherwise they could connect
+	//before its mode is changed. Umask is of the process, but the mirror listens before
+	//it starts creating other files
+	mask := syscall.Umask(0077)
+	l, err := net.Listen("unix", path)
+	syscall.Umask(mask)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := path.Join(dir, "run", "kubemrr.sock")
+	mask := syscall.Umask(0022)
+	l, err := listenUnix(socket)
+	assert.Equal(t, 0022, syscall.Umask(mask), "umask of the process must be restored")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(socket)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "only the owner must be able to connect")
+	}
+	info, err = os.Stat(path.Join(dir, "run"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+
+	c := NewMrrCache()
+	c.updateKubeObject(KubeServer{"http://a.com"}, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1"}})
+	go http.Serve(l, NewMirrorHandler(c))
+
+	client, err := NewMrrClient(MirrorEndpoint{Network: "unix", Address: socket})
+	if assert.NoError(t, err) {
+		objects, err := client.Objects(MrrFilter{Kind: "pod"})
+		assert.NoError(t, err)
+		assert.Equal(t, []KubeObject{{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1"}}}, objects)
+	}
+
+	_, err = listenUnix(socket)
+	assert.Error(t, err, "must not take over socket of running mirror")
+	l.Close()
+}
+
+func TestListenUnixReplacesStaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := path.Join(dir, "kubemrr.sock")
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listenUnix(socket)
+	if assert.NoError(t, err) {
+		l.Close()
+	}
+
+	file := path.Join(dir, "file")
+	ioutil.WriteFile(file, []byte("data"), 0600)
+	_, err = listenUnix(file)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not a socket")
+	}
+}
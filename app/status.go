@@ -0,0 +1,473 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+//monitor keeps what the mirror is doing: the state of the loop of each resource,
+//and the statistics of requests to the API of the mirror
+type monitor struct {
+	started time.Time
+
+	loops     map[KubeServer]map[resourceKey]*loopStatus
+	requests  map[string]*histogram
+	responses map[apiResponseKey]uint64
+	mu        *sync.Mutex
+}
+
+//loopStatus is the state of the loop that mirrors a resource of a server
+type loopStatus struct {
+	started time.Time
+	//watchStarted is when the current watch connection was opened, zero while it is not open
+	watchStarted time.Time
+	//lastSync is when the server last confirmed the mirrored objects
+	lastSync      time.Time
+	lastError     string
+	lastErrorTime time.Time
+
+	watches uint64
+	events  map[EventType]uint64
+	lists   *histogram
+}
+
+type apiResponseKey struct {
+	path string
+	code int
+}
+
+func newMonitor() *monitor {
+	return &monitor{
+		started:   time.Now(),
+		loops:     make(map[KubeServer]map[resourceKey]*loopStatus),
+		requests:  make(map[string]*histogram),
+		responses: make(map[apiResponseKey]uint64),
+		mu:        &sync.Mutex{},
+	}
+}
+
+//loop returns the status of the loop, the caller must hold the lock
+func (m *monitor) loop(server KubeServer, r KubeResource) *loopStatus {
+	loops, ok := m.loops[server]
+	if !ok {
+		loops = make(map[resourceKey]*loopStatus)
+		m.loops[server] = loops
+	}
+	key := newResourceKey(r.Group, r.Singular)
+	ls, ok := loops[key]
+	if !ok {
+		ls = &loopStatus{started: time.Now(), events: make(map[EventType]uint64), lists: newHistogram()}
+		loops[key] = ls
+	}
+	return ls
+}
+
+func (m *monitor) loopStarted(server KubeServer, r KubeResource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loop(server, r)
+}
+
+func (m *monitor) loopStopped(server KubeServer, r KubeResource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.loops[server], newResourceKey(r.Group, r.Singular))
+	if len(m.loops[server]) == 0 {
+		delete(m.loops, server)
+	}
+}
+
+func (m *monitor) listed(server KubeServer, r KubeResource, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ls := m.loop(server, r)
+	ls.lists.observe(d.Seconds())
+	if err != nil {
+		ls.failed(err)
+	}
+}
+
+func (m *monitor) watchStarted(server KubeServer, r KubeResource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ls := m.loop(server, r)
+	ls.watches++
+	ls.watchStarted = time.Now()
+}
+
+//watchStopped records the end of the watch connection with the error that has closed it
+func (m *monitor) watchStopped(server KubeServer, r KubeResource, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ls := m.loop(server, r)
+	ls.watchStarted = time.Time{}
+	//outdated resource versions are expected, objects are listed again
+	if err != nil && !isGone(err) {
+		ls.failed(err)
+	}
+}
+
+func (m *monitor) received(server KubeServer, r KubeResource, t EventType) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loop(server, r).events[t]++
+}
+
+//synced records that the server has confirmed the mirrored objects
+func (m *monitor) synced(server KubeServer, r KubeResource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loop(server, r).lastSync = time.Now()
+}
+
+func (m *monitor) requested(path string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.requests[path]
+	if !ok {
+		h = newHistogram()
+		m.requests[path] = h
+	}
+	h.observe(d.Seconds())
+	m.responses[apiResponseKey{path, code}]++
+}
+
+func (ls *loopStatus) failed(err error) {
+	ls.lastError = err.Error()
+	ls.lastErrorTime = time.Now()
+}
+
+//reconnects is the number of watch connections opened after the first one
+func (ls *loopStatus) reconnects() uint64 {
+	if ls.watches == 0 {
+		return 0
+	}
+	return ls.watches - 1
+}
+
+//status returns the state of all mirrored resources, sorted by server, kind and group
+func (c *MrrCache) status() *APIStatus {
+	counts := c.counts()
+	c.mu.RLock()
+	states := make(map[KubeServer]map[resourceKey]resourceState)
+	for server, s := range c.states {
+		states[server] = make(map[resourceKey]resourceState)
+		for key, state := range s {
+			states[server][key] = state
+		}
+	}
+	c.mu.RUnlock()
+
+	m := c.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := &APIStatus{
+		APIVersion: APIVersion,
+		Kubemrr:    VERSION,
+		Started:    m.started,
+		Resources:  []APIResourceStatus{},
+	}
+	for server, loops := range m.loops {
+		for key, ls := range loops {
+			s := APIResourceStatus{
+				Server:     server.URL,
+				Group:      key.Group,
+				Kind:       key.Kind,
+				Objects:    counts[server][key],
+				Stale:      states[server][key].stale,
+				Started:    ls.started,
+				LastError:  ls.lastError,
+				Reconnects: ls.reconnects(),
+			}
+			for _, n := range ls.events {
+				s.Events += n
+			}
+			if !ls.lastSync.IsZero() {
+				t := ls.lastSync
+				s.LastSync = &t
+			}
+			if !ls.lastErrorTime.IsZero() {
+				t := ls.lastErrorTime
+				s.LastErrorTime = &t
+			}
+			if !ls.watchStarted.IsZero() {
+				t := ls.watchStarted
+				s.WatchStarted = &t
+			}
+			res.Resources = append(res.Resources, s)
+		}
+	}
+	sort.Slice(res.Resources, func(i, j int) bool {
+		a, b := res.Resources[i], res.Resources[j]
+		if a.Server != b.Server {
+			return a.Server < b.Server
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Group < b.Group
+	})
+	return res
+}
+
+//authorizedStatus returns the state of the resources whose objects the authorizer allows to see
+//in all namespaces. The servers and numbers of objects of other resources are not revealed
+func (c *MrrCache) authorizedStatus(ctx context.Context, a Authorizer) *APIStatus {
+	status := c.status()
+	if _, ok := a.(allowAll); ok {
+		return status
+	}
+
+	resources := []APIResourceStatus{}
+	for _, s := range status.Resources {
+		server := KubeServer{s.Server}
+		r, ok := c.resourceByKey(server, newResourceKey(s.Group, s.Kind))
+		if ok && a.Allowed(ctx, server, r, "") {
+			resources = append(resources, s)
+		}
+	}
+	status.Resources = resources
+	return status
+}
+
+//counts returns the number of cached objects of each resource
+func (c *MrrCache) counts() map[KubeServer]map[resourceKey]int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	res := make(map[KubeServer]map[resourceKey]int)
+	for server, index := range c.objects {
+		res[server] = make(map[resourceKey]int)
+		for key, kind := range index {
+			for _, i := range kind {
+				res[server][key] += i.len()
+			}
+		}
+	}
+	return res
+}
+
+//writeMetrics writes metrics of the mirror and of the resources of the status in the Prometheus text format
+func (c *MrrCache) writeMetrics(w io.Writer, status *APIStatus) {
+	now := time.Now()
+	mw := metricsWriter{w}
+
+	labels := func(s APIResourceStatus) []string {
+		return []string{"server", s.Server, "group", s.Group, "kind", s.Kind}
+	}
+
+	mw.family("kubemrr_cache_objects", "gauge", "Number of mirrored objects.")
+	for _, s := range status.Resources {
+		mw.sample("kubemrr_cache_objects", labels(s), float64(s.Objects))
+	}
+
+	mw.family("kubemrr_sync_age_seconds", "gauge", "Seconds since the server last confirmed the mirrored objects, or since the mirror started to watch them.")
+	for _, s := range status.Resources {
+		since := s.Started
+		if s.LastSync != nil {
+			since = *s.LastSync
+		}
+		mw.sample("kubemrr_sync_age_seconds", labels(s), now.Sub(since).Seconds())
+	}
+
+	mw.family("kubemrr_resource_stale", "gauge", "Whether the objects are restored from the cache file and not confirmed by the server yet.")
+	for _, s := range status.Resources {
+		stale := 0.0
+		if s.Stale {
+			stale = 1
+		}
+		mw.sample("kubemrr_resource_stale", labels(s), stale)
+	}
+
+	m := c.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mw.family("kubemrr_watch_events_total", "counter", "Number of watch events received from the servers.")
+	for _, s := range status.Resources {
+		ls := m.loops[KubeServer{s.Server}][newResourceKey(s.Group, s.Kind)]
+		if ls == nil {
+			continue
+		}
+		for _, t := range []EventType{Added, Modified, Deleted, Bookmark, Error} {
+			if n, ok := ls.events[t]; ok {
+				mw.sample("kubemrr_watch_events_total", append(labels(s), "type", string(t)), float64(n))
+			}
+		}
+	}
+
+	mw.family("kubemrr_watch_reconnects_total", "counter", "Number of watch connections opened after the first one.")
+	for _, s := range status.Resources {
+		mw.sample("kubemrr_watch_reconnects_total", labels(s), float64(s.Reconnects))
+	}
+
+	mw.family("kubemrr_list_duration_seconds", "histogram", "Latency of requests that list objects.")
+	for _, s := range status.Resources {
+		if ls := m.loops[KubeServer{s.Server}][newResourceKey(s.Group, s.Kind)]; ls != nil {
+			mw.histogram("kubemrr_list_duration_seconds", labels(s), ls.lists)
+		}
+	}
+
+	paths := make([]string, 0, len(m.requests))
+	for path := range m.requests {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	responses := make([]apiResponseKey, 0, len(m.responses))
+	for key := range m.responses {
+		responses = append(responses, key)
+	}
+	sort.Slice(responses, func(i, j int) bool {
+		if responses[i].path != responses[j].path {
+			return responses[i].path < responses[j].path
+		}
+		return responses[i].code < responses[j].code
+	})
+
+	mw.family("kubemrr_api_requests_total", "counter", "Number of requests to the API of the mirror.")
+	for _, key := range responses {
+		mw.sample("kubemrr_api_requests_total", []string{"path", key.path, "code", fmt.Sprint(key.code)}, float64(m.responses[key]))
+	}
+
+	mw.family("kubemrr_api_request_duration_seconds", "histogram", "Latency of requests to the API of the mirror.")
+	for _, path := range paths {
+		mw.histogram("kubemrr_api_request_duration_seconds", []string{"path", path}, m.requests[path])
+	}
+}
+
+//unsynced returns the number of mirrored resources whose objects the servers have not confirmed yet
+func (s *APIStatus) unsynced() int {
+	n := 0
+	for _, r := range s.Resources {
+		if r.LastSync == nil {
+			n++
+		}
+	}
+	return n
+}
+
+//monitoredPaths are the paths of the mirror whose requests are counted in metrics, other paths are counted together
+var monitoredPaths = []string{"/api", "/api/" + APIVersion + "/objects", "/api/" + APIVersion + "/labels", "/api/" + APIVersion + "/status", "/status", "/metrics", "/healthz", "/readyz"}
+
+//instrument records the statistics of requests to the handler
+func instrument(m *monitor, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := "other"
+		for _, p := range monitoredPaths {
+			if r.URL.Path == p {
+				path = p
+			}
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		m.requested(path, rec.code, time.Since(start))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+//writeStatusTable prints a line for each mirrored resource: how many objects it has, when they were
+//last confirmed by the server, how long the current watch connection is open, and the last error
+func writeStatusTable(out io.Writer, s *APIStatus, now time.Time) {
+	ago := func(t time.Time) string {
+		return now.Sub(t).Round(time.Second).String()
+	}
+
+	fmt.Fprintf(out, "kubemrr %s, running for %s\n\n", s.Kubemrr, ago(s.Started))
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tKIND\tOBJECTS\tSYNCED\tWATCHING\tLAST ERROR")
+	for _, r := range s.Resources {
+		kind := r.Kind
+		if r.Group != "" {
+			kind += "." + r.Group
+		}
+
+		synced := "never"
+		if r.LastSync != nil {
+			synced = ago(*r.LastSync) + " ago"
+		}
+		if r.Stale {
+			synced = "restored"
+		}
+
+		watching := "-"
+		if r.WatchStarted != nil {
+			watching = ago(*r.WatchStarted)
+		}
+
+		lastError := "-"
+		if r.LastErrorTime != nil {
+			lastError = fmt.Sprintf("%s ago: %s", ago(*r.LastErrorTime), strings.Replace(r.LastError, "\n", " ", -1))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", r.Server, kind, r.Objects, synced, watching, lastError)
+	}
+	w.Flush()
+}
+
+func NewStatusCommand(f Factory) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show what the mirror is doing",
+		Long: `
+DESCRIPTION:
+  Ask "kubemrr watch" process for the state of each mirrored resource: the number of
+  mirrored objects, when they were last confirmed by the server, how long the current
+  watch connection is open, and the last error.
+
+  The mirror also serves the state at /status, metrics in the Prometheus format at /metrics,
+  and /healthz and /readyz for health checks. /readyz fails until objects of every
+  mirrored resource are confirmed by the servers.
+
+EXAMPLE:
+  kubemrr -a 0.0.0.0 -p 33033 status
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := RunCommon(cmd); err != nil {
+				return err
+			}
+			return RunStatus(f, cmd, args)
+		},
+	}
+
+	AddCommonFlags(cmd)
+	AddEndpointSecurityFlags(cmd)
+	return cmd
+}
+
+func RunStatus(f Factory, cmd *cobra.Command, args []string) error {
+	endpoint, err := GetEndpoint(cmd)
+	if err != nil {
+		return err
+	}
+	client, err := f.MrrClient(endpoint)
+	if err != nil {
+		return fmt.Errorf("could not create client to kubemrr: %s", err)
+	}
+
+	status, err := client.Status()
+	if err != nil {
+		return err
+	}
+	writeStatusTable(f.StdOut(), status, time.Now())
+	return nil
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoopWatchObjectsStatus(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	kc.resourceVersion = "10"
+	kc.objects = []KubeObject{
+		{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "a1"}},
+		{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "a2"}},
+	}
+	kc.objectEvents = []*ObjectEvent{
+		{Added, &KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "a3", ResourceVersion: "11"}}},
+	}
+	kc.watchObjectError = errors.New("connection reset")
+	r, _ := defaultRegistry.Lookup("pod")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := loopWatchObjects(ctx, c, kc, r, testBackoff, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	status := c.status()
+	if assert.Equal(t, 1, len(status.Resources)) {
+		s := status.Resources[0]
+		assert.Equal(t, kc.Server().URL, s.Server)
+		assert.Equal(t, "pod", s.Kind)
+		assert.Equal(t, 3, s.Objects)
+		assert.NotNil(t, s.LastSync)
+		assert.NotNil(t, s.WatchStarted, "the last watch is open")
+		assert.Equal(t, "connection reset", s.LastError)
+		assert.Equal(t, uint64(4), s.Reconnects, "watch fails 4 times")
+		assert.Equal(t, uint64(5), s.Events)
+	}
+	assert.Equal(t, 0, status.unsynced())
+
+	cancel()
+	<-done
+	assert.Empty(t, c.status().Resources, "stopped loops are forgotten")
+}
+
+func TestWriteMetrics(t *testing.T) {
+	c := NewMrrCache()
+	server := KubeServer{"https://a.com"}
+	pod, _ := defaultRegistry.Lookup("pod")
+	c.updateKubeObject(server, KubeObject{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1", Namespace: "default"}})
+	c.monitor.loopStarted(server, pod)
+	c.monitor.listed(server, pod, 30*time.Millisecond, nil)
+	c.monitor.watchStarted(server, pod)
+	c.monitor.watchStarted(server, pod)
+	c.monitor.received(server, pod, Added)
+	c.monitor.received(server, pod, Added)
+	c.monitor.requested("/api/v1/objects", 200, time.Millisecond)
+
+	buf := bytes.NewBuffer([]byte{})
+	c.writeMetrics(buf, c.status())
+	out := buf.String()
+
+	labels := `{server="https://a.com",group="",kind="pod"}`
+	for _, line := range []string{
+		"# TYPE kubemrr_cache_objects gauge\n",
+		"kubemrr_cache_objects" + labels + " 1\n",
+		"kubemrr_resource_stale" + labels + " 0\n",
+		`kubemrr_watch_events_total{server="https://a.com",group="",kind="pod",type="ADDED"} 2` + "\n",
+		"kubemrr_watch_reconnects_total" + labels + " 1\n",
+		`kubemrr_list_duration_seconds_bucket{server="https://a.com",group="",kind="pod",le="0.025"} 0` + "\n",
+		`kubemrr_list_duration_seconds_bucket{server="https://a.com",group="",kind="pod",le="0.05"} 1` + "\n",
+		`kubemrr_list_duration_seconds_bucket{server="https://a.com",group="",kind="pod",le="+Inf"} 1` + "\n",
+		"kubemrr_list_duration_seconds_count" + labels + " 1\n",
+		`kubemrr_api_requests_total{path="/api/v1/objects",code="200"} 1` + "\n",
+		`kubemrr_api_request_duration_seconds_count{path="/api/v1/objects"} 1` + "\n",
+	} {
+		assert.Contains(t, out, line)
+	}
+	assert.Contains(t, out, "kubemrr_sync_age_seconds"+labels+" ")
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	c := NewMrrCache()
+	server := KubeServer{"https://a.com"}
+	pod, _ := defaultRegistry.Lookup("pod")
+	c.monitor.loopStarted(server, pod)
+
+	s := httptest.NewServer(NewMirrorHandler(c))
+	defer s.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok\n", body)
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "objects of 1 of 1 resources are not synced yet\n", body)
+
+	c.monitor.synced(server, pod)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body = get("/status")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "https://a.com  pod   0        0s ago  -         -")
+
+	get("/api/v1/labels?kind=pod")
+	code, body = get("/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `kubemrr_api_requests_total{path="/readyz",code="503"} 1`)
+	assert.Contains(t, body, `kubemrr_api_request_duration_seconds_count{path="/api/v1/labels"} 1`)
+
+	client, err := NewMrrClient(MirrorEndpoint{Network: "tcp", Address: s.Listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := client.Status()
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(status.Resources)) {
+		assert.Equal(t, VERSION, status.Kubemrr)
+		assert.Equal(t, "pod", status.Resources[0].Kind)
+		assert.NotNil(t, status.Resources[0].LastSync)
+	}
+}
+
+func TestStatusIsAuthorized(t *testing.T) {
+	c := NewMrrCache()
+	pod, _ := defaultRegistry.Lookup("pod")
+	node, _ := defaultRegistry.Lookup("node")
+	for _, server := range []KubeServer{{"https://dev.com"}, {"https://prod.com"}} {
+		c.monitor.loopStarted(server, pod)
+		c.monitor.loopStarted(server, node)
+	}
+	u := &AuthzUser{Rules: []AuthzRule{
+		{Servers: []string{"https://dev.*"}, Namespaces: []string{"*"}},
+		{Servers: []string{"https://prod.*"}, Namespaces: []string{"team-a"}, ClusterScoped: true},
+	}}
+
+	status := c.authorizedStatus(context.Background(), u)
+	actual := []string{}
+	for _, s := range status.Resources {
+		actual = append(actual, s.Server+" "+s.Kind)
+	}
+	assert.Equal(t, []string{"https://dev.com pod", "https://prod.com node"}, actual)
+
+	buf := bytes.NewBuffer([]byte{})
+	c.writeMetrics(buf, status)
+	assert.NotContains(t, buf.String(), `server="https://prod.com",group="",kind="pod"`)
+}
+
+func TestWriteStatusTable(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	status := &APIStatus{
+		Kubemrr: "1.0.0",
+		Started: now.Add(-time.Hour),
+		Resources: []APIResourceStatus{
+			{Server: "https://a.com", Kind: "pod", Objects: 12, LastSync: ago(5 * time.Second), WatchStarted: ago(time.Minute)},
+			{Server: "https://a.com", Group: "apps", Kind: "deployment", Objects: 3, Stale: true,
+				LastError: "connection refused", LastErrorTime: ago(10 * time.Second)},
+		},
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	writeStatusTable(buf, status, now)
+	assert.Equal(t, `kubemrr 1.0.0, running for 1h0m0s
+
+SERVER         KIND             OBJECTS  SYNCED    WATCHING  LAST ERROR
+https://a.com  pod              12       5s ago    1m0s      -
+https://a.com  deployment.apps  3        restored  -         10s ago: connection refused
+`, buf.String())
+}
+
+func TestRunStatus(t *testing.T) {
+	tc := &TestMirrorClient{status: &APIStatus{Kubemrr: VERSION, Started: time.Now()}}
+	buf := bytes.NewBuffer([]byte{})
+	f := &TestFactory{mrrClient: tc, stdOut: buf}
+
+	assert.NoError(t, RunStatus(f, NewStatusCommand(f), nil))
+	assert.Contains(t, buf.String(), "SERVER")
+
+	tc.err = errors.New("connection refused")
+	assert.Error(t, RunStatus(f, NewStatusCommand(f), nil))
+}
@@ -3,23 +3,104 @@ package app
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 type ObjectMeta struct {
-	Name            string `json:"name,omitempty"`
-	Namespace       string `json:"namespace,omitempty"`
-	ResourceVersion string `json:"resourceVersion,omitempty"`
+	Name            string            `json:"name,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	//Annotations are kept only for the keys chosen with --annotations of watch command
+	Annotations map[string]string `json:"annotations,omitempty"`
+	//DeletionTimestamp is set when the object is being deleted
+	DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
 }
 
+//TypeMeta tells the resource of an object. Kind is the singular name of the resource,
+//and Group is the API group of the resource, empty for the core resources.
+//Both are set by the client, because list items do not have them
 type TypeMeta struct {
-	Kind string `json:"kind,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+	Group string `json:"-"`
 }
 
 type KubeObject struct {
 	TypeMeta   `json:",inline"`
 	ObjectMeta `json:"metadata,omitempty"`
+	//Spec and Status are kept only for pods
+	Spec   *PodSpec   `json:"spec,omitempty"`
+	Status *PodStatus `json:"status,omitempty"`
+}
+
+//PodSpec keeps names of containers of a pod, which are completed by kubectl logs and exec,
+//and the node of the pod, which is matched by field selectors
+type PodSpec struct {
+	NodeName       string      `json:"nodeName,omitempty"`
+	Containers     []Container `json:"containers,omitempty"`
+	InitContainers []Container `json:"initContainers,omitempty"`
+}
+
+//PodStatus keeps the phase of a pod, and only the Ready condition of the pod
+type PodStatus struct {
+	Phase      string         `json:"phase,omitempty"`
+	Conditions []PodCondition `json:"conditions,omitempty"`
+}
+
+type PodCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+const (
+	podRunning    = "Running"
+	podReady      = "Ready"
+	conditionTrue = "True"
+)
+
+type Container struct {
+	Name string `json:"name"`
+}
+
+//containerNames returns names of the containers followed by names of the init containers
+func (o KubeObject) containerNames() []string {
+	names := []string{}
+	if o.Spec == nil {
+		return names
+	}
+	for _, c := range o.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	for _, c := range o.Spec.InitContainers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+//isReady tells whether the pod has condition Ready
+func (o KubeObject) isReady() bool {
+	if o.Status == nil {
+		return false
+	}
+	for _, c := range o.Status.Conditions {
+		if c.Type == podReady {
+			return c.Status == conditionTrue
+		}
+	}
+	return false
+}
+
+//isRunning tells whether the pod is in phase Running and is not being deleted, so that
+//kubectl exec, attach and port-forward can connect to it
+func (o KubeObject) isRunning() bool {
+	return o.Status != nil && o.Status.Phase == podRunning && o.DeletionTimestamp == nil
 }
 
 //KubeServer represents a Kubernetes API server which we ask for information
@@ -49,12 +130,19 @@ type Config struct {
 	Contexts       []ContextWrap `yaml:"contexts"`
 	Users          []UserWrap    `yaml:"users"`
 	CurrentContext string        `yaml:"current-context"`
+
+	//Filename is the file the config was read from, empty if the config is not from a file.
+	//For merged configs it is the first of the merged files
+	Filename string `yaml:"-"`
 }
 
 type Cluster struct {
-	Server               string `yaml:"server"`
-	SkipVerify           bool   `yaml:"insecure-skip-tls-verify"`
-	CertificateAuthority string `yaml:"certificate-authority"`
+	Server                   string `yaml:"server"`
+	SkipVerify               bool   `yaml:"insecure-skip-tls-verify"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	TLSServerName            string `yaml:"tls-server-name"`
+	ProxyURL                 string `yaml:"proxy-url"`
 }
 
 type ClusterWrap struct {
@@ -74,8 +162,35 @@ type ContextWrap struct {
 }
 
 type User struct {
-	ClientCertificate string `yaml:"client-certificate"`
-	ClientKey         string `yaml:"client-key"`
+	ClientCertificate     string              `yaml:"client-certificate"`
+	ClientCertificateData string              `yaml:"client-certificate-data"`
+	ClientKey             string              `yaml:"client-key"`
+	ClientKeyData         string              `yaml:"client-key-data"`
+	Token                 string              `yaml:"token"`
+	TokenFile             string              `yaml:"tokenFile"`
+	Username              string              `yaml:"username"`
+	Password              string              `yaml:"password"`
+	AuthProvider          *AuthProviderConfig `yaml:"auth-provider"`
+	Exec                  *ExecConfig         `yaml:"exec"`
+}
+
+//AuthProviderConfig configures a plugin that provides tokens, such as gcp or oidc
+type AuthProviderConfig struct {
+	Name   string            `yaml:"name"`
+	Config map[string]string `yaml:"config"`
+}
+
+//ExecConfig configures a command that provides credentials, such as aws-iam-authenticator
+type ExecConfig struct {
+	Command    string       `yaml:"command"`
+	Args       []string     `yaml:"args"`
+	Env        []ExecEnvVar `yaml:"env"`
+	APIVersion string       `yaml:"apiVersion"`
+}
+
+type ExecEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
 }
 
 type UserWrap struct {
@@ -83,6 +198,41 @@ type UserWrap struct {
 	User User   `yaml:"user"`
 }
 
+//resolvePaths makes relative paths in the config relative to the directory of the config file,
+//like kubectl does. The command of exec plugin is resolved only if it contains a path separator,
+//otherwise it is looked up in PATH
+func (c *Config) resolvePaths() error {
+	if c.Filename == "" {
+		return nil
+	}
+
+	abs, err := filepath.Abs(c.Filename)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(abs)
+
+	resolve := func(p *string) {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+
+	for i := range c.Clusters {
+		resolve(&c.Clusters[i].Cluster.CertificateAuthority)
+	}
+	for i := range c.Users {
+		u := &c.Users[i].User
+		resolve(&u.ClientCertificate)
+		resolve(&u.ClientKey)
+		resolve(&u.TokenFile)
+		if u.Exec != nil && strings.ContainsRune(u.Exec.Command, filepath.Separator) {
+			resolve(&u.Exec.Command)
+		}
+	}
+	return nil
+}
+
 func NewConfigFromURL(url string) (*Config, error) {
 	config := Config{}
 	cl := ClusterWrap{url, Cluster{Server: url}}
@@ -151,6 +301,8 @@ func (c *Config) getUser(name string) User {
 	return user
 }
 
+//GenerateTLSConfig returns TLS configuration of the current context.
+//Inline certificate data takes precedence over the certificate files, like in kubectl
 func (cfg *Config) GenerateTLSConfig() (*tls.Config, error) {
 	context := cfg.getCurrentContext()
 	c := cfg.getCluster(context.Cluster)
@@ -158,29 +310,41 @@ func (cfg *Config) GenerateTLSConfig() (*tls.Config, error) {
 
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: c.SkipVerify,
+		ServerName:         c.TLSServerName,
 	}
 
-	if len(c.CertificateAuthority) > 0 {
+	caCert, err := readDataOrFile(c.CertificateAuthorityData, c.CertificateAuthority)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use specified CA cert: %s", err)
+	}
+	if caCert != nil {
 		caCertPool := x509.NewCertPool()
-		caCert, err := ioutil.ReadFile(c.CertificateAuthority)
-		if err != nil {
-			return nil, fmt.Errorf("unable to use specified CA cert %s: %s", c.CertificateAuthority, err)
-		}
 		ok := caCertPool.AppendCertsFromPEM(caCert)
 		if !ok {
-			return nil, fmt.Errorf("unable to parse CA cert %s", c.CertificateAuthority)
+			return nil, fmt.Errorf("unable to parse CA cert %s", describeDataOrFile(c.CertificateAuthorityData, c.CertificateAuthority))
 		}
 		tlsConfig.RootCAs = caCertPool
 	}
 
-	if len(u.ClientCertificate) > 0 && len(u.ClientKey) == 0 {
-		return nil, fmt.Errorf("client cert file %q specified without client key file", u.ClientCertificate)
-	} else if len(u.ClientKey) > 0 && len(u.ClientCertificate) == 0 {
-		return nil, fmt.Errorf("client key file %q specified without client cert file", u.ClientKey)
-	} else if len(u.ClientCertificate) > 0 && len(u.ClientKey) > 0 {
-		cert, err := tls.LoadX509KeyPair(u.ClientCertificate, u.ClientKey)
+	clientCert, err := readDataOrFile(u.ClientCertificateData, u.ClientCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use specified client cert: %s", err)
+	}
+	clientKey, err := readDataOrFile(u.ClientKeyData, u.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use specified client key: %s", err)
+	}
+
+	certName := describeDataOrFile(u.ClientCertificateData, u.ClientCertificate)
+	keyName := describeDataOrFile(u.ClientKeyData, u.ClientKey)
+	if clientCert != nil && clientKey == nil {
+		return nil, fmt.Errorf("client cert %s specified without client key", certName)
+	} else if clientKey != nil && clientCert == nil {
+		return nil, fmt.Errorf("client key %s specified without client cert", keyName)
+	} else if clientCert != nil && clientKey != nil {
+		cert, err := tls.X509KeyPair(clientCert, clientKey)
 		if err != nil {
-			return nil, fmt.Errorf("unable to use specified client cert (%s) & key (%s): %s", u.ClientCertificate, u.ClientKey, err)
+			return nil, fmt.Errorf("unable to use specified client cert (%s) & key (%s): %s", certName, keyName, err)
 		}
 		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
@@ -188,3 +352,48 @@ func (cfg *Config) GenerateTLSConfig() (*tls.Config, error) {
 
 	return tlsConfig, nil
 }
+
+//GenerateProxy returns the proxy of the current context. Without proxy-url in the cluster
+//the proxy is taken from environment variables, like in kubectl
+func (cfg *Config) GenerateProxy() (func(*http.Request) (*url.URL, error), error) {
+	c := cfg.getCurrentCluster()
+	if c.ProxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse proxy-url %s: %s", c.ProxyURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported scheme of proxy-url %s, expected http, https or socks5", c.ProxyURL)
+	}
+	return http.ProxyURL(u), nil
+}
+
+//readDataOrFile returns decoded base64 data if it is given, otherwise content of the file.
+//Returns nil if neither is given
+func readDataOrFile(data string, file string) ([]byte, error) {
+	if data != "" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 data: %s", err)
+		}
+		return decoded, nil
+	}
+
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+
+	return nil, nil
+}
+
+func describeDataOrFile(data string, file string) string {
+	if data != "" {
+		return "<inline data>"
+	}
+	return file
+}
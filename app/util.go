@@ -1,18 +1,23 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	log "github.com/Sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"net/rpc"
 	"net/url"
 	"os"
+	"os/signal"
 	"os/user"
 	"path"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 func AddCommonFlags(cmd *cobra.Command) {
@@ -20,6 +25,7 @@ func AddCommonFlags(cmd *cobra.Command) {
 	cmd.Flags().String("kubeconfig", "~/.kube/config", "Path to the kubeconfig file")
 	cmd.Flags().IntP("port", "p", 33033, "The port on which mirror is accessible")
 	cmd.Flags().BoolP("verbose", "v", false, "Enables verbose output")
+	cmd.Flags().String("socket", "", "Path to the Unix socket where mirror is accessible, instead of the address and port")
 }
 
 func RunCommon(cmd *cobra.Command) error {
@@ -46,27 +52,43 @@ func GetBind(cmd *cobra.Command) (string, error) {
 	return fmt.Sprintf("%s:%d", address, port), nil
 }
 
+//GetKubeconfig returns the file given with --kubeconfig flag. If the flag is not given,
+//the files listed in KUBECONFIG environment variable are merged, like in kubectl
 func GetKubeconfig(cmd *cobra.Command) (*Config, error) {
-	file, err := cmd.Flags().GetString("kubeconfig")
+	files, err := GetKubeconfigFiles(cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	config, err := parseKubeConfig(file)
+	config, err := parseKubeConfigs(files)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse kubeconfig file %s: %s", file, err)
+		return nil, fmt.Errorf("could not parse kubeconfig: %s", err)
 	}
 
 	return &config, nil
 }
 
+//GetKubeconfigFiles returns the kubeconfig files in the order of their precedence
+func GetKubeconfigFiles(cmd *cobra.Command) ([]string, error) {
+	file, err := cmd.Flags().GetString("kubeconfig")
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Flags().Changed("kubeconfig") {
+		return []string{file}, nil
+	}
+	return defaultKubeconfigFiles()
+}
+
 type Factory interface {
 	KubeClient(config *Config) KubeClient
-	MrrClient(bind string) (MrrClient, error)
+	MrrClient(e MirrorEndpoint) (MrrClient, error)
 	MrrCache() *MrrCache
-	Serve(l net.Listener, c *MrrCache) error
+	Serve(ctx context.Context, l net.Listener, h http.Handler) error
 	HomeKubeconfig() (Config, error)
 	StdOut() io.Writer
+	Daemon(files DaemonFiles) Daemon
 }
 
 type DefaultFactory struct {
@@ -81,8 +103,8 @@ func NewFactory(stdOut io.Writer, kubeconfig *Config) Factory {
 	}
 }
 
-func (f *DefaultFactory) MrrClient(address string) (MrrClient, error) {
-	return NewMrrClient(address)
+func (f *DefaultFactory) MrrClient(e MirrorEndpoint) (MrrClient, error) {
+	return NewMrrClient(e)
 }
 
 func (f *DefaultFactory) StdOut() io.Writer {
@@ -101,10 +123,46 @@ func (f *DefaultFactory) KubeClient(config *Config) KubeClient {
 	return NewKubeClient(config)
 }
 
-func (f *DefaultFactory) Serve(l net.Listener, cache *MrrCache) error {
-	rpc.Register(cache)
-	rpc.HandleHTTP()
-	return http.Serve(l, nil)
+//Serve serves requests until the context is canceled. Then it stops to accept connections,
+//closes idle ones and waits for active requests up to shutdownTimeout
+func (f *DefaultFactory) Serve(ctx context.Context, l net.Listener, h http.Handler) error {
+	server := &http.Server{Handler: h}
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		timeout, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- server.Shutdown(timeout)
+	}()
+
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return <-shutdown
+}
+
+//shutdownTimeout is how long Serve waits for active requests after the context is canceled
+var shutdownTimeout = 10 * time.Second
+
+//notifyContext returns a context which is canceled when the process receives any of the signals
+func notifyContext(parent context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	go func() {
+		defer signal.Stop(ch)
+		select {
+		case sig := <-ch:
+			log.WithField("signal", sig.String()).Info("received signal")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (f *DefaultFactory) Daemon(files DaemonFiles) Daemon {
+	return newProcessDaemon(files)
 }
 
 func (f *DefaultFactory) HomeKubeconfig() (Config, error) {
@@ -112,11 +170,104 @@ func (f *DefaultFactory) HomeKubeconfig() (Config, error) {
 		return *f.kubeconfig, nil
 	}
 
-	usr, err := user.Current()
+	return loadDefaultKubeconfig()
+}
+
+//loadDefaultKubeconfig merges files listed in KUBECONFIG environment variable,
+//or reads ~/.kube/config if the variable is empty
+func loadDefaultKubeconfig() (Config, error) {
+	files, err := defaultKubeconfigFiles()
 	if err != nil {
 		return Config{}, err
 	}
-	return parseKubeConfig(usr.HomeDir + "/.kube/config")
+	return parseKubeConfigs(files)
+}
+
+func defaultKubeconfigFiles() ([]string, error) {
+	files := filepath.SplitList(os.Getenv("KUBECONFIG"))
+	if len(files) > 0 {
+		return files, nil
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	return []string{usr.HomeDir + "/.kube/config"}, nil
+}
+
+//parseKubeConfigs reads and merges the files with the same precedence rules as kubectl:
+//the first file that defines a cluster, context, user or current context wins.
+//Missing files are skipped, but at least one file must exist. A single file is read as is
+func parseKubeConfigs(files []string) (Config, error) {
+	if len(files) == 1 {
+		return parseKubeConfig(files[0])
+	}
+
+	var configs []Config
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+
+		fnResolved, err := substituteUserHome(file)
+		if err != nil {
+			return Config{}, fmt.Errorf("could not substitute ~ in file %s: %s", file, err)
+		}
+		if _, err := os.Stat(fnResolved); os.IsNotExist(err) {
+			continue
+		}
+
+		config, err := parseKubeConfig(file)
+		if err != nil {
+			return Config{}, err
+		}
+		configs = append(configs, config)
+	}
+
+	if len(configs) == 0 {
+		return Config{}, fmt.Errorf("none of the files %v exists", files)
+	}
+
+	return mergeConfigs(configs), nil
+}
+
+func mergeConfigs(configs []Config) Config {
+	res := Config{}
+	clusters := make(map[string]bool)
+	contexts := make(map[string]bool)
+	users := make(map[string]bool)
+
+	for _, c := range configs {
+		if res.Filename == "" {
+			res.Filename = c.Filename
+		}
+		if res.CurrentContext == "" {
+			res.CurrentContext = c.CurrentContext
+		}
+		for _, cl := range c.Clusters {
+			if !clusters[cl.Name] {
+				clusters[cl.Name] = true
+				res.Clusters = append(res.Clusters, cl)
+			}
+		}
+		for _, ctx := range c.Contexts {
+			if !contexts[ctx.Name] {
+				contexts[ctx.Name] = true
+				res.Contexts = append(res.Contexts, ctx)
+			}
+		}
+		for _, u := range c.Users {
+			if !users[u.Name] {
+				users[u.Name] = true
+				res.Users = append(res.Users, u)
+			}
+		}
+	}
+
+	return res
 }
 
 func parseKubeConfig(filename string) (Config, error) {
@@ -135,25 +286,35 @@ func parseKubeConfig(filename string) (Config, error) {
 		return res, fmt.Errorf("could not parse file %s: %s", filename, err)
 	}
 
+	res.Filename = fnResolved
+	err = res.resolvePaths()
+	if err != nil {
+		return res, fmt.Errorf("could not resolve paths in file %s: %s", filename, err)
+	}
+
 	return res, nil
 }
 
 type TestFactory struct {
 	mrrClient   MrrClient
 	mrrCache    *MrrCache
+	daemon      *TestDaemon
 	kubeClients map[string]*TestKubeClient
 	kubeconfig  Config
 	stdOut      io.Writer
+	serveStop   chan struct{}
+	mu          sync.Mutex
 }
 
 func NewTestFactory() *TestFactory {
 	return &TestFactory{
 		kubeClients: make(map[string]*TestKubeClient),
 		mrrCache:    NewMrrCache(),
+		daemon:      &TestDaemon{},
 	}
 }
 
-func (f *TestFactory) MrrClient(address string) (MrrClient, error) {
+func (f *TestFactory) MrrClient(e MirrorEndpoint) (MrrClient, error) {
 	return f.mrrClient, nil
 }
 
@@ -169,16 +330,26 @@ func (f *TestFactory) MrrCache() *MrrCache {
 	return f.mrrCache
 }
 
-func (f *TestFactory) Serve(l net.Listener, cache *MrrCache) error {
+func (f *TestFactory) Serve(ctx context.Context, l net.Listener, h http.Handler) error {
+	select {
+	case <-ctx.Done():
+	case <-f.serveStop:
+	}
 	return nil
 }
 
+func (f *TestFactory) Daemon(files DaemonFiles) Daemon {
+	return f.daemon
+}
+
 func (f *TestFactory) HomeKubeconfig() (Config, error) {
 	return f.kubeconfig, nil
 }
 
 func (f *TestFactory) KubeClient(config *Config) KubeClient {
 	url, _ := url.Parse(config.getCurrentCluster().Server)
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	kc, ok := f.kubeClients[url.String()]
 	if !ok {
 		kc = NewTestKubeClient()
@@ -188,6 +359,24 @@ func (f *TestFactory) KubeClient(config *Config) KubeClient {
 	return kc
 }
 
+//kubeClient returns the client created for the given server, or nil
+func (f *TestFactory) kubeClient(server string) *TestKubeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.kubeClients[server]
+}
+
+//clients returns the clients created so far
+func (f *TestFactory) clients() []*TestKubeClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := []*TestKubeClient{}
+	for _, kc := range f.kubeClients {
+		res = append(res, kc)
+	}
+	return res
+}
+
 //Copyright 2014 The Kubernetes Authors.
 func recursiveSplit(dir string) []string {
 	parent, file := path.Split(dir)
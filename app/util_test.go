@@ -1,10 +1,14 @@
 package app
 
 import (
+	"encoding/base64"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/user"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -44,6 +48,7 @@ func TestParseKubeConfig(t *testing.T) {
 		return
 	}
 
+	dir, _ := filepath.Abs("test_data")
 	expected := Config{
 		CurrentContext: "prod",
 		Contexts: []ContextWrap{
@@ -51,18 +56,101 @@ func TestParseKubeConfig(t *testing.T) {
 			{"prod", Context{"cluster_1", "blue", "user_1"}},
 		},
 		Clusters: []ClusterWrap{
-			{"cluster_1", Cluster{Server: "https://foo.com", CertificateAuthority: "ca1"}},
-			{"cluster_2", Cluster{Server: "https://bar.com", CertificateAuthority: "ca2", SkipVerify: true}},
+			{"cluster_1", Cluster{Server: "https://foo.com", CertificateAuthority: dir + "/ca1"}},
+			{"cluster_2", Cluster{Server: "https://bar.com", CertificateAuthority: dir + "/ca2", SkipVerify: true}},
 		},
 		Users: []UserWrap{
-			{"user_1", User{"cert1", "key1"}},
-			{"user_2", User{"cert2", "key2"}},
+			{"user_1", User{ClientCertificate: dir + "/cert1", ClientKey: dir + "/key1"}},
+			{"user_2", User{ClientCertificate: dir + "/cert2", ClientKey: dir + "/key2"}},
 		},
+		Filename: "test_data/kubeconfig_valid",
 	}
 
 	assert.Equal(t, expected, actual)
 }
 
+func TestParseKubeConfigRelativePaths(t *testing.T) {
+	actual, err := parseKubeConfig("test_data/kubeconfig_relative")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	dir, _ := filepath.Abs("test_data")
+	u := actual.getUser("local")
+	assert.Equal(t, dir+"/ca.pem", actual.getCluster("local").CertificateAuthority)
+	assert.Equal(t, dir+"/cert.pem", u.ClientCertificate)
+	assert.Equal(t, dir+"/key.pem", u.ClientKey)
+	assert.Equal(t, "/var/run/secrets/token", u.TokenFile, "absolute path must not change")
+	assert.Equal(t, dir+"/bin/credentials", u.Exec.Command)
+
+	_, err = actual.GenerateTLSConfig()
+	assert.NoError(t, err, "certificates must be found regardless of working directory")
+}
+
+func TestResolvePathsOfExecCommand(t *testing.T) {
+	c := Config{
+		Filename: "/etc/kube/config",
+		Users: []UserWrap{
+			{"a", User{Exec: &ExecConfig{Command: "aws-iam-authenticator"}}},
+			{"b", User{Exec: &ExecConfig{Command: "./bin/plugin"}}},
+		},
+	}
+
+	assert.NoError(t, c.resolvePaths())
+	assert.Equal(t, "aws-iam-authenticator", c.getUser("a").Exec.Command, "command must be looked up in PATH")
+	assert.Equal(t, "/etc/kube/bin/plugin", c.getUser("b").Exec.Command)
+}
+
+func TestParseKubeConfigs(t *testing.T) {
+	actual, err := parseKubeConfigs([]string{"test_data/kubeconfig_valid", "test_data/kubeconfig_missing", "test_data/kubeconfig_merge"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	assert.Equal(t, "prod", actual.CurrentContext, "first current context must win")
+	assert.Equal(t, "https://foo.com", actual.getCluster("cluster_1").Server, "first cluster must win")
+	assert.Equal(t, "https://baz.com", actual.getCluster("cluster_3").Server)
+	assert.Equal(t, "cluster_1", actual.getContext("prod").Cluster, "first context must win")
+	assert.Equal(t, "green", actual.getContext("stage").Namespace)
+	assert.Equal(t, "secret", actual.getUser("user_3").Token)
+
+	actual, err = parseKubeConfigs([]string{"test_data/kubeconfig_merge", "test_data/kubeconfig_valid"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	assert.Equal(t, "stage", actual.CurrentContext)
+	assert.Equal(t, "https://other.com", actual.getCluster("cluster_1").Server)
+	assert.Equal(t, "cluster_3", actual.getContext("prod").Cluster)
+	assert.Equal(t, 3, len(actual.Clusters))
+}
+
+func TestParseKubeConfigsFailures(t *testing.T) {
+	_, err := parseKubeConfigs([]string{"test_data/kubeconfig_missing"})
+	assert.Error(t, err, "at least one file must exist")
+
+	_, err = parseKubeConfigs([]string{"test_data/kubeconfig_valid", "test_data/kubeconfig_invalid"})
+	assert.Error(t, err, "invalid file must not be skipped")
+}
+
+func TestGetKubeconfigFromEnv(t *testing.T) {
+	defer os.Setenv("KUBECONFIG", os.Getenv("KUBECONFIG"))
+	os.Setenv("KUBECONFIG", strings.Join([]string{"test_data/kubeconfig_merge", "test_data/kubeconfig_valid"}, string(os.PathListSeparator)))
+
+	cmd := NewWatchCommand(NewTestFactory())
+	config, err := GetKubeconfig(cmd)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "stage", config.CurrentContext)
+		assert.NotNil(t, config.getContext("dev"))
+	}
+
+	cmd.Flags().Set("kubeconfig", "test_data/kubeconfig_valid")
+	config, err = GetKubeconfig(cmd)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "prod", config.CurrentContext, "--kubeconfig flag must take precedence over KUBECONFIG")
+		assert.Nil(t, config.getContext("stage"))
+	}
+}
+
 func TestConfigMakeFilter(t *testing.T) {
 	conf := Config{
 		CurrentContext: "prod",
@@ -86,7 +174,7 @@ func TestConfigMakeTLSConfig(t *testing.T) {
 		CurrentContext: "x",
 		Contexts:       []ContextWrap{{"x", Context{Cluster: "cluster", User: "user"}}},
 		Clusters:       []ClusterWrap{{"cluster", Cluster{CertificateAuthority: "test_data/ca.pem", SkipVerify: true}}},
-		Users:          []UserWrap{{"user", User{"test_data/cert.pem", "test_data/key.pem"}}},
+		Users:          []UserWrap{{"user", User{ClientCertificate: "test_data/cert.pem", ClientKey: "test_data/key.pem"}}},
 	}
 
 	tls, err := cfg.GenerateTLSConfig()
@@ -97,6 +185,98 @@ func TestConfigMakeTLSConfig(t *testing.T) {
 	assert.Equal(t, true, tls.InsecureSkipVerify)
 }
 
+func TestConfigMakeTLSConfigFromData(t *testing.T) {
+	data := func(file string) string {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+
+	cfg := Config{
+		CurrentContext: "x",
+		Contexts:       []ContextWrap{{"x", Context{Cluster: "cluster", User: "user"}}},
+		Clusters: []ClusterWrap{{"cluster", Cluster{
+			CertificateAuthority:     "test_data/missing.pem",
+			CertificateAuthorityData: data("test_data/ca.pem"),
+			TLSServerName:            "kubernetes.default",
+		}}},
+		Users: []UserWrap{{"user", User{ClientCertificateData: data("test_data/cert.pem"), ClientKey: "test_data/key.pem"}}},
+	}
+
+	tls, err := cfg.GenerateTLSConfig()
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, len(tls.RootCAs.Subjects()), "must have parsed Certificate Authority")
+		assert.Equal(t, 1, len(tls.Certificates), "must have parsed client certificate")
+		assert.Equal(t, "kubernetes.default", tls.ServerName)
+	}
+}
+
+func TestConfigMakeTLSConfigFailures(t *testing.T) {
+	tests := []struct {
+		cluster  Cluster
+		user     User
+		complain string
+	}{
+		{Cluster{CertificateAuthorityData: "not base64"}, User{}, "invalid base64"},
+		{Cluster{CertificateAuthorityData: "bm90IHBlbQ=="}, User{}, "unable to parse CA cert <inline data>"},
+		{Cluster{}, User{ClientCertificate: "test_data/cert.pem"}, "without client key"},
+		{Cluster{}, User{ClientKeyData: "a2V5"}, "without client cert"},
+	}
+
+	for _, test := range tests {
+		cfg := Config{
+			CurrentContext: "x",
+			Contexts:       []ContextWrap{{"x", Context{Cluster: "cluster", User: "user"}}},
+			Clusters:       []ClusterWrap{{"cluster", test.cluster}},
+			Users:          []UserWrap{{"user", test.user}},
+		}
+
+		_, err := cfg.GenerateTLSConfig()
+		if err == nil {
+			t.Errorf("Expected an error for %+v %+v", test.cluster, test.user)
+			continue
+		}
+		assert.Contains(t, err.Error(), test.complain)
+	}
+}
+
+func TestConfigMakeProxy(t *testing.T) {
+	tests := []struct {
+		proxyURL string
+		expected string
+		complain string
+	}{
+		{proxyURL: "http://proxy:3128", expected: "http://proxy:3128"},
+		{proxyURL: "socks5://localhost:1080", expected: "socks5://localhost:1080"},
+		{proxyURL: "ftp://proxy", complain: "unsupported scheme"},
+	}
+
+	for _, test := range tests {
+		cfg := Config{
+			CurrentContext: "x",
+			Contexts:       []ContextWrap{{"x", Context{Cluster: "cluster"}}},
+			Clusters:       []ClusterWrap{{"cluster", Cluster{Server: "https://foo.com", ProxyURL: test.proxyURL}}},
+		}
+
+		proxy, err := cfg.GenerateProxy()
+		if test.complain != "" {
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.complain)
+			}
+			continue
+		}
+
+		if assert.NoError(t, err) {
+			req, _ := http.NewRequest("GET", "https://foo.com", nil)
+			u, _ := proxy(req)
+			assert.Equal(t, test.expected, u.String())
+		}
+	}
+}
+
 //Copyright 2014 The Kubernetes Authors.
 func TestSubstituteUserHome(t *testing.T) {
 	usr, err := user.Current()
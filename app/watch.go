@@ -1,13 +1,16 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/asaskevich/govalidator"
 	"github.com/spf13/cobra"
-	"net"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -21,17 +24,42 @@ DESCRIPTION:
 
   It maintans several connections to each of the given API servers.
   Connection configuration is taken from the --kubeconfig file, where contexts are defined.
+  Without the flag, files listed in KUBECONFIG environment variable are merged like in kubectl,
+  falling back to ~/.kube/config.
   On each connection it will listen for changes happened in the Kubernetes cluster.
   The names of the alive resources are available by "get" command.
 
-  Mirrored resources: pods, services, deployments, configmaps, namespaces, nodes.
+  Arguments are URLs of servers, names of contexts or glob patterns of context names,
+  e.g. "prod-*". With --all-contexts servers of all contexts are mirrored.
+  The kubeconfig files and the certificates they refer to are watched for changes:
+  mirrors are started and stopped as matching contexts appear and disappear,
+  and restarted when credentials of their servers change.
+
+  Mirrored resources are discovered from the API servers: every resource that can be listed
+  is mirrored, unless it is excluded by --exclude or not mentioned in --only.
+
+  Mirrored objects are written to --cache-file every --cache-interval. On start they are
+  read back and served at once, while watches resume from the stored resource versions.
+
+  With --socket the mirror is accessible only through the Unix socket, which can be
+  used by the current user only. Otherwise anyone who can reach the address and port
+  can read names of the mirrored objects.
+
+  A shared mirror can restrict what each client sees. With --authz-file clients are
+  identified by their bearer tokens or client certificates, and see only the servers
+  and namespaces given for them in the file. With --authz-access-review clients send
+  their own Kubernetes tokens with --token-file, and see only the objects that the
+  API servers allow them to list.
 
   By default, "get pod" returns pods from all servers and all namespaces.
   See help for "get" command to know how to filter.
 
 EXAMPLE:
   kubemrr -a 0.0.0.0 -p 33033 watch dev-context prod-context
+  kubemrr -a 0.0.0.0 -p 33033 watch 'prod-*'
+  kubemrr -a 0.0.0.0 -p 33033 watch --all-contexts
   kubemrr -a 0.0.0.0 -p 33033 get pod
+  kubemrr --socket ~/.kubemrr/kubemrr.sock watch --all-contexts
 
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -43,29 +71,54 @@ EXAMPLE:
 	}
 
 	AddCommonFlags(watchCmd)
+	AddEndpointSecurityFlags(watchCmd)
+	AddBackoffFlags(watchCmd)
 	watchCmd.Flags().Duration("interval", 2*time.Minute, "Interval between requests to the server")
-	watchCmd.Flags().String("only", "", "Coma-separated names of resources to watch, empty to watch all supported")
+	watchCmd.Flags().MarkDeprecated("interval", "objects are listed once and then watched for changes")
+	watchCmd.Flags().String("only", "", "Coma-separated names of resources to watch, empty to watch all discovered")
+	watchCmd.Flags().String("exclude", "events", "Coma-separated names of resources not to watch")
+	watchCmd.Flags().String("annotations", "", "Comma-separated keys of annotations to keep with objects, glob patterns are allowed")
+	watchCmd.Flags().Bool("all-contexts", false, "Mirror servers of all contexts in kubeconfig")
+	watchCmd.Flags().String("cache-file", "~/.kubemrr/cache.json", "File where mirrored objects are kept between restarts, empty to disable")
+	watchCmd.Flags().Duration("cache-interval", time.Minute, "Interval between writes of the cache file")
+	watchCmd.Flags().String("authz-file", "", "File that maps clients to the servers and namespaces they may see")
+	watchCmd.Flags().Bool("authz-access-review", false, "Let clients see only what their own Kubernetes tokens allow to list")
 	return watchCmd
 }
 
 func RunWatch(f Factory, cmd *cobra.Command, args []string) error {
-	if len(args) < 1 {
+	allContexts, err := cmd.Flags().GetBool("all-contexts")
+	if err != nil {
+		return errors.New("could not parse value of --all-contexts")
+	}
+	if len(args) < 1 && !allContexts {
 		return errors.New("at least one argument is required, either url or context name")
 	}
 
-	bind, err := GetBind(cmd)
+	endpoint, err := GetEndpoint(cmd)
 	if err != nil {
-		return fmt.Errorf("unexpected error: %s", err)
+		return err
 	}
+	bind := endpoint.Address
 
-	l, err := net.Listen("tcp", bind)
+	tlsConfig, err := endpoint.serverTLSConfig()
 	if err != nil {
-		return fmt.Errorf("failed to bind on %s: %v", bind, err)
+		return err
+	}
+	token, err := endpoint.token()
+	if err != nil {
+		return err
+	}
+	if token != "" && !endpoint.TLS && endpoint.Network != "unix" {
+		return errors.New("--token-file requires --tls or --socket, clients send the token to the mirror")
 	}
 
-	interval, err := cmd.Flags().GetDuration("interval")
+	l, err := listen(endpoint.Network, bind)
 	if err != nil {
-		return errors.New("could not parse value of --interval")
+		return fmt.Errorf("failed to bind on %s: %v", bind, err)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
 	}
 
 	enabledResources, err := cmd.Flags().GetString("only")
@@ -73,128 +126,572 @@ func RunWatch(f Factory, cmd *cobra.Command, args []string) error {
 		return errors.New("could not parse value of --only")
 	}
 
-	clients := make([]KubeClient, len(args))
-	c := f.MrrCache()
+	excludedResources, err := cmd.Flags().GetString("exclude")
+	if err != nil {
+		return errors.New("could not parse value of --exclude")
+	}
+
+	backoff, err := GetBackoff(cmd)
+	if err != nil {
+		return err
+	}
 
-	for i, arg := range args {
-		var config *Config
+	cacheFile, err := cmd.Flags().GetString("cache-file")
+	if err != nil {
+		return errors.New("could not parse value of --cache-file")
+	}
+	cacheFile, err = substituteUserHome(cacheFile)
+	if err != nil {
+		return fmt.Errorf("invalid --cache-file: %v", err)
+	}
+
+	cacheInterval, err := cmd.Flags().GetDuration("cache-interval")
+	if err != nil || cacheInterval <= 0 {
+		return errors.New("--cache-interval must be a positive duration")
+	}
+
+	annotations, err := cmd.Flags().GetString("annotations")
+	if err != nil {
+		return errors.New("could not parse value of --annotations")
+	}
+
+	authzFile, err := cmd.Flags().GetString("authz-file")
+	if err != nil {
+		return errors.New("could not parse value of --authz-file")
+	}
+	accessReview, err := cmd.Flags().GetBool("authz-access-review")
+	if err != nil {
+		return errors.New("could not parse value of --authz-access-review")
+	}
+	if authzFile != "" && accessReview {
+		return errors.New("--authz-file and --authz-access-review cannot be used together")
+	}
+	if accessReview && !endpoint.TLS && endpoint.Network != "unix" {
+		return errors.New("--authz-access-review requires --tls or --socket, clients send their Kubernetes tokens to the mirror")
+	}
+	if authzFile != "" && !endpoint.TLS && endpoint.Network != "unix" {
+		log.Warn("--authz-file is used without --tls or --socket, tokens of clients can be read on the network")
+	}
+	if (authzFile != "" || accessReview) && token != "" {
+		return errors.New("--token-file cannot be used with authorization, clients present their own tokens")
+	}
+	var authzConfig *AuthzConfig
+	if authzFile != "" {
+		if authzFile, err = substituteUserHome(authzFile); err != nil {
+			return fmt.Errorf("invalid --authz-file: %v", err)
+		}
+		if authzConfig, err = readAuthzConfig(authzFile); err != nil {
+			return err
+		}
+	}
+
+	//servers given by URL do not change, contexts are looked up in kubeconfig on each reload
+	servers := make(map[KubeServer]*Config)
+	patterns := []string{}
+	if allContexts {
+		patterns = append(patterns, "*")
+	}
+	for _, arg := range args {
 		if govalidator.IsURL(arg) {
-			config, err = NewConfigFromURL(arg)
+			config, err := NewConfigFromURL(arg)
 			if err != nil {
 				return fmt.Errorf("url %s is not valid: %s", arg, err)
 			}
+			servers[configServer(config)] = config
 		} else {
-			config, err = GetKubeconfig(cmd)
+			patterns = append(patterns, arg)
+		}
+	}
+
+	//configs returns configuration of all servers to mirror
+	configs := func() (map[KubeServer]*Config, error) {
+		res := make(map[KubeServer]*Config)
+		if len(patterns) > 0 {
+			config, err := GetKubeconfig(cmd)
 			if err != nil {
-				return fmt.Errorf("cannot parse kubeconfig file %s: %s", arg, err)
+				return nil, err
 			}
-			context := config.getContext(arg)
-			if context == nil {
-				return fmt.Errorf("cannot find context %s in kubeconfig", arg)
+			for _, p := range patterns {
+				if !isPattern(p) && config.getContext(p) == nil {
+					return nil, fmt.Errorf("cannot find context %s in kubeconfig", p)
+				}
 			}
-			config.CurrentContext = arg
+			res = selectContexts(config, patterns)
+		}
+		for server, config := range servers {
+			res[server] = config
 		}
+		return res, nil
+	}
 
-		kc := f.KubeClient(config)
-		log.WithField("server", kc.Server().URL).Info("created client")
-		clients[i] = kc
+	initial, err := configs()
+	if err != nil {
+		return err
 	}
+	if len(initial) == 0 {
+		log.Warn("no context matches the arguments, waiting for changes of kubeconfig")
+	}
+
+	//ctx is canceled on SIGINT or SIGTERM, which stops the server and then the mirrors
+	ctx, cancel := notifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	for _, kc := range clients {
-		if err := kc.Ping(); err != nil {
-			return fmt.Errorf("failed to ping server: %s", err)
+	for _, server := range sortedServers(initial) {
+		if err := f.KubeClient(initial[server]).Ping(ctx); err != nil {
+			return fmt.Errorf("failed to ping server %s: %s", server.URL, err)
 		}
 	}
 
-	for _, kc := range clients {
-		for _, k := range []string{"pod"} {
-			if isWatching(k, enabledResources) {
-				loopWatchObjects(c, kc, k)
-			}
+	//mirrors are stopped only after the server, so that clients are served until the end
+	mirrorsCtx, stopMirrors := context.WithCancel(context.Background())
+	defer stopMirrors()
+
+	c := f.MrrCache()
+	if annotations != "" {
+		c.keepAnnotations(strings.Split(annotations, ","))
+	}
+	snapshotsCtx, stopSnapshots := context.WithCancel(context.Background())
+	defer stopSnapshots()
+	var snapshotsDone <-chan struct{}
+	if cacheFile != "" {
+		snapshot, err := readSnapshot(cacheFile)
+		if err != nil {
+			log.WithField("error", err).Warn("could not read cache file, starting with empty cache")
+		} else if snapshot != nil {
+			c.loadSnapshot(snapshot)
 		}
+		snapshotsDone = loopSaveSnapshots(snapshotsCtx, c, cacheFile, cacheInterval)
+	}
 
-		for _, k := range []string{"service", "deployment", "configmap", "namespace", "node"} {
-			if isWatching(k, enabledResources) {
-				loopGetObjects(c, kc, k, interval)
+	s := newSupervisor(mirrorsCtx, f, c, enabledResources, excludedResources, backoff)
+	s.sync(initial)
+
+	if len(patterns) > 0 {
+		files, err := GetKubeconfigFiles(cmd)
+		if err != nil {
+			return err
+		}
+
+		current := initial
+		reload := func() []string {
+			log.Info("kubeconfig has changed, reloading")
+			next, err := configs()
+			if err != nil {
+				log.WithField("error", err).Warn("could not reload kubeconfig, servers are not changed")
+			} else {
+				s.sync(next)
+				current = next
 			}
+			return append(files, certificateFiles(current)...)
+		}
+
+		if err := watchFiles(ctx, append(files, certificateFiles(current)...), reload); err != nil {
+			log.WithField("error", err).Warn("could not watch kubeconfig for changes")
 		}
 	}
 
-	log.WithField("bind", bind).Info("started to listen")
-	err = f.Serve(l, c)
+	handler := NewMirrorHandler(c)
+	switch {
+	case authzConfig != nil:
+		handler = NewAuthorizedMirrorHandler(c, authzConfig)
+	case accessReview:
+		handler = NewAuthorizedMirrorHandler(c, newAccessReviews(s.clients))
+	case token != "":
+		handler = requireToken(token, handler)
+	}
+
+	log.WithField("bind", bind).WithField("tls", endpoint.TLS).Info("started to listen")
+	err = f.Serve(ctx, l, handler)
+	log.Info("stopping")
+
+	//the cache is written before the mirrors remove their objects from it
+	stopSnapshots()
+	if snapshotsDone != nil {
+		<-snapshotsDone
+	}
+	s.stopAll()
+
 	if err != nil {
 		return fmt.Errorf("unexpected error: %v", err)
 	}
+	log.Info("kubemrr has stopped")
+	return nil
+}
+
+//mirror keeps the loops that put objects of one API server into the cache
+type mirror struct {
+	cache   *MrrCache
+	kc      KubeClient
+	only    string
+	exclude string
+	backoff Backoff
+
+	//ctx is canceled when the mirror is stopped, the loops of the mirror use contexts derived from it
+	ctx    context.Context
+	cancel context.CancelFunc
+	loops  map[resourceKey]mirrorLoop
+	//stopping are the loops which are stopped but may not have removed their objects yet
+	stopping map[resourceKey]mirrorLoop
+	//custom are the resources defined by CRDs, they are stopped when their definitions disappear
+	custom map[resourceKey]KubeResource
+	//keep is closed when the loops are stopped to hand the objects over to another mirror
+	keep     chan struct{}
+	shutdown bool
+	mu       *sync.Mutex
+}
+
+type mirrorLoop struct {
+	resource KubeResource
+	cancel   context.CancelFunc
+	done     <-chan struct{}
+}
+
+func newMirror(ctx context.Context, c *MrrCache, kc KubeClient, only string, exclude string, b Backoff) *mirror {
+	ctx, cancel := context.WithCancel(ctx)
+	return &mirror{
+		cache:    c,
+		kc:       kc,
+		only:     only,
+		exclude:  exclude,
+		backoff:  b,
+		ctx:      ctx,
+		cancel:   cancel,
+		loops:    make(map[resourceKey]mirrorLoop),
+		stopping: make(map[resourceKey]mirrorLoop),
+		custom:   make(map[resourceKey]KubeResource),
+		keep:     make(chan struct{}),
+		mu:       &sync.Mutex{},
+	}
+}
+
+//start starts to mirror the resource, unless it is already mirrored or is not enabled by the flags.
+//If the resource has just been stopped, the new loop starts after the stopped one removes its objects
+func (m *mirror) start(r KubeResource) {
+	if !isWatching(r, m.only) || isExcluded(r, m.exclude) {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		if _, ok := m.loops[r.key()]; ok || m.shutdown {
+			return
+		}
+		stopped, ok := m.stopping[r.key()]
+		if !ok {
+			break
+		}
+		m.mu.Unlock()
+		<-stopped.done
+		m.mu.Lock()
+		if m.stopping[r.key()].done == stopped.done {
+			delete(m.stopping, r.key())
+		}
+	}
 
-	return errors.New("kubemrr has stopped")
+	log.
+		WithField("server", m.kc.Server().URL).
+		WithField("kind", r.Singular).
+		WithField("group", r.Group).
+		Info("started to mirror resource")
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.cache.addResource(m.kc.Server(), r)
+	done := loopWatchObjects(ctx, m.cache, m.kc, r, m.backoff, m.keep)
+	m.loops[r.key()] = mirrorLoop{r, cancel, done}
 }
 
-func isWatching(r string, rs string) bool {
-	return len(rs) == 0 || strings.Contains(rs, r)
+//startCustom starts to mirror the resource defined by a CRD. The resource is mirrored again
+//when its definition has changed how it is served, e.g. its storage version
+func (m *mirror) startCustom(r KubeResource) {
+	m.mu.Lock()
+	loop, ok := m.loops[r.key()]
+	m.mu.Unlock()
+	if ok && !loop.resource.servedAs(r) {
+		log.
+			WithField("server", m.kc.Server().URL).
+			WithField("kind", r.Singular).
+			WithField("group", r.Group).
+			WithField("version", r.Version).
+			Info("definition of resource has changed, restarting")
+		m.stop(loop.resource)
+	}
+
+	m.mu.Lock()
+	m.custom[r.key()] = r
+	m.mu.Unlock()
+	m.start(r)
 }
 
-func loopWatchObjects(c *MrrCache, kc KubeClient, kind string) {
-	events := make(chan *ObjectEvent)
-	l := log.WithField("kind", kind).WithField("server", kc.Server().URL)
+//customResources returns the resources defined by CRDs
+func (m *mirror) customResources() []KubeResource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]KubeResource, 0, len(m.custom))
+	for _, r := range m.custom {
+		res = append(res, r)
+	}
+	return res
+}
 
-	watch := func() {
-		for {
-			l.Info("started to watch")
-			err := kc.WatchObjects(kind, events)
-			fields := log.Fields{}
-			if err != nil {
-				fields["error"] = err.Error()
-			}
-			l.WithFields(fields).Info("watch connection was closed, retrying")
-			c.deleteKubeObjects(kc.Server(), kind)
+//stop stops to mirror the resource. Objects of the resource are removed from the cache by the stopped loop
+func (m *mirror) stop(r KubeResource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.custom, r.key())
+	loop, ok := m.loops[r.key()]
+	if !ok {
+		return
+	}
+
+	log.
+		WithField("server", m.kc.Server().URL).
+		WithField("kind", r.Singular).
+		WithField("group", r.Group).
+		Info("stopped to mirror resource")
+	loop.cancel()
+	delete(m.loops, r.key())
+	m.stopping[r.key()] = loop
+	go func(key resourceKey) {
+		<-loop.done
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.stopping[key].done == loop.done {
+			delete(m.stopping, key)
 		}
+	}(r.key())
+	m.cache.removeResource(m.kc.Server(), r)
+}
+
+//stopAll stops all loops of the server and waits until they remove their objects from the cache.
+//The mirror cannot be started again
+func (m *mirror) stopAll() {
+	if _, ok := m.stopLoops(false); !ok {
+		return
 	}
+	m.cache.deleteServer(m.kc.Server())
+	log.WithField("server", m.kc.Server().URL).Info("stopped to mirror server")
+}
 
-	update := func() {
-		for {
-			select {
-			case e := <-events:
-				l.
-					WithField("name", e.Object.Name).
-					WithField("type", e.Type).
-					Info("received event")
-				switch e.Type {
-				case Deleted:
-					c.deleteKubeObject(kc.Server(), *e.Object)
-				case Added, Modified:
-					c.updateKubeObject(kc.Server(), *e.Object)
-				}
-				l.WithField("cache", c.objects).Debugf("objects in cache")
-			}
+//handOver stops all loops of the server, but leaves their objects in the cache for the mirror
+//which takes over the server. It returns the resources of the stopped loops.
+//The mirror cannot be started again
+func (m *mirror) handOver() []KubeResource {
+	rs, _ := m.stopLoops(true)
+	log.WithField("server", m.kc.Server().URL).Info("handed over server")
+	return rs
+}
+
+//stopLoops stops all loops and waits until they end. It returns the resources of the loops,
+//and false if the mirror has already been stopped
+func (m *mirror) stopLoops(keep bool) ([]KubeResource, bool) {
+	m.mu.Lock()
+	if m.shutdown {
+		m.mu.Unlock()
+		return nil, false
+	}
+	m.shutdown = true
+	if keep {
+		close(m.keep)
+	}
+	m.cancel()
+	loops := m.loops
+	m.loops = make(map[resourceKey]mirrorLoop)
+	stopping := m.stopping
+	m.stopping = make(map[resourceKey]mirrorLoop)
+	m.mu.Unlock()
+
+	rs := []KubeResource{}
+	for _, loop := range loops {
+		<-loop.done
+		rs = append(rs, loop.resource)
+	}
+	//loops stopped before may leave their objects as well
+	for _, loop := range stopping {
+		<-loop.done
+		rs = append(rs, loop.resource)
+	}
+	return rs, true
+}
+
+//dropUnmirrored removes objects of the resources which were handed over by the previous mirror
+//of the server, but are not mirrored by this one
+func (m *mirror) dropUnmirrored(rs []KubeResource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range rs {
+		if _, ok := m.loops[r.key()]; !ok {
+			m.cache.deleteKubeObjects(m.kc.Server(), r)
 		}
 	}
+}
 
-	go watch()
-	go update()
+func isWatching(r KubeResource, rs string) bool {
+	return len(rs) == 0 || matchesAny(r, rs)
+}
+
+func isExcluded(r KubeResource, rs string) bool {
+	return len(rs) > 0 && matchesAny(r, rs)
+}
+
+//matchesAny tells whether the resource can be referred by any of the coma-separated names
+func matchesAny(r KubeResource, names string) bool {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && r.matches(name) {
+			return true
+		}
+	}
+	return false
 }
 
-func loopGetObjects(c *MrrCache, kc KubeClient, kind string, interval time.Duration) {
-	l := log.WithField("kind", kind).WithField("server", kc.Server().URL)
+//minWatchDuration is how long a watch connection must stay open to be resumed at once,
+//when the server closes it without sending any events
+var minWatchDuration = 10 * time.Second
+
+//loopWatchObjects lists objects of the given resource, and then watches for their changes
+//starting from the resource version of the list. When the watch connection is closed,
+//the watch is resumed from the last seen resource version. Objects are listed again only when
+//the resource version becomes too old to watch from, and the cached objects are kept until then.
+//Objects restored from the cache file are served as stale until the server confirms them.
+//Failed requests are retried after a delay given by the backoff.
+//When the context is canceled, the objects are removed from the cache, unless keep is closed,
+//and the returned channel is closed
+func loopWatchObjects(ctx context.Context, c *MrrCache, kc KubeClient, r KubeResource, b Backoff, keep <-chan struct{}) <-chan struct{} {
+	l := log.WithField("kind", r.Singular).WithField("group", r.Group).WithField("server", kc.Server().URL)
+
+	//watch applies events to the cache and returns the last seen resource version
+	//with the number of received events
+	watch := func(resourceVersion string) (string, int, error) {
+		events := make(chan *ObjectEvent)
+		done := make(chan int)
+		last := resourceVersion
+		go func() {
+			n := 0
+			for e := range events {
+				applyEvent(c, kc.Server(), e)
+				c.monitor.received(kc.Server(), r, e.Type)
+				if e.Object.ResourceVersion != "" {
+					last = e.Object.ResourceVersion
+					c.confirm(kc.Server(), r, last)
+					c.monitor.synced(kc.Server(), r)
+				}
+				n++
+			}
+			done <- n
+		}()
+
+		l.WithField("resourceVersion", resourceVersion).Info("started to watch")
+		c.monitor.watchStarted(kc.Server(), r)
+		err := kc.WatchObjects(ctx, r, resourceVersion, events)
+		close(events)
+		n := <-done
+		c.monitor.watchStopped(kc.Server(), r, err)
+		return last, n, err
+	}
+
+	done := make(chan struct{})
+	c.monitor.loopStarted(kc.Server(), r)
 	update := func() {
-		for {
-			l.Info("updating objects")
-			objects, err := kc.GetObjects(kind)
-			if err != nil {
-				l.WithField("error", err).Error("unexpected error while updating objects")
-				time.Sleep(10 * time.Second)
-				continue
+		//objects restored from the cache file are kept, and the watch is resumed from their version
+		resourceVersion := c.restore(kc.Server(), r)
+		for !isStopped(ctx) {
+			if resourceVersion == "" {
+				l.Info("listing objects")
+				start := time.Now()
+				list, err := kc.GetObjects(ctx, r)
+				c.monitor.listed(kc.Server(), r, time.Since(start), err)
+				if err != nil {
+					retry(ctx, l.WithField("error", err), "failed to list objects", &b)
+					continue
+				}
+
+				if isStopped(ctx) {
+					break
+				}
+
+				b.Reset()
+				l.WithField("objects", list.Objects).Debug("received objects")
+				c.replaceKubeObjects(kc.Server(), r, list.Objects)
+				c.confirm(kc.Server(), r, list.ResourceVersion)
+				c.monitor.synced(kc.Server(), r)
+				l.Infof("put %d objects into cache", len(list.Objects))
+				resourceVersion = list.ResourceVersion
 			}
 
-			l.WithField("objects", objects).Debug("received objects")
-			c.deleteKubeObjects(kc.Server(), kind)
-			for i := range objects {
-				c.updateKubeObject(kc.Server(), objects[i])
+			var n int
+			var err error
+			start := time.Now()
+			resourceVersion, n, err = watch(resourceVersion)
+			switch {
+			case isStopped(ctx):
+			case isGone(err):
+				l.WithField("error", err).Info("resource version is too old, listing objects again")
+				resourceVersion = ""
+			case err != nil:
+				retry(ctx, l.WithField("error", err), "watch connection failed", &b)
+			case n == 0 && time.Since(start) < minWatchDuration:
+				//a server that closes watch connections at once must not be flooded with requests,
+				//but quiet resources are watched again at once after the server's timeout
+				retry(ctx, l, "watch connection was closed without events", &b)
+			default:
+				b.Reset()
+				c.confirm(kc.Server(), r, resourceVersion)
+				c.monitor.synced(kc.Server(), r)
+				l.Info("watch connection was closed, resuming")
 			}
-			l.Infof("put %d objects into cache", len(objects))
+		}
 
-			time.Sleep(interval)
+		select {
+		case <-keep:
+		default:
+			c.deleteKubeObjects(kc.Server(), r)
 		}
+		c.monitor.loopStopped(kc.Server(), r)
+		l.Info("stopped updating objects")
+		close(done)
 	}
 
 	go update()
+	return done
+}
+
+//retry logs the failure and pauses the loop for the delay given by the backoff
+func retry(ctx context.Context, l *log.Entry, msg string, b *Backoff) {
+	d := b.Next()
+	l.
+		WithField("attempt", b.Attempts()).
+		WithField("delay", d.String()).
+		Warn(msg + ", retrying")
+	sleep(ctx, d)
+}
+
+func applyEvent(c *MrrCache, server KubeServer, e *ObjectEvent) {
+	if e.Type == Bookmark {
+		return
+	}
+
+	log.
+		WithField("server", server.URL).
+		WithField("kind", e.Object.Kind).
+		WithField("name", e.Object.Name).
+		WithField("type", e.Type).
+		Info("received event")
+	switch e.Type {
+	case Deleted:
+		c.deleteKubeObject(server, *e.Object)
+	case Added, Modified:
+		c.updateKubeObject(server, *e.Object)
+	}
+}
+
+//sleep pauses the loop for the given duration, or until the context is canceled
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func isStopped(ctx context.Context) bool {
+	return ctx.Err() != nil
 }
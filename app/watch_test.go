@@ -1,12 +1,15 @@
 package app
 
 import (
-	"fmt"
+	"context"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
-	"math/rand"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 	"time"
 )
@@ -58,19 +61,20 @@ func TestRunWatch(t *testing.T) {
 
 	cmd := NewWatchCommand(f)
 	cmd.Flags().Set("port", "0")
-	cmd.Flags().Set("interval", "3ms")
 	go cmd.RunE(cmd, servers)
 	time.Sleep(50 * time.Millisecond)
 
-	for s, kc := range f.kubeClients {
-		for _, kind := range []string{"pod"} {
-			if kc.watchObjectHits[kind] != 1 {
-				t.Errorf("Unexpected number of WatchObject requests for [%s] server [%s]: %v", kind, s, kc.watchObjectHits)
+	for _, kc := range f.clients() {
+		s := kc.Server().URL
+		watches, gets := kc.hits()
+		for _, kind := range []string{"pod", "configmap", "namespace", "service", "deployment.extensions", "node"} {
+			if kc.watchHits(kind) != 1 {
+				t.Errorf("Unexpected number of WatchObject requests for [%s] server [%s]: %v", kind, s, watches)
 			}
 		}
-		for _, kind := range []string{"configmap", "namespace", "service", "deployment", "node"} {
-			if kc.getObjectHits[kind] < 3 {
-				t.Errorf("Expected at least 3 GetObject requests for [%s] server [%s], hits were %v", kind, s, kc.getObjectHits)
+		for _, kind := range []string{"pod", "configmap", "namespace", "service", "deployment.extensions", "node"} {
+			if kc.getHits(kind) != 1 {
+				t.Errorf("Unexpected number of GetObject requests for [%s] server [%s]: %v", kind, s, gets)
 			}
 		}
 	}
@@ -80,12 +84,106 @@ func TestRunWatchFailedPing(t *testing.T) {
 	kc := NewTestKubeClient()
 	f := NewTestFactory()
 	f.kubeClients[kc.Server().URL] = kc
+	f.serveStop = make(chan struct{})
+	close(f.serveStop)
 
 	cmd := NewWatchCommand(f)
 	cmd.Flags().Set("port", "0")
+	cmd.Flags().Set("cache-file", "")
 	cmd.RunE(cmd, []string{kc.Server().URL})
 
-	assert.Equal(t, kc.pings, 1, "must have pinged server")
+	assert.Equal(t, kc.counter(&kc.pings), 1, "must have pinged server")
+}
+
+func TestRunWatchAccessReviewRequiresTLS(t *testing.T) {
+	f := NewTestFactory()
+	cmd := NewWatchCommand(f)
+	cmd.Flags().Set("port", "0")
+	cmd.Flags().Set("cache-file", "")
+	cmd.Flags().Set("authz-access-review", "true")
+
+	err := cmd.RunE(cmd, []string{"http://a.com"})
+	if assert.Error(t, err, "must not accept Kubernetes tokens over plain TCP") {
+		assert.Contains(t, err.Error(), "--tls or --socket")
+	}
+}
+
+func TestRunWatchTokenRequiresTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	token := filepath.Join(dir, "token")
+	ioutil.WriteFile(token, []byte("secret"), 0600)
+
+	f := NewTestFactory()
+	cmd := NewWatchCommand(f)
+	cmd.Flags().Set("port", "0")
+	cmd.Flags().Set("cache-file", "")
+	cmd.Flags().Set("token-file", token)
+
+	err = cmd.RunE(cmd, []string{"http://a.com"})
+	if assert.Error(t, err, "must not accept the token over plain TCP") {
+		assert.Contains(t, err.Error(), "--tls or --socket")
+	}
+}
+
+func TestRunWatchShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubemrr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cache.json")
+
+	kc := NewTestKubeClient()
+	kc.resourceVersion = "10"
+	kc.objects = []KubeObject{{TypeMeta: TypeMeta{Kind: "pod"}, ObjectMeta: ObjectMeta{Name: "p1"}}}
+	f := NewTestFactory()
+	f.kubeClients[kc.Server().URL] = kc
+	f.serveStop = make(chan struct{})
+
+	cmd := NewWatchCommand(f)
+	cmd.Flags().Set("port", "0")
+	cmd.Flags().Set("only", "pod")
+	cmd.Flags().Set("cache-file", file)
+	done := make(chan error)
+	go func() {
+		done <- cmd.RunE(cmd, []string{kc.Server().URL})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, kc.watches())
+
+	close(f.serveStop)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("watch has not stopped")
+	}
+
+	assert.Equal(t, 0, kc.watches(), "must close watches")
+	snapshot, err := readSnapshot(file)
+	if assert.NoError(t, err) && assert.NotNil(t, snapshot) {
+		assert.Equal(t, 1, len(snapshot.Servers), "must write cache before exit")
+	}
+}
+
+func TestRunWatchAnnotations(t *testing.T) {
+	kc := NewTestKubeClient()
+	f := NewTestFactory()
+	f.kubeClients[kc.Server().URL] = kc
+	f.serveStop = make(chan struct{})
+	close(f.serveStop)
+
+	cmd := NewWatchCommand(f)
+	cmd.Flags().Set("port", "0")
+	cmd.Flags().Set("cache-file", "")
+	cmd.Flags().Set("annotations", "owner,example.com/*")
+	cmd.RunE(cmd, []string{kc.Server().URL})
+
+	assert.Equal(t, []string{"owner", "example.com/*"}, f.mrrCache.annotations)
 }
 
 func TestRunWatchContextMode(t *testing.T) {
@@ -98,39 +196,53 @@ func TestRunWatchContextMode(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 
 	//copied from kubeconfig_valid file
-	expectedURLs := []string{"https://foo.com", "https://bar.com"}
+	expectedURLs := []string{"https://bar.com", "https://foo.com"}
 	actualURLs := []string{}
-	for _, kc := range f.kubeClients {
+	for _, kc := range f.clients() {
 		actualURLs = append(actualURLs, kc.baseURL.String())
 	}
+	sort.Strings(actualURLs)
 
 	assert.Equal(t, expectedURLs, actualURLs)
 }
 
+func TestRunWatchAllContexts(t *testing.T) {
+	f := NewTestFactory()
+	cmd := NewWatchCommand(f)
+	cmd.Flags().Set("port", "0")
+	cmd.Flags().Set("kubeconfig", "test_data/kubeconfig_valid")
+	cmd.Flags().Set("all-contexts", "true")
+
+	go cmd.RunE(cmd, []string{})
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, []string{"https://bar.com", "https://foo.com"}, testFactoryServers(f))
+}
+
 func TestRunWatchWithOnlyFlag(t *testing.T) {
 	f := NewTestFactory()
 	cmd := NewWatchCommand(f)
 	cmd.Flags().Set("port", "0")
-	cmd.Flags().Set("interval", "3ms")
 	cmd.Flags().Set("only", "pod,namespace")
 	go cmd.RunE(cmd, []string{"http://z.org"})
 	time.Sleep(50 * time.Millisecond)
 
-	for _, kc := range f.kubeClients {
-		for kind, hits := range kc.watchObjectHits {
-			if kind == "pod" && hits < 1 {
-				t.Errorf("Expected to hit [%s] at least 3 times, but was [%d]", kind, hits)
+	for _, kc := range f.clients() {
+		watches, gets := kc.hits()
+		for kind, hits := range watches {
+			if (kind == "pod" || kind == "namespace") && hits != 1 {
+				t.Errorf("Expected to hit [%s] once, but was [%d]", kind, hits)
 			}
-			if kind != "pod" && hits > 0 {
+			if kind != "pod" && kind != "namespace" && hits > 0 {
 				t.Errorf("Did not expect to hit [%s]", kind)
 			}
 		}
 
-		for kind, hits := range kc.getObjectHits {
-			if kind == "namespace" && hits < 3 {
-				t.Errorf("Expected to hit [%s] at least 3 times, but was [%d]", kind, hits)
+		for kind, hits := range gets {
+			if (kind == "pod" || kind == "namespace") && hits != 1 {
+				t.Errorf("Expected to hit [%s] once, but was [%d]", kind, hits)
 			}
-			if kind != "namespace" && hits > 0 {
+			if kind != "pod" && kind != "namespace" && hits > 0 {
 				t.Errorf("Did not expect to hit [%s]", kind)
 			}
 		}
@@ -144,18 +256,18 @@ func TestLoopWatchObjectsFailure(t *testing.T) {
 	kc.watchObjectError = errors.New("Test Error")
 	kc.objectEventsF = func() []*ObjectEvent {
 		return []*ObjectEvent{
-			&ObjectEvent{Added, &KubeObject{ObjectMeta: ObjectMeta{Name: "object"}, TypeMeta: TypeMeta{kind}}},
+			&ObjectEvent{Added, &KubeObject{ObjectMeta: ObjectMeta{Name: "object"}, TypeMeta: TypeMeta{Kind: kind}}},
 		}
 	}
 
-	loopWatchObjects(c, kc, kind)
+	loopWatchObjects(context.Background(), c, kc, KubeResource{Singular: kind}, testBackoff, nil)
 
 	time.Sleep(50 * time.Millisecond)
-	if kc.watchObjectHits[kind] < 2 {
+	if kc.watchHits(kind) < 2 {
 		t.Errorf("Not enough WatchObjects calls")
 	}
 
-	x := len(c.objects[kc.Server()])
+	x := len(c.serverObjects(kc.Server()))
 	if x > 1 {
 		t.Errorf("Cache must contain only one object, but contains %d", x)
 	}
@@ -172,45 +284,125 @@ func TestLoopWatchObjects(t *testing.T) {
 		{Modified, &KubeObject{ObjectMeta: ObjectMeta{Name: "pod1", ResourceVersion: "v2"}}},
 		{Added, &KubeObject{ObjectMeta: ObjectMeta{Name: "z"}}},
 		{Deleted, &KubeObject{ObjectMeta: ObjectMeta{Name: "z"}}},
-		{Added, &KubeObject{TypeMeta: TypeMeta{"other"}, ObjectMeta: ObjectMeta{Name: "pod0"}}},
+		{Added, &KubeObject{TypeMeta: TypeMeta{Kind: "other"}, ObjectMeta: ObjectMeta{Name: "pod0"}}},
 	}
 
-	loopWatchObjects(c, kc, "does not matter")
+	loopWatchObjects(context.Background(), c, kc, KubeResource{Singular: "does not matter"}, testBackoff, nil)
 	time.Sleep(50 * time.Millisecond)
 
-	//order matters in slice
-	expected := []KubeObject{*kc.objectEvents[4].Object, *kc.objectEvents[3].Object, *kc.objectEvents[7].Object}
-	actual := c.objects[kc.Server()]
+	expected := []KubeObject{*kc.objectEvents[3].Object, *kc.objectEvents[4].Object, *kc.objectEvents[7].Object}
+	actual := c.serverObjects(kc.Server())
 	if !reflect.DeepEqual(actual, expected) {
 		t.Errorf("Cache version %+v is not equal to expected %+v", actual, expected)
 	}
 }
 
-func TestLoopGetObjects(t *testing.T) {
+func TestLoopWatchObjectsList(t *testing.T) {
 	c := NewMrrCache()
 	kc := NewTestKubeClient()
-	kind := ""
+	kind := "x"
+
+	kc.resourceVersion = "10"
+	kc.objects = []KubeObject{
+		{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{Name: "a1"}},
+		{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{Name: "a2"}},
+	}
+	kc.objectEvents = []*ObjectEvent{
+		{Added, &KubeObject{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{Name: "a3", ResourceVersion: "11"}}},
+		{Deleted, &KubeObject{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{Name: "a1", ResourceVersion: "12"}}},
+	}
+	kc.watchObjectError = errors.New("Test Error")
+	c.updateKubeObject(kc.Server(), KubeObject{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{Name: "stale"}})
+	c.updateKubeObject(kc.Server(), KubeObject{TypeMeta: TypeMeta{Kind: "other"}, ObjectMeta: ObjectMeta{Name: "other"}})
 
-	finalObjects := []KubeObject{
-		{ObjectMeta: ObjectMeta{Name: "a1"}},
-		{ObjectMeta: ObjectMeta{Name: "a2"}},
+	loopWatchObjects(context.Background(), c, kc, KubeResource{Singular: kind}, testBackoff, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	expected := []KubeObject{
+		{TypeMeta: TypeMeta{Kind: "other"}, ObjectMeta: ObjectMeta{Name: "other"}},
+		{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{Name: "a2"}},
+		*kc.objectEvents[0].Object,
 	}
-	kc.objectsF = func() []KubeObject {
-		if kc.getObjectHits[kind] > 2 {
-			return finalObjects
+	assert.Equal(t, expected, c.serverObjects(kc.Server()))
+	assert.Equal(t, 1, kc.getHits(kind), "must list objects only once")
+	assert.Equal(t, []string{"10", "12", "12", "12", "12"}, kc.watchVersions(kind), "must resume watch from the last seen version")
+}
+
+func TestLoopWatchObjectsBookmark(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	kind := "x"
+	kc.resourceVersion = "10"
+	kc.objects = []KubeObject{{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{Name: "a1"}}}
+	kc.objectEvents = []*ObjectEvent{
+		{Bookmark, &KubeObject{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{ResourceVersion: "20"}}},
+	}
+	kc.watchObjectError = errors.New("Test Error")
+
+	loopWatchObjects(context.Background(), c, kc, KubeResource{Singular: kind}, testBackoff, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, kc.objects, c.serverObjects(kc.Server()), "bookmark must not change the cache")
+	assert.Equal(t, []string{"10", "20", "20", "20", "20"}, kc.watchVersions(kind))
+}
+
+func TestLoopWatchObjectsRelistsWhenGone(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	kind := "x"
+	kc.resourceVersion = "10"
+	kc.watchObjectError = &StatusError{Status{Code: 410}}
+
+	loopWatchObjects(context.Background(), c, kc, KubeResource{Singular: kind}, testBackoff, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, 5, kc.getHits(kind), "must list objects after each expired watch")
+	assert.Equal(t, []string{"10", "10", "10", "10", "10"}, kc.watchVersions(kind))
+}
+
+func TestLoopWatchObjectsClosedWithoutEvents(t *testing.T) {
+	defer func(d time.Duration) { minWatchDuration = d }(minWatchDuration)
+	minWatchDuration = 20 * time.Millisecond
+	b := Backoff{Initial: time.Second, Max: time.Second, Factor: 1}
+
+	tests := []struct {
+		closes   time.Duration
+		expected int
+		msg      string
+	}{
+		{time.Millisecond, 1, "watch closed at once must be retried with backoff"},
+		{30 * time.Millisecond, 3, "quiet watch must be resumed at once"},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		kc := NewTestKubeClient()
+		kc.watchObjectCloses = test.closes
+		done := loopWatchObjects(ctx, NewMrrCache(), kc, KubeResource{Singular: "x"}, b, nil)
+		time.Sleep(150 * time.Millisecond)
+		cancel()
+		<-done
+
+		hits := kc.watchHits("x")
+		if test.expected == 1 {
+			assert.Equal(t, 1, hits, test.msg)
 		} else {
-			return []KubeObject{
-				{ObjectMeta: ObjectMeta{Name: fmt.Sprintf("rand-%d", rand.Intn(999))}},
-			}
+			assert.True(t, hits >= test.expected, "%s, watched %d times", test.msg, hits)
 		}
 	}
+}
 
-	loopGetObjects(c, kc, kind, 3*time.Millisecond)
+func TestLoopWatchObjectsStop(t *testing.T) {
+	c := NewMrrCache()
+	kc := NewTestKubeClient()
+	kind := "x"
+	kc.objects = []KubeObject{{TypeMeta: TypeMeta{Kind: kind}, ObjectMeta: ObjectMeta{Name: "x1"}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	loopWatchObjects(ctx, c, kc, KubeResource{Singular: kind}, testBackoff, nil)
 	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, kc.objects, c.serverObjects(kc.Server()))
 
-	actual := c.objects[kc.Server()]
-	expected := finalObjects
-	if !reflect.DeepEqual(actual, expected) {
-		t.Errorf("Expected \n%+v \n Got \n%+v", expected, actual)
-	}
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, c.serverObjects(kc.Server()), "must remove objects when stopped")
 }
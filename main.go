@@ -32,6 +32,8 @@ func init() {
 	RootCmd.AddCommand(app.NewWatchCommand(f))
 	RootCmd.AddCommand(app.NewVersionCommand(f))
 	RootCmd.AddCommand(app.NewCompletionCommand(f))
+	RootCmd.AddCommand(app.NewDaemonCommand(f))
+	RootCmd.AddCommand(app.NewStatusCommand(f))
 }
 
 func main() {
@@ -67,6 +67,35 @@ func k8sNodes(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, ` { "items": [ { "metadata": { "name": "node1" } } ] }`)
 }
 
+func k8sSecrets(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, ` { "items": [ { "metadata": { "name": "secret1" } } ] }`)
+}
+
+func k8sCoreVersions(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, `{ "versions": ["v1"] }`)
+}
+
+func k8sCoreResources(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, `{ "groupVersion": "v1", "resources": [
+		{ "name": "pods", "namespaced": true, "kind": "Pod", "verbs": ["list", "watch"], "shortNames": ["po"] },
+		{ "name": "services", "namespaced": true, "kind": "Service", "verbs": ["list", "watch"], "shortNames": ["svc"] },
+		{ "name": "configmaps", "namespaced": true, "kind": "ConfigMap", "verbs": ["list", "watch"], "shortNames": ["cm"] },
+		{ "name": "namespaces", "namespaced": false, "kind": "Namespace", "verbs": ["list", "watch"], "shortNames": ["ns"] },
+		{ "name": "nodes", "namespaced": false, "kind": "Node", "verbs": ["list", "watch"], "shortNames": ["no"] },
+		{ "name": "secrets", "namespaced": true, "kind": "Secret", "verbs": ["list", "watch"] }
+	]}`)
+}
+
+func k8sGroups(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, `{ "groups": [ { "name": "extensions", "preferredVersion": { "groupVersion": "extensions/v1beta1", "version": "v1beta1" } } ] }`)
+}
+
+func k8sExtensionsResources(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, `{ "groupVersion": "extensions/v1beta1", "resources": [
+		{ "name": "deployments", "namespaced": true, "kind": "Deployment", "verbs": ["list", "watch"], "shortNames": ["deploy"] }
+	]}`)
+}
+
 func ok(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, `OK`)
 }
@@ -77,6 +106,11 @@ func startKubernetesServer() {
 	k8sAddress = k8sServer.URL
 
 	mux.HandleFunc("/", ok)
+	mux.HandleFunc("/api", k8sCoreVersions)
+	mux.HandleFunc("/api/v1", k8sCoreResources)
+	mux.HandleFunc("/apis", k8sGroups)
+	mux.HandleFunc("/apis/extensions/v1beta1", k8sExtensionsResources)
+	mux.HandleFunc("/api/v1/secrets", k8sSecrets)
 	mux.HandleFunc("/api/v1/pods", k8sPods)
 	mux.HandleFunc("/api/v1/services", k8sServices)
 	mux.HandleFunc("/api/v1/configmaps", k8sConfigmaps)
@@ -101,8 +135,6 @@ func TestCommands(t *testing.T) {
 	watchCmd.Flags().Set("port", "39000")
 	go watchCmd.RunE(watchCmd, []string{k8sAddress})
 
-	time.Sleep(1 * time.Millisecond)
-
 	tests := []struct {
 		arg    string
 		output string
@@ -131,11 +163,22 @@ func TestCommands(t *testing.T) {
 			arg:    "node",
 			output: "node1",
 		},
+		{
+			arg:    "secrets",
+			output: "secret1",
+		},
 	}
 
 	for _, test := range tests {
-		buf.Reset()
-		getCmd.RunE(getCmd, []string{test.arg})
+		//the mirror needs some time to start and fill the cache
+		for i := 0; i < 100; i++ {
+			buf.Reset()
+			getCmd.RunE(getCmd, []string{test.arg})
+			if buf.String() == test.output {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
 		if buf.String() != test.output {
 			t.Errorf("Getting [%v]: expected [%v], but received [%v]", test.arg, test.output, buf)
 		}